@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ansel1/merry"
+
+	"github.com/joshsziegler/zauth/pkg/ldap"
+)
+
+// runExport handles `zauth export`, which writes every user, group, and
+// membership as LDIF or JSON (to stdout by default).
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", ldap.FormatLDIF, "output format: ldif or json")
+	includeHashes := flags.Bool("password-hashes", false, "include password hashes (keep the output secret!)")
+	output := flags.String("o", "", "write to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export [options]\n", programName)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		// Only the owner can read the export, since it may contain hashes
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		defer file.Close()
+		w = file
+	}
+	tx, err := DB.Beginx()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer tx.Rollback()
+	return ldap.Export(w, tx, *format, *includeHashes)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ansel1/merry"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zauth/pkg/user"
+)
+
+// runImport handles `zauth import`, which creates users from a CSV or LDIF
+// file. See the web UI's import page for the file formats.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "show what would be imported without changing anything")
+	format := flags.String("format", "", "file format: csv or ldif (default: from the file extension)")
+	sendEmail := flags.String("email", "none", "email new users: welcome, reset, or none")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s import [options] FILE\n", programName)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer file.Close()
+	var rows []user.ImportRow
+	switch *format {
+	case "csv":
+		rows, err = user.ParseImportCSV(file)
+	case "ldif":
+		rows, err = user.ParseImportLDIF(file)
+	default:
+		return merry.Errorf("unknown import format '%s' (must be csv or ldif)", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := DB.Beginx()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer tx.Rollback()
+	var results []user.ImportResult
+	var created []user.User
+	if *dryRun {
+		results, err = user.PlanImport(tx, rows, nil)
+	} else {
+		results, created, err = user.Import(tx, cliActor(), rows, nil)
+	}
+	if err != nil {
+		return err
+	}
+	printImportResults(os.Stdout, results)
+	if *dryRun {
+		fmt.Println("Dry-run: nothing was changed.")
+		return nil
+	}
+	// Queued in this transaction, so they're sent IFF the import is committed.
+	// The server's outbox sends them.
+	err = user.SendImportEmails(tx, created, *sendEmail)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	fmt.Printf("Imported %d users.\n", len(created))
+	if *sendEmail == user.ImportEmailWelcome || *sendEmail == user.ImportEmailReset {
+		fmt.Printf("Queued %d emails, which the server will send.\n", len(created))
+	}
+	return nil
+}
+
+// cliActor returns the audit actor for changes made from the command line,
+// which is the operating system user running zauth.
+func cliActor() audit.Actor {
+	actor := audit.Actor{Username: "unknown", Channel: audit.ChannelCLI}
+	current, err := osuser.Current()
+	if err == nil {
+		actor.Username = current.Username
+	}
+	return actor
+}
+
+// printImportResults writes a table with one line per import row.
+func printImportResults(out io.Writer, results []user.ImportResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tUSERNAME\tEMAIL\tGROUPS\tRESULT")
+	for _, r := range results {
+		result := r.Status
+		if r.Message != "" {
+			result += ": " + r.Message
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.Row.Line, r.Username, r.Row.Email,
+			strings.Join(r.Row.Groups, ","), result)
+	}
+	w.Flush()
+}
@@ -15,6 +15,9 @@ import (
 	"github.com/joshsziegler/zauth/pkg/db"
 	"github.com/joshsziegler/zauth/pkg/email"
 	"github.com/joshsziegler/zauth/pkg/ldap"
+	"github.com/joshsziegler/zauth/pkg/passkey"
+	"github.com/joshsziegler/zauth/pkg/password"
+	"github.com/joshsziegler/zauth/pkg/user"
 )
 
 const (
@@ -33,19 +36,36 @@ var (
 	DB *sqlx.DB
 )
 
-type httpConfig struct {
-	ListenTo string
-}
-
 // Config stores the all server options.
 type Config struct {
-	Production     bool
-	Database       db.Config
-	LDAP           ldap.Config
-	HTTP           httpConfig
+	Production bool
+	Database   db.Config
+	LDAP       ldap.Config
+	HTTP       httpserver.Config
+	WebAuthn   passkey.Config
+	Accounts   user.Config
+	Passwords  password.Config
+	Email      email.Config
+	// SendGridAPIKey is deprecated. Use Email instead. If set, and
+	// Email.Transport isn't, the SendGrid transport is used.
 	SendGridAPIKey string
 }
 
+// redacted returns a copy of the config with its passwords and API keys
+// masked, so it can be logged.
+func (c Config) redacted() Config {
+	mask := func(secret *string) {
+		if *secret != "" {
+			*secret = "*****"
+		}
+	}
+	mask(&c.Database.Password)
+	mask(&c.Email.SendGridAPIKey)
+	mask(&c.Email.SMTP.Password)
+	mask(&c.SendGridAPIKey)
+	return c
+}
+
 // mustLoadConfig loads and returns our configuration from a JSON file or panic.
 func mustLoadConfig() (c Config) {
 	// Read the existing config file from disk
@@ -59,16 +79,93 @@ func mustLoadConfig() (c Config) {
 			panic(merry.Prepend(err, "error parsing JSON from "+configPath))
 		}
 	}
-	log.Infof("Config: %+v\n", c)
+	log.Infof("Config: %+v\n", c.redacted())
 
 	return c
 }
 
+// checkPolicyGroups logs an error for each password group policy whose group
+// doesn't exist, since a misspelled name would otherwise silently do nothing.
+func checkPolicyGroups(database *sqlx.DB, policies map[string]password.Policy) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	tx, err := database.Beginx()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer tx.Rollback()
+	groups, err := user.GetGroupsSliceWithoutUsers(tx)
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		exists[g.Name] = true
+	}
+	for name := range policies {
+		if !exists[name] {
+			log.Errorf("Passwords.GroupPolicies has a policy for '%s', which "+
+				"isn't a group, so it won't be used", name)
+		}
+	}
+	return nil
+}
+
+// runCommand runs one of our command line sub-commands (e.g. import).
+func runCommand(command string, args []string) error {
+	switch command {
+	case "import":
+		return runImport(args)
+	case "export":
+		return runExport(args)
+	}
+	return merry.Errorf("unknown command '%s' (must be import or export)", command)
+}
+
 func main() {
 	log.Infof("%s %s (Built: %s)", programName, Version, BuildDate)
 	config = mustLoadConfig()
 	DB = db.MustConnect(config.Database)
-	email.Init(config.SendGridAPIKey)
-	go httpserver.Listen(DB, config.HTTP.ListenTo, config.Production)
-	ldap.Listen(DB, config.LDAP) // blocking
+	if config.Email.Transport == "" && config.SendGridAPIKey != "" {
+		config.Email.Transport = email.TransportSendGrid
+		config.Email.SendGridAPIKey = config.SendGridAPIKey
+	}
+	err := email.Init(config.Email)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ldap.Init(config.LDAP)
+	if config.Production && config.Accounts.SiteURL == "" {
+		log.Fatal("Accounts.SiteURL is required in production, so links in " +
+			"emails work")
+	}
+	err = user.Init(config.Accounts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = password.Init(config.Passwords)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = checkPolicyGroups(DB, config.Passwords.GroupPolicies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Run a one-off command instead of the servers if one was given
+	if len(os.Args) > 1 {
+		err = runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	err = passkey.Init(config.WebAuthn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go user.RunExpiryJob(DB)
+	go email.RunOutbox(DB)
+	go httpserver.Listen(DB, config.HTTP, config.Production)
+	ldap.Listen(DB) // blocking
 }
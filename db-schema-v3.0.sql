@@ -0,0 +1,370 @@
+-- zauth database schema, version 3.0. Use this to create a new database, or
+-- db-schema-v3.upgrade.sql to upgrade an existing version 2.0 database.
+
+/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET @OLD_CHARACTER_SET_RESULTS=@@CHARACTER_SET_RESULTS */;
+/*!40101 SET @OLD_COLLATION_CONNECTION=@@COLLATION_CONNECTION */;
+/*!40101 SET NAMES utf8 */;
+/*!40103 SET @OLD_TIME_ZONE=@@TIME_ZONE */;
+/*!40103 SET TIME_ZONE='+00:00' */;
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+
+--
+-- Table structure for table `AuditEvents`
+--
+
+DROP TABLE IF EXISTS `AuditEvents`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `AuditEvents` (
+  `ID` bigint(20) NOT NULL AUTO_INCREMENT,
+  `Time` datetime NOT NULL,
+  `Actor` varchar(64) NOT NULL DEFAULT '',
+  `Action` varchar(64) NOT NULL,
+  `Target` varchar(255) NOT NULL DEFAULT '',
+  `ValueBefore` varchar(1000) NOT NULL DEFAULT '',
+  `ValueAfter` varchar(1000) NOT NULL DEFAULT '',
+  `IP` varchar(45) NOT NULL DEFAULT '',
+  `Channel` varchar(16) NOT NULL DEFAULT '',
+  PRIMARY KEY (`ID`),
+  KEY `Time` (`Time`),
+  KEY `Actor` (`Actor`,`Time`),
+  KEY `Target` (`Target`,`Time`),
+  KEY `Action` (`Action`,`Time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `EmailOutbox`
+--
+
+DROP TABLE IF EXISTS `EmailOutbox`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `EmailOutbox` (
+  `ID` bigint(20) NOT NULL AUTO_INCREMENT,
+  `Created` datetime NOT NULL,
+  `FromName` varchar(255) NOT NULL DEFAULT '',
+  `FromEmail` varchar(255) NOT NULL,
+  `ToName` varchar(255) NOT NULL DEFAULT '',
+  `ToEmail` varchar(255) NOT NULL,
+  `Subject` varchar(998) NOT NULL DEFAULT '',
+  `Plain` mediumtext NOT NULL,
+  `HTML` mediumtext NOT NULL,
+  `Status` varchar(16) NOT NULL DEFAULT 'pending',
+  `Attempts` int(11) NOT NULL DEFAULT 0,
+  `NextAttempt` datetime NOT NULL,
+  `LastError` varchar(1000) NOT NULL DEFAULT '',
+  `Sent` datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+  PRIMARY KEY (`ID`),
+  KEY `Due` (`Status`,`NextAttempt`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `GroupManagers`
+--
+
+DROP TABLE IF EXISTS `GroupManagers`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `GroupManagers` (
+  `GroupID` int(11) NOT NULL,
+  `UserID` int(11) NOT NULL,
+  `Role` enum('owner','manager') NOT NULL DEFAULT 'manager',
+  PRIMARY KEY (`GroupID`,`UserID`),
+  KEY `UserID` (`UserID`),
+  CONSTRAINT `GroupManagers_ibfk_1` FOREIGN KEY (`GroupID`) REFERENCES `UserGroups` (`ID`) ON DELETE CASCADE,
+  CONSTRAINT `GroupManagers_ibfk_2` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `Passkeys`
+--
+
+DROP TABLE IF EXISTS `Passkeys`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `Passkeys` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `UserID` int(11) NOT NULL,
+  `Name` varchar(100) NOT NULL,
+  `CredentialID` varbinary(1023) NOT NULL,
+  `Credential` blob NOT NULL,
+  `SignCount` int(10) unsigned NOT NULL DEFAULT '0',
+  `Created` datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+  `LastUsed` datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_credential` (`CredentialID`(255)),
+  KEY `UserID` (`UserID`),
+  CONSTRAINT `Passkeys_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `PasswordHistory`
+--
+
+DROP TABLE IF EXISTS `PasswordHistory`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `PasswordHistory` (
+  `ID` bigint(20) NOT NULL AUTO_INCREMENT,
+  `UserID` int(11) NOT NULL,
+  `PasswordHash` varchar(300) NOT NULL,
+  `Created` datetime NOT NULL,
+  PRIMARY KEY (`ID`),
+  KEY `UserID` (`UserID`),
+  CONSTRAINT `PasswordHistory_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `PendingLogins`
+--
+
+DROP TABLE IF EXISTS `PendingLogins`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `PendingLogins` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `UserID` int(11) NOT NULL,
+  `TokenHash` char(64) NOT NULL,
+  `Created` datetime NOT NULL,
+  `Attempts` int(11) NOT NULL DEFAULT 0,
+  `Remember` tinyint(1) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_token_hash` (`TokenHash`),
+  KEY `Created` (`Created`),
+  CONSTRAINT `PendingLogins_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `RecoveryCodes`
+--
+
+DROP TABLE IF EXISTS `RecoveryCodes`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `RecoveryCodes` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `UserID` int(11) NOT NULL,
+  `CodeHash` char(64) NOT NULL,
+  `Used` tinyint(1) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`ID`),
+  KEY `UserID` (`UserID`),
+  CONSTRAINT `RecoveryCodes_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `ReservedUsers`
+--
+
+DROP TABLE IF EXISTS `ReservedUsers`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `ReservedUsers` (
+  `ID` int(11) NOT NULL,
+  `Username` varchar(200) NOT NULL,
+  `Deleted` datetime NOT NULL,
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_username` (`Username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `Role2Group`
+--
+
+DROP TABLE IF EXISTS `Role2Group`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `Role2Group` (
+  `RoleID` int(11) NOT NULL,
+  `GroupID` int(11) NOT NULL,
+  PRIMARY KEY (`RoleID`,`GroupID`),
+  KEY `GroupID` (`GroupID`),
+  CONSTRAINT `Role2Group_ibfk_1` FOREIGN KEY (`RoleID`) REFERENCES `Roles` (`ID`) ON DELETE CASCADE,
+  CONSTRAINT `Role2Group_ibfk_2` FOREIGN KEY (`GroupID`) REFERENCES `UserGroups` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `Role2Permission`
+--
+
+DROP TABLE IF EXISTS `Role2Permission`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `Role2Permission` (
+  `RoleID` int(11) NOT NULL,
+  `Permission` varchar(64) NOT NULL,
+  PRIMARY KEY (`RoleID`,`Permission`),
+  CONSTRAINT `Role2Permission_ibfk_1` FOREIGN KEY (`RoleID`) REFERENCES `Roles` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `Roles`
+--
+
+DROP TABLE IF EXISTS `Roles`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `Roles` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `Name` varchar(32) NOT NULL,
+  `Description` varchar(300) NOT NULL DEFAULT '',
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_name` (`Name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `Sessions`
+--
+
+DROP TABLE IF EXISTS `Sessions`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `Sessions` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `UserID` int(11) NOT NULL,
+  `TokenHash` char(64) NOT NULL,
+  `Created` datetime NOT NULL,
+  `LastUsed` datetime NOT NULL,
+  `Expires` datetime NOT NULL,
+  `Remember` tinyint(1) NOT NULL DEFAULT '0',
+  `IP` varchar(45) NOT NULL DEFAULT '',
+  `UserAgent` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_token_hash` (`TokenHash`),
+  KEY `UserID` (`UserID`),
+  KEY `LastUsed` (`LastUsed`),
+  KEY `Expires` (`Expires`),
+  CONSTRAINT `Sessions_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `User2Group`
+--
+
+DROP TABLE IF EXISTS `User2Group`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `User2Group` (
+  `UserID` int(11) NOT NULL,
+  `GroupID` int(11) NOT NULL,
+  PRIMARY KEY (`UserID`,`GroupID`),
+  KEY `GroupID` (`GroupID`),
+  CONSTRAINT `User2Group_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE,
+  CONSTRAINT `User2Group_ibfk_2` FOREIGN KEY (`GroupID`) REFERENCES `UserGroups` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `UserGroups`
+--
+
+DROP TABLE IF EXISTS `UserGroups`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `UserGroups` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `Name` varchar(200) NOT NULL,
+  `Description` text,
+  `GroupID` int(11) DEFAULT NULL,
+  `Require2FA` tinyint(1) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_name` (`Name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `Users`
+--
+
+DROP TABLE IF EXISTS `Users`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `Users` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `Username` varchar(200) NOT NULL,
+  `FirstName` varchar(200) NOT NULL,
+  `LastName` varchar(200) NOT NULL,
+  `Email` varchar(300) NOT NULL,
+  `PasswordHash` varchar(300) NOT NULL DEFAULT '-',
+  `PasswordSet` datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+  `LastLogin` datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+  `Disabled` tinyint(1) NOT NULL DEFAULT '0',
+  `TOTPSecret` varchar(300) NOT NULL DEFAULT '',
+  `TOTPEnabled` tinyint(1) NOT NULL DEFAULT '0',
+  `TOTPLastStep` bigint(20) NOT NULL DEFAULT '0',
+  `ExpiresAt` datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+  `ExpiryWarned` tinyint(1) NOT NULL DEFAULT '0',
+  `PasswordExpiryWarned` tinyint(1) NOT NULL DEFAULT '0',
+  `ArchivedAt` datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_username` (`Username`),
+  KEY `ExpiresAt` (`ExpiresAt`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `WebAuthnSessions`
+--
+
+DROP TABLE IF EXISTS `WebAuthnSessions`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `WebAuthnSessions` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `TokenHash` char(64) NOT NULL,
+  `Created` datetime NOT NULL,
+  `Data` text NOT NULL,
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_token_hash` (`TokenHash`),
+  KEY `Created` (`Created`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Default roles. To give the members of a group every permission, bind the
+-- 'admin' role to it:
+--   INSERT INTO Role2Group (RoleID, GroupID)
+--     SELECT 1, ID FROM UserGroups WHERE Name='admin';
+--
+
+INSERT INTO `Roles` (`ID`, `Name`, `Description`) VALUES
+  (1,'admin','Full access to users, groups, and roles.'),
+  (2,'helpdesk','View users, and reset their passwords.');
+INSERT INTO `Role2Permission` (`RoleID`, `Permission`) VALUES
+  (1,'audit.read'),
+  (1,'directory.export'),
+  (1,'emails.manage'),
+  (1,'groups.manage'),
+  (1,'roles.manage'),
+  (1,'users.create'),
+  (1,'users.delete'),
+  (1,'users.disable'),
+  (1,'users.edit'),
+  (1,'users.reset_password'),
+  (1,'users.view'),
+  (2,'users.reset_password'),
+  (2,'users.view');
+/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
+/*!40101 SET CHARACTER_SET_RESULTS=@OLD_CHARACTER_SET_RESULTS */;
+/*!40101 SET COLLATION_CONNECTION=@OLD_COLLATION_CONNECTION */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
@@ -0,0 +1,245 @@
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+
+
+-- Two-factor authentication (TOTP). The secret is encrypted by the application.
+ALTER TABLE Users
+	ADD COLUMN TOTPSecret varchar(300) NOT NULL DEFAULT '',
+	ADD COLUMN TOTPEnabled tinyint(1) NOT NULL DEFAULT '0',
+	ADD COLUMN TOTPLastStep bigint(20) NOT NULL DEFAULT '0';
+
+-- Groups may require their members to use two-factor authentication
+ALTER TABLE UserGroups
+	ADD COLUMN Require2FA tinyint(1) NOT NULL DEFAULT '0';
+
+-- One-time recovery codes (SHA-256 hashes only) for two-factor authentication
+CREATE TABLE `RecoveryCodes` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `UserID` int(11) NOT NULL,
+  `CodeHash` char(64) NOT NULL,
+  `Used` tinyint(1) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`ID`),
+  KEY `UserID` (`UserID`),
+  CONSTRAINT `RecoveryCodes_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Users who gave the correct password, but not yet their second factor. Like
+-- Sessions, only the hash of the browser's token is stored. Failed attempts
+-- are counted here, so replaying an old cookie can't reset them.
+CREATE TABLE `PendingLogins` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `UserID` int(11) NOT NULL,
+  `TokenHash` char(64) NOT NULL,
+  `Created` datetime NOT NULL,
+  `Attempts` int(11) NOT NULL DEFAULT 0,
+  `Remember` tinyint(1) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_token_hash` (`TokenHash`),
+  KEY `Created` (`Created`),
+  CONSTRAINT `PendingLogins_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- WebAuthn credentials (passkeys and security keys). Users may have several.
+CREATE TABLE `Passkeys` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `UserID` int(11) NOT NULL,
+  `Name` varchar(100) NOT NULL,
+  `CredentialID` varbinary(1023) NOT NULL,
+  `Credential` blob NOT NULL,
+  `SignCount` int(10) unsigned NOT NULL DEFAULT '0',
+  `Created` datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+  `LastUsed` datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_credential` (`CredentialID`(255)),
+  KEY `UserID` (`UserID`),
+  CONSTRAINT `Passkeys_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- WebAuthn ceremonies (registering or using a passkey) which have begun, but
+-- not finished. Only the hash of the browser's token is stored, and each is
+-- deleted when it's finished, so its challenge can't be replayed.
+CREATE TABLE `WebAuthnSessions` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `TokenHash` char(64) NOT NULL,
+  `Created` datetime NOT NULL,
+  `Data` text NOT NULL,
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_token_hash` (`TokenHash`),
+  KEY `Created` (`Created`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Group owners and managers can change their group's membership without being
+-- admins. Owners can also appoint other owners and managers.
+CREATE TABLE `GroupManagers` (
+  `GroupID` int(11) NOT NULL,
+  `UserID` int(11) NOT NULL,
+  `Role` enum('owner','manager') NOT NULL DEFAULT 'manager',
+  PRIMARY KEY (`GroupID`,`UserID`),
+  KEY `UserID` (`UserID`),
+  CONSTRAINT `GroupManagers_ibfk_1` FOREIGN KEY (`GroupID`) REFERENCES `UserGroups` (`ID`) ON DELETE CASCADE,
+  CONSTRAINT `GroupManagers_ibfk_2` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Roles grant named permissions (e.g. users.create) to the members of the groups
+-- bound to them. This replaces the hardcoded 'admin' group check.
+CREATE TABLE `Roles` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `Name` varchar(32) NOT NULL,
+  `Description` varchar(300) NOT NULL DEFAULT '',
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_name` (`Name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `Role2Permission` (
+  `RoleID` int(11) NOT NULL,
+  `Permission` varchar(64) NOT NULL,
+  PRIMARY KEY (`RoleID`,`Permission`),
+  CONSTRAINT `Role2Permission_ibfk_1` FOREIGN KEY (`RoleID`) REFERENCES `Roles` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `Role2Group` (
+  `RoleID` int(11) NOT NULL,
+  `GroupID` int(11) NOT NULL,
+  PRIMARY KEY (`RoleID`,`GroupID`),
+  KEY `GroupID` (`GroupID`),
+  CONSTRAINT `Role2Group_ibfk_1` FOREIGN KEY (`RoleID`) REFERENCES `Roles` (`ID`) ON DELETE CASCADE,
+  CONSTRAINT `Role2Group_ibfk_2` FOREIGN KEY (`GroupID`) REFERENCES `UserGroups` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Keep the existing 'admin' group's rights by binding it to an 'admin' role with
+-- every permission. Also add a 'helpdesk' role (bound to no groups yet).
+INSERT INTO Roles (Name, Description) VALUES
+	('admin', 'Full access to users, groups, and roles.'),
+	('helpdesk', 'View users, and reset their passwords.');
+INSERT INTO Role2Permission (RoleID, Permission)
+	SELECT Roles.ID, Perms.Permission
+	FROM Roles, (SELECT 'users.view' AS Permission
+				 UNION SELECT 'users.create'
+				 UNION SELECT 'users.edit'
+				 UNION SELECT 'users.disable'
+				 UNION SELECT 'users.reset_password'
+				 UNION SELECT 'groups.manage'
+				 UNION SELECT 'roles.manage'
+				 UNION SELECT 'audit.read') AS Perms
+	WHERE Roles.Name='admin';
+INSERT INTO Role2Permission (RoleID, Permission)
+	SELECT Roles.ID, Perms.Permission
+	FROM Roles, (SELECT 'users.view' AS Permission
+				 UNION SELECT 'users.reset_password') AS Perms
+	WHERE Roles.Name='helpdesk';
+INSERT INTO Role2Group (RoleID, GroupID)
+	SELECT Roles.ID, UserGroups.ID
+	FROM Roles, UserGroups
+	WHERE Roles.Name='admin' AND UserGroups.Name='admin';
+
+-- Admins may export the directory as LDIF or JSON
+INSERT INTO Role2Permission (RoleID, Permission)
+	SELECT ID, 'directory.export' FROM Roles WHERE Name='admin';
+
+-- Sessions are stored server-side so they can be listed and revoked. Only the
+-- hash of each session's token is stored.
+CREATE TABLE `Sessions` (
+  `ID` int(11) NOT NULL AUTO_INCREMENT,
+  `UserID` int(11) NOT NULL,
+  `TokenHash` char(64) NOT NULL,
+  `Created` datetime NOT NULL,
+  `LastUsed` datetime NOT NULL,
+  `Expires` datetime NOT NULL,
+  `Remember` tinyint(1) NOT NULL DEFAULT '0',
+  `IP` varchar(45) NOT NULL DEFAULT '',
+  `UserAgent` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_token_hash` (`TokenHash`),
+  KEY `UserID` (`UserID`),
+  KEY `LastUsed` (`LastUsed`),
+  KEY `Expires` (`Expires`),
+  CONSTRAINT `Sessions_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- AuditEvents records administrative and authentication events. Each is
+-- written in the same transaction as the change it describes.
+CREATE TABLE `AuditEvents` (
+  `ID` bigint(20) NOT NULL AUTO_INCREMENT,
+  `Time` datetime NOT NULL,
+  `Actor` varchar(64) NOT NULL DEFAULT '',
+  `Action` varchar(64) NOT NULL,
+  `Target` varchar(255) NOT NULL DEFAULT '',
+  `ValueBefore` varchar(1000) NOT NULL DEFAULT '',
+  `ValueAfter` varchar(1000) NOT NULL DEFAULT '',
+  `IP` varchar(45) NOT NULL DEFAULT '',
+  `Channel` varchar(16) NOT NULL DEFAULT '',
+  PRIMARY KEY (`ID`),
+  KEY `Time` (`Time`),
+  KEY `Actor` (`Actor`,`Time`),
+  KEY `Target` (`Target`,`Time`),
+  KEY `Action` (`Action`,`Time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Accounts may expire (e.g. contractors). The zero date means never.
+-- ExpiryWarned is set once the expiry warning has been emailed.
+ALTER TABLE Users
+	ADD COLUMN ExpiresAt datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+	ADD COLUMN ExpiryWarned tinyint(1) NOT NULL DEFAULT '0',
+	ADD KEY `ExpiresAt` (`ExpiresAt`);
+
+-- PasswordExpiryWarned is set once the password expiry reminder has been
+-- emailed, and cleared when the password is changed.
+ALTER TABLE Users
+	ADD COLUMN PasswordExpiryWarned tinyint(1) NOT NULL DEFAULT '0';
+
+-- Each user's recent password hashes, so they can't reuse them
+CREATE TABLE `PasswordHistory` (
+  `ID` bigint(20) NOT NULL AUTO_INCREMENT,
+  `UserID` int(11) NOT NULL,
+  `PasswordHash` varchar(300) NOT NULL,
+  `Created` datetime NOT NULL,
+  PRIMARY KEY (`ID`),
+  KEY `UserID` (`UserID`),
+  CONSTRAINT `PasswordHistory_ibfk_1` FOREIGN KEY (`UserID`) REFERENCES `Users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Archived users are kept, but hidden from LDAP and the user list. The zero
+-- date means not archived.
+ALTER TABLE Users
+	ADD COLUMN ArchivedAt datetime NOT NULL DEFAULT '0001-01-01 00:00:00';
+
+-- The IDs (and so Unix IDs) and usernames of deleted users, so neither is ever
+-- given to someone else
+CREATE TABLE `ReservedUsers` (
+  `ID` int(11) NOT NULL,
+  `Username` varchar(200) NOT NULL,
+  `Deleted` datetime NOT NULL,
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `uniq_username` (`Username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Admins may archive and delete users
+INSERT INTO Role2Permission (RoleID, Permission)
+	SELECT ID, 'users.delete' FROM Roles WHERE Name='admin';
+
+-- Admins may preview notification emails, and resend failed ones
+INSERT INTO Role2Permission (RoleID, Permission)
+	SELECT ID, 'emails.manage' FROM Roles WHERE Name='admin';
+
+-- Emails waiting to be sent, or which failed, so a mail server outage doesn't
+-- lose them. They're added in the same transaction as the change they're about.
+CREATE TABLE `EmailOutbox` (
+  `ID` bigint(20) NOT NULL AUTO_INCREMENT,
+  `Created` datetime NOT NULL,
+  `FromName` varchar(255) NOT NULL DEFAULT '',
+  `FromEmail` varchar(255) NOT NULL,
+  `ToName` varchar(255) NOT NULL DEFAULT '',
+  `ToEmail` varchar(255) NOT NULL,
+  `Subject` varchar(998) NOT NULL DEFAULT '',
+  `Plain` mediumtext NOT NULL,
+  `HTML` mediumtext NOT NULL,
+  `Status` varchar(16) NOT NULL DEFAULT 'pending',
+  `Attempts` int(11) NOT NULL DEFAULT 0,
+  `NextAttempt` datetime NOT NULL,
+  `LastError` varchar(1000) NOT NULL DEFAULT '',
+  `Sent` datetime NOT NULL DEFAULT '0001-01-01 00:00:00',
+  PRIMARY KEY (`ID`),
+  KEY `Due` (`Status`,`NextAttempt`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET character_set_client = @saved_cs_client */;
@@ -0,0 +1,90 @@
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zauth/pkg/user"
+)
+
+// auditExportLimit is how many events can be exported as CSV at once.
+const auditExportLimit = 100000
+
+type auditPageData struct {
+	Message        string
+	Error          string
+	RequestingUser user.User
+	Events         []audit.Event
+	// Filter values, as entered, so the form keeps them.
+	Actor  string
+	Action string
+	Target string
+	Since  string
+	Until  string
+	// CSVURL downloads these events as CSV.
+	CSVURL template.URL
+	// Limited is true if there may be more matching events than shown.
+	Limited bool
+}
+
+// auditLog is a sub-handler that shows the audit log, filtered by the query
+// parameters, or downloads it as CSV if format=csv.
+func auditLog(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermAuditRead) {
+		return ErrPermissionDenied.Here()
+	}
+	query := r.URL.Query()
+	data := auditPageData{
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		Actor:          strings.TrimSpace(query.Get("Actor")),
+		Action:         strings.TrimSpace(query.Get("Action")),
+		Target:         strings.TrimSpace(query.Get("Target")),
+		Since:          strings.TrimSpace(query.Get("Since")),
+		Until:          strings.TrimSpace(query.Get("Until")),
+	}
+	filter := audit.Filter{Actor: data.Actor, Action: data.Action,
+		Target: data.Target}
+	var err error
+	filter.Since, err = parseDateInput(data.Since)
+	if err != nil {
+		return err
+	}
+	filter.Until, err = parseDateInput(data.Until)
+	if err != nil {
+		return err
+	}
+	if !filter.Until.IsZero() {
+		// Include the whole day
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+
+	if query.Get("format") == "csv" {
+		filter.Limit = auditExportLimit
+		events, err := audit.Search(c.Tx, filter)
+		if err != nil {
+			return err
+		}
+		filename := fmt.Sprintf("zauth-audit-%s.csv", time.Now().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		w.Header().Set("Cache-Control", "no-store")
+		return audit.WriteCSV(w, events)
+	}
+
+	data.Events, err = audit.Search(c.Tx, filter)
+	if err != nil {
+		return err
+	}
+	data.Limited = len(data.Events) >= audit.DefaultLimit
+	query.Set("format", "csv")
+	data.CSVURL = template.URL("/audit?" + query.Encode())
+	Render(w, "audit.html", data)
+	return nil
+}
@@ -0,0 +1,117 @@
+package httpserver
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zauth/pkg/email"
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+type emailPreviewPageData struct {
+	Message        string
+	Error          string
+	RequestingUser user.User
+	// Names lists every notification email, and Name is the one shown.
+	Names []string
+	Name  string
+	Email email.Message
+}
+
+// emailPreview is a sub-handler that shows one of our notification emails (the
+// first if none is given) as it would be sent to the requesting user.
+func emailPreview(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermEmailsManage) {
+		return ErrPermissionDenied.Here()
+	}
+	name := c.GetRouteVarTrim("name")
+	if name == "" {
+		name = user.EmailNames[0]
+	}
+	found := false
+	for _, n := range user.EmailNames {
+		found = found || n == name
+	}
+	if !found {
+		return ErrNotFound.Here()
+	}
+	message, err := user.PreviewEmail(name, *c.User)
+	if err != nil {
+		return err
+	}
+	data := emailPreviewPageData{
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		Names:          user.EmailNames,
+		Name:           name,
+		Email:          message,
+	}
+	Render(w, "email_preview.html", data)
+	return nil
+}
+
+type emailOutboxPageData struct {
+	Message        string
+	Error          string
+	RequestingUser user.User
+	// Messages are those waiting to be sent, or which failed.
+	Messages  []email.OutboxMessage
+	CSRFField template.HTML
+}
+
+// emailOutbox is a sub-handler that shows the emails waiting to be sent, and
+// those which failed.
+func emailOutbox(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermEmailsManage) {
+		return ErrPermissionDenied.Here()
+	}
+	messages, err := email.GetUnsent(c.Tx)
+	if err != nil {
+		return err
+	}
+	data := emailOutboxPageData{
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		Messages:       messages,
+		CSRFField:      csrf.TemplateField(r),
+	}
+	Render(w, "email_outbox.html", data)
+	return nil
+}
+
+// emailResend is a sub-handler that retries sending a failed email.
+func emailResend(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermEmailsManage) {
+		return ErrPermissionDenied.Here()
+	}
+	id, err := strconv.ParseInt(c.GetRouteVarTrim("id"), 10, 64)
+	if err != nil {
+		return merry.Here(ErrRequestArgument).WithCause(err)
+	}
+	message, err := email.Resend(c.Tx, id)
+	if err != nil {
+		c.AddErrorFlash(merry.UserMessage(err))
+		http.Redirect(w, r, "/emails/outbox", http.StatusFound)
+		return nil
+	}
+	err = audit.Record(c.Tx, c.Actor(), "email.resend", message.ToEmail, "",
+		message.Subject)
+	if err != nil {
+		return err
+	}
+	log.Infof("%s resent email %d to %s", c.User.Username, id, message.ToEmail)
+	c.AddNormalFlash("The email to " + message.ToEmail + " will be sent again.")
+	http.Redirect(w, r, "/emails/outbox", http.StatusFound)
+	return nil
+}
@@ -17,6 +17,9 @@ var (
 			WithUserMessage("Failed to retrieve user record.")
 	ErrGetSecureSession = merry.
 				WithMessage(ErrInternal, "secure session exists, but could not be decoded")
+	ErrNotFound = merry.
+			New("not found").
+			WithUserMessage("Sorry, but that page doesn't exist.")
 	ErrRequestArgument = merry.
 				New("invalid HTTP request argument").
 				WithUserMessage("One or more of your request arguments was invalid.")
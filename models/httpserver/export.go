@@ -0,0 +1,62 @@
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/csrf"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zauth/pkg/ldap"
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+type exportPageData struct {
+	Message        string
+	Error          string
+	RequestingUser user.User
+	CSRFField      template.HTML
+}
+
+// exportDirectory is a sub-handler that shows the export options, and then
+// downloads every user and group as LDIF or JSON.
+func exportDirectory(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermDirectoryExport) {
+		return ErrPermissionDenied.Here()
+	}
+	if r.Method == "GET" {
+		data := exportPageData{
+			Message:        c.NormalFlashMessage,
+			Error:          c.ErrorFlashMessage,
+			RequestingUser: *c.User,
+			CSRFField:      csrf.TemplateField(r),
+		}
+		Render(w, "export.html", data)
+		return nil
+	}
+
+	format := r.FormValue("Format")
+	contentType := "text/plain; charset=utf-8" // LDIF has no registered type
+	if format == ldap.FormatJSON {
+		contentType = "application/json"
+	} else if format != ldap.FormatLDIF {
+		return ErrRequestArgument.Here()
+	}
+	includeHashes := r.FormValue("PasswordHashes") == "true"
+	log.Infof("%s exported the directory as %s (password hashes: %t)",
+		c.User.Username, format, includeHashes)
+	err := audit.Record(c.Tx, c.Actor(), "directory.export", "", "",
+		fmt.Sprintf("%s (password hashes: %t)", format, includeHashes))
+	if err != nil {
+		return err
+	}
+	filename := fmt.Sprintf("zauth-%s.%s", time.Now().Format("20060102-150405"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	return ldap.Export(w, c.Tx, format, includeHashes)
+}
@@ -0,0 +1,95 @@
+package httpserver
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+const (
+	// forgotPasswordSent is ALWAYS shown after a request, whether or not an
+	// account matched, so this page can't be used to find valid accounts.
+	forgotPasswordSent = "If that matches an account, we've sent an email " +
+		"with a link to reset your password. Please check your inbox."
+)
+
+var (
+	// forgotPasswordByAddress limits how many reset emails each email address
+	// receives, so this page can't be used to flood someone's inbox.
+	forgotPasswordByAddress = newRateLimiter(3, time.Hour)
+	// forgotPasswordByIP limits how many requests each client can make.
+	forgotPasswordByIP = newRateLimiter(10, time.Hour)
+)
+
+type forgotPasswordPageData struct {
+	Message   string
+	Error     string
+	CSRFField template.HTML
+}
+
+// ForgotPasswordGetPost lets a user who has forgotten their password request a
+// password reset link by entering their username or email address.
+func ForgotPasswordGetPost(c *Context, w http.ResponseWriter, r *http.Request) error {
+	if c.User != nil {
+		// User is already logged in, so they can simply change their password
+		http.Redirect(w, r, "/users/"+c.User.Username+"/password", http.StatusFound)
+		return nil
+	}
+	data := forgotPasswordPageData{CSRFField: csrf.TemplateField(r),
+		Message: c.NormalFlashMessage, Error: c.ErrorFlashMessage}
+
+	switch r.Method {
+	case "GET":
+		Render(w, "forgot_password.html", data)
+	case "POST":
+		usernameOrEmail := strings.TrimSpace(r.FormValue("UsernameOrEmail"))
+		if usernameOrEmail == "" {
+			data.Error = "Please provide your username or email address."
+			Render(w, "forgot_password.html", data)
+			return nil
+		}
+		ip := clientIP(r)
+		if !forgotPasswordByIP.Allow(ip) {
+			log.Infof("forgot password: rate limit exceeded for %s", ip)
+			data.Error = "Too many requests. Please try again later."
+			Render(w, "forgot_password.html", data)
+			return nil
+		}
+		users, err := user.GetUsersByUsernameOrEmail(c.Tx, usernameOrEmail)
+		if err != nil {
+			return err
+		}
+		for i := range users {
+			u := users[i]
+			if u.Disabled {
+				log.Infof("forgot password: not sending to disabled user %s", u.Username)
+				continue
+			}
+			if u.Expired() {
+				log.Infof("forgot password: not sending to expired user %s", u.Username)
+				continue
+			}
+			if !forgotPasswordByAddress.Allow(u.Email) {
+				log.Infof("forgot password: rate limit exceeded for %s", u.Email)
+				continue
+			}
+			// The outbox sends it in the background, so the response time
+			// doesn't reveal whether an account matched
+			err = u.SendForgotPasswordEmail(c.Tx)
+			if err != nil {
+				log.Errorf("forgot password: failed to queue email to %s: %s", u.Username, err)
+				continue
+			}
+			log.Infof("forgot password: queued reset link to %s", u.Username)
+		}
+		data.Message = forgotPasswordSent
+		Render(w, "forgot_password.html", data)
+	}
+	return nil
+}
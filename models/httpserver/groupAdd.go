@@ -33,7 +33,7 @@ func newFormNewGroup(r *http.Request) formNewGroup {
 // NewGroupGet is a sub-handler that shows the Group creation page.
 func NewGroupGet(c *Context, w http.ResponseWriter, r *http.Request) error {
 	// Check permissions
-	if !c.User.IsAdmin() {
+	if !c.User.Can(user.PermGroupsManage) {
 		return ErrPermissionDenied.Here()
 	}
 	// Handle the request
@@ -45,13 +45,13 @@ func NewGroupGet(c *Context, w http.ResponseWriter, r *http.Request) error {
 // NewGroupPost is a sub-handler that processes the Group creation form.
 func NewGroupPost(c *Context, w http.ResponseWriter, r *http.Request) error {
 	// Check permissions
-	if !c.User.IsAdmin() {
+	if !c.User.Can(user.PermGroupsManage) {
 		return ErrPermissionDenied.Here()
 	}
 	// Handle the request
 	data := newGroupPageData{User: c.User, CSRFField: csrf.TemplateField(r)}
 	form := newFormNewGroup(r)
-	err := user.AddGroup(c.Tx, form.Name, form.Description)
+	err := user.AddGroup(c.Tx, c.Actor(), form.Name, form.Description)
 	if err != nil {
 		data.Form = form // Show current form values along with error
 		//data.ErrorMessage = merry.UserMessage(err)
@@ -0,0 +1,251 @@
+package httpserver
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+type groupDetailPageData struct {
+	Message        string
+	Error          string
+	RequestingUser user.User
+	Group          user.Group
+	// Managers are the group's owners and managers.
+	Managers []user.GroupManager
+	// References lists why this group can't be deleted (if any).
+	References []string
+	// CanAddMembers is false if the group's roles grant permissions the
+	// requesting user doesn't have (see user.CanAddGroupMember).
+	CanAddMembers bool
+	// CanRemove is true for each member the requesting user can remove (see
+	// user.CanRemoveGroupMember).
+	CanRemove map[string]bool
+	CSRFField template.HTML
+}
+
+// canAddGroupMember returns true if the requesting user can add members to the
+// group, without gaining permissions through its roles they don't have.
+func canAddGroupMember(c *Context, group string) (bool, error) {
+	permissions, err := user.GetGroupPermissions(c.Tx)
+	if err != nil {
+		return false, err
+	}
+	return c.User.CanAddGroupMember(group, permissions[group]), nil
+}
+
+// getGroupDetailPageData loads the named group and everything needed to show
+// its detail or delete confirmation page.
+func getGroupDetailPageData(c *Context, r *http.Request, name string) (
+	data groupDetailPageData, err error) {
+	group, err := user.GetGroupWithMembers(c.Tx, name)
+	if merry.Is(err, sql.ErrNoRows) {
+		return data, ErrNotFound.Here()
+	} else if err != nil {
+		return data, err
+	}
+	managers, err := user.GetGroupManagers(c.Tx, name)
+	if err != nil {
+		return data, err
+	}
+	references, err := user.GetGroupReferences(c.Tx, name)
+	if err != nil {
+		return data, err
+	}
+	canAdd, err := canAddGroupMember(c, name)
+	if err != nil {
+		return data, err
+	}
+	canRemove := make(map[string]bool, len(group.Members))
+	for _, username := range group.Members {
+		if c.User.Can(user.PermGroupsManage) {
+			canRemove[username] = true
+			continue
+		}
+		member, err := c.GetUser(username)
+		if err != nil {
+			return data, err
+		}
+		canRemove[username] = c.User.CanRemoveGroupMember(name, member)
+	}
+	data = groupDetailPageData{
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		Group:          group,
+		Managers:       managers,
+		References:     references,
+		CanAddMembers:  canAdd,
+		CanRemove:      canRemove,
+		CSRFField:      csrf.TemplateField(r),
+	}
+	return data, nil
+}
+
+// groupDetail shows a single group, its members, and forms to change them.
+func groupDetail(c *Context, w http.ResponseWriter, r *http.Request) error {
+	group := c.GetRouteVarTrim("groupname")
+	// Check permissions
+	if !c.User.CanManageGroupMembers(group) {
+		return ErrPermissionDenied.Here()
+	}
+	data, err := getGroupDetailPageData(c, r, group)
+	if err != nil {
+		return err
+	}
+	Render(w, "group_detail.html", data)
+	return nil
+}
+
+// groupSetDescription is a sub-handler that changes a group's description.
+func groupSetDescription(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermGroupsManage) {
+		return ErrPermissionDenied.Here()
+	}
+	group := c.GetRouteVarTrim("groupname")
+	err := user.SetGroupDescription(c.Tx, c.Actor(), group, r.FormValue("Description"))
+	if err != nil {
+		c.AddErrorFlash("Failed to update the description.")
+		return err
+	}
+	c.AddNormalFlash("Description updated.")
+	http.Redirect(w, r, "/groups/"+group, http.StatusFound)
+	return nil
+}
+
+// groupAddRemoveMember is a sub-handler that adds a user (from the form) to a
+// group, or removes one (from the URL) from it.
+func groupAddRemoveMember(c *Context, w http.ResponseWriter, r *http.Request) error {
+	group := c.GetRouteVarTrim("groupname")
+	// Check permissions
+	if !c.User.CanManageGroupMembers(group) {
+		return ErrPermissionDenied.Here()
+	}
+	username := c.GetRouteVarTrim("username")
+	add := username == ""
+	if add {
+		username = strings.TrimSpace(r.FormValue("Username"))
+		canAdd, err := canAddGroupMember(c, group)
+		if err != nil {
+			return err
+		} else if !canAdd {
+			return ErrPermissionDenied.Here()
+		}
+	}
+	// Make sure the user exists, so we can give them a useful message if not
+	member, err := c.GetUser(username)
+	if merry.Is(err, sql.ErrNoRows) {
+		c.AddErrorFlash(fmt.Sprintf("User '%s' does not exist.", username))
+		http.Redirect(w, r, "/groups/"+group, http.StatusFound)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !add && !c.User.CanRemoveGroupMember(group, member) {
+		return ErrPermissionDenied.Here()
+	}
+	// Handle the request
+	if add {
+		err = user.AddUserToGroup(c.Tx, c.Actor(), username, group)
+	} else {
+		err = user.RemoveUserFromGroup(c.Tx, c.Actor(), username, group)
+	}
+	if merry.Is(err, user.ErrorUserArchived) {
+		c.AddErrorFlash(merry.UserMessage(err))
+		http.Redirect(w, r, "/groups/"+group, http.StatusFound)
+		return nil
+	} else if err != nil {
+		c.AddErrorFlash(fmt.Sprintf("Failed to change %s's membership in %s.", username, group))
+		return roleChangeError(err)
+	}
+	if add {
+		c.AddNormalFlash(fmt.Sprintf("Added %s to %s.", username, group))
+	} else {
+		c.AddNormalFlash(fmt.Sprintf("Removed %s from %s.", username, group))
+	}
+	http.Redirect(w, r, "/groups/"+group, http.StatusFound)
+	return nil
+}
+
+// groupAddRemoveManager is a sub-handler that makes a user (from the form) an
+// owner or manager of a group, or removes one (from the URL).
+func groupAddRemoveManager(c *Context, w http.ResponseWriter, r *http.Request) error {
+	group := c.GetRouteVarTrim("groupname")
+	// Check permissions
+	if !c.User.CanManageGroupManagers(group) {
+		return ErrPermissionDenied.Here()
+	}
+	var err error
+	username := c.GetRouteVarTrim("username")
+	if username == "" {
+		username = strings.TrimSpace(r.FormValue("Username"))
+		role := r.FormValue("Role")
+		err = user.SetGroupManager(c.Tx, c.Actor(), group, username, role)
+		if err != nil && merry.UserMessage(err) != "" {
+			// Unknown user or invalid role
+			c.AddErrorFlash(merry.UserMessage(err))
+			http.Redirect(w, r, "/groups/"+group, http.StatusFound)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		c.AddNormalFlash(fmt.Sprintf("%s is now a %s of %s.", username, role, group))
+	} else {
+		err = user.RemoveGroupManager(c.Tx, c.Actor(), group, username)
+		if err != nil {
+			return err
+		}
+		c.AddNormalFlash(fmt.Sprintf("%s no longer manages %s.", username, group))
+	}
+	log.Infof("%s changed the managers of group %s", c.User.Username, group)
+	http.Redirect(w, r, "/groups/"+group, http.StatusFound)
+	return nil
+}
+
+// groupDelete asks the user to confirm deleting a group by typing its name,
+// and then deletes it.
+func groupDelete(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermGroupsManage) {
+		return ErrPermissionDenied.Here()
+	}
+	group := c.GetRouteVarTrim("groupname")
+	data, err := getGroupDetailPageData(c, r, group)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case "GET":
+		Render(w, "group_delete.html", data)
+		return nil
+	case "POST":
+		if strings.TrimSpace(r.FormValue("Confirm")) != group {
+			data.Error = "The name you typed does not match this group's name."
+			Render(w, "group_delete.html", data)
+			return nil
+		}
+		err = user.DeleteGroup(c.Tx, c.Actor(), group)
+		if merry.Is(err, user.ErrorGroupReferenced) {
+			data.Error = merry.UserMessage(err)
+			Render(w, "group_delete.html", data)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		log.Infof("%s deleted group %s", c.User.Username, group)
+		c.AddNormalFlash(fmt.Sprintf("Deleted group %s.", group))
+		http.Redirect(w, r, "/groups", http.StatusFound)
+		return nil
+	}
+	return nil
+}
@@ -1,22 +1,26 @@
 package httpserver
 
 import (
+	"html/template"
 	"net/http"
 
+	"github.com/gorilla/csrf"
 	"github.com/joshsziegler/zauth/pkg/user"
 )
 
 type groupListData struct {
-	Message string
-	Error   string
-	User    user.User
-	Groups  []*user.Group
+	Message   string
+	Error     string
+	CSRFField template.HTML
+	User      user.User
+	Groups    []*user.Group
 }
 
-// GroupListGet shows the user a list of all current zauth groups.
+// GroupListGet shows the user a list of all current zauth groups (or only those
+// they manage if they can't manage all groups).
 func GroupListGet(c *Context, w http.ResponseWriter, r *http.Request) error {
-	// Only admins can view this page
-	if !c.User.IsAdmin() {
+	// Only group managers can view this page
+	if !c.User.ManagesAnyGroup() {
 		return ErrPermissionDenied.Here()
 	}
 
@@ -25,8 +29,19 @@ func GroupListGet(c *Context, w http.ResponseWriter, r *http.Request) error {
 		return err
 		// return ErrInternal.Here()
 	}
+	// Group managers only see the groups they manage
+	if !c.User.Can(user.PermGroupsManage) {
+		managed := groups[:0]
+		for _, group := range groups {
+			if c.User.CanManageGroupMembers(group.Name) {
+				managed = append(managed, group)
+			}
+		}
+		groups = managed
+	}
 	data := groupListData{User: *c.User, Groups: groups,
 		Message: c.NormalFlashMessage, Error: c.ErrorFlashMessage,
+		CSRFField: csrf.TemplateField(r),
 	}
 	Render(w, "group_list.html", data)
 	return nil
@@ -0,0 +1,42 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ansel1/merry"
+	"github.com/joshsziegler/zauth/pkg/user"
+)
+
+// groupRequire2FA is a sub-handler that sets whether members of a group must
+// use two-factor authentication.
+func groupRequire2FA(c *Context, w http.ResponseWriter, r *http.Request) (err error) {
+	// Check permissions
+	if !c.User.Can(user.PermGroupsManage) {
+		return ErrPermissionDenied.Here()
+	}
+	group := c.GetRouteVarTrim("groupname")
+	// Handle the request
+	operation := c.GetRouteVarTrim("requireOrOptional")
+	if operation == "require" {
+		err = user.SetGroupRequire2FA(c.Tx, c.Actor(), group, true)
+	} else if operation == "optional" {
+		err = user.SetGroupRequire2FA(c.Tx, c.Actor(), group, false)
+	} else {
+		return merry.Here(ErrRequestArgument).
+			WithMessagef("invalid operation '%s' (must be 'require' or 'optional')",
+				operation)
+	}
+	// Set flash message indicating result
+	if err != nil {
+		c.AddErrorFlash(fmt.Sprintf("Failed to change two-factor requirement for %s.", group))
+		return err
+	}
+	if operation == "require" {
+		c.AddNormalFlash(fmt.Sprintf("Members of %s must now use two-factor authentication.", group))
+	} else {
+		c.AddNormalFlash(fmt.Sprintf("Two-factor authentication is now optional for %s.", group))
+	}
+	http.Redirect(w, r, "/groups", http.StatusFound)
+	return nil
+}
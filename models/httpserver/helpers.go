@@ -2,8 +2,14 @@ package httpserver
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/ansel1/merry"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
 	"github.com/joshsziegler/zauth/pkg/user"
 	"github.com/joshsziegler/zgo/pkg/log"
 )
@@ -60,3 +66,39 @@ func ErrorInternal(w http.ResponseWriter) {
 func ErrorUnauthorized(w http.ResponseWriter, message string, user *user.User) {
 	Error(w, 403, "Unauthorized", message, user)
 }
+
+// dateInputFormat is the format of HTML's date inputs.
+const dateInputFormat = "2006-01-02"
+
+// parseDateInput parses the value of a date input as midnight, local time.
+// Returns the zero time if the input is empty.
+func parseDateInput(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(dateInputFormat, value, time.Local)
+	if err != nil {
+		return time.Time{}, merry.Here(ErrRequestArgument).WithCause(err)
+	}
+	return t, nil
+}
+
+// clientIP returns the IP address of the client making the request.
+//
+// This does NOT trust headers such as X-Forwarded-For, since they can be set
+// by the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// webActor returns the audit actor for a request made by username, who may not
+// be logged in yet (e.g. when logging in).
+func webActor(r *http.Request, username string) audit.Actor {
+	return audit.Actor{Username: username, IP: clientIP(r),
+		Channel: audit.ChannelWeb}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/ansel1/merry"
 	"github.com/gorilla/csrf"
+	"github.com/joshsziegler/zauth/pkg/passkey"
 	"github.com/joshsziegler/zauth/pkg/user"
 	"github.com/joshsziegler/zgo/pkg/log"
 )
@@ -17,6 +18,11 @@ type LoginPageData struct {
 	Error     string
 	Username  string
 	CSRFField template.HTML
+	// CSRFToken and PasskeysEnabled are used for passwordless passkey logins.
+	CSRFToken       string
+	PasskeysEnabled bool
+	// RememberMeEnabled shows the "Remember me" checkbox.
+	RememberMeEnabled bool
 }
 
 // LoginGetPost handles a user's request to view the login page (GET and POST).
@@ -33,7 +39,9 @@ func LoginGetPost(c *Context, w http.ResponseWriter, r *http.Request) error {
 
 	// Create page data here so we don't forget to create the CSRF token
 	data := LoginPageData{CSRFField: csrf.TemplateField(r),
-		Message: c.NormalFlashMessage, Error: c.ErrorFlashMessage}
+		CSRFToken: csrf.Token(r), PasskeysEnabled: passkey.Enabled(),
+		RememberMeEnabled: rememberMeEnabled,
+		Message:           c.NormalFlashMessage, Error: c.ErrorFlashMessage}
 
 	switch r.Method {
 	case "GET":
@@ -49,12 +57,18 @@ func LoginGetPost(c *Context, w http.ResponseWriter, r *http.Request) error {
 		}
 
 		// Authenticate using the provided username and password
-		err := user.Login(c.Tx, username, password)
+		err := user.Login(c.Tx, c.Actor(), username, password)
+		if merry.Is(err, user.ErrorPasswordExpired) {
+			// Let them login so they can change it (see mustChangePassword)
+			err = nil
+		}
 		if err != nil { // error, or invalid username and/or password
 			log.Info(err)
 			data.Username = username
 			if merry.Is(err, user.ErrorLoginDisabled) {
 				data.Error = "This account has been disabled."
+			} else if merry.Is(err, user.ErrorLoginExpired) {
+				data.Error = "This account has expired."
 			} else {
 				data.Error = "Invalid username and/or password."
 			}
@@ -62,20 +76,80 @@ func LoginGetPost(c *Context, w http.ResponseWriter, r *http.Request) error {
 			return nil
 		}
 
-		// Always returns a session, even if it's empty
-		session, err := store.Get(r, sessionName)
+		// Ask for their second factor before logging them in, if they have one
+		loggingIn, err := user.GetUserWithGroups(c.Tx, username)
 		if err != nil {
-			log.Debug("secure session exists, but could not be decoded")
+			return err
 		}
-		session.Values["Username"] = username
-		// Save the updated session BEFORE writing the response so it's sent
-		err = session.Save(r, w)
-		if err != nil {
-			return ErrInternal.Here()
+		if loggingIn.HasSecondFactor() {
+			err = setPendingLogin(c, w, r, username, rememberMe(r))
+			if err != nil {
+				return err
+			}
+			http.Redirect(w, r, urlLoginTwoFactor, http.StatusFound)
+			return nil
 		}
+		return completeLogin(c, w, r, username, rememberMe(r), "password")
+	}
+	return nil
+}
+
+// completeLogin records the login and the method used (e.g. password+totp), logs
+// the user in using setLoggedIn, and redirects them to their details page.
+//
+// Only call this once the user has provided ALL of their required factors!
+func completeLogin(c *Context, w http.ResponseWriter, r *http.Request, username string,
+	remember bool, method string) error {
+	err := user.UpdateLastLogin(c.Tx, c.Actor(), username, method)
+	if err != nil {
+		return err
+	}
+	err = setLoggedIn(c, w, r, username, remember)
+	if err != nil {
+		return err
+	}
+	http.Redirect(w, r, "/users/"+username, 302)
+	return nil
+}
 
-		log.Infof("logged in as %s", username)
-		http.Redirect(w, r, "/users/"+username, 302)
+// setLoggedIn creates a new session for the user and saves its token to their
+// secure cookie, which is what makes them logged in, and removes any pending
+// login. If remember is true (and allowed), the cookie is kept after the
+// browser closes.
+//
+// Only call this once the user has provided ALL of their required factors!
+func setLoggedIn(c *Context, w http.ResponseWriter, r *http.Request, username string,
+	remember bool) error {
+	remember = remember && rememberMeEnabled
+	token, err := user.CreateSession(c.Tx, username, clientIP(r), r.UserAgent(),
+		remember)
+	if err != nil {
+		return err
+	}
+	err = clearPendingLogin(c, w, r)
+	if err != nil {
+		return err
+	}
+	// Always returns a session, even if it's empty
+	session, err := store.Get(r, loginSessionName)
+	if err != nil {
+		log.Debug("secure session exists, but could not be decoded")
 	}
+	session.Values[sessionTokenKey] = token
+	if remember {
+		session.Options.MaxAge = int(user.SessionRememberLifetime.Seconds())
+	}
+	// Save the updated session BEFORE writing the response so it's sent
+	err = session.Save(r, w)
+	if err != nil {
+		return ErrInternal.Here()
+	}
+
+	log.Infof("logged in as %s", username)
 	return nil
 }
+
+// rememberMe returns true if the user checked "Remember me" when logging in.
+func rememberMe(r *http.Request) bool {
+	return r.FormValue("remember") == "true"
+}
@@ -0,0 +1,167 @@
+package httpserver
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
+	"github.com/joshsziegler/zauth/pkg/passkey"
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+// pendingLoginKey is where the browser's pending login token is kept in its
+// secure cookie. The pending login itself (including its attempts) is kept in
+// the database, so it can't be reset by replaying an old cookie.
+const pendingLoginKey = "PendingLogin"
+
+type loginTwoFactorPageData struct {
+	Message   string
+	Error     string
+	CSRFField template.HTML
+	CSRFToken string
+	// TOTPEnabled and HasPasskeys indicate which second factors to offer.
+	TOTPEnabled bool
+	HasPasskeys bool
+}
+
+// setPendingLogin records that this user provided the correct password, but
+// must still provide their second factor before they are logged in. Remember
+// is whether they checked "Remember me".
+func setPendingLogin(c *Context, w http.ResponseWriter, r *http.Request,
+	username string, remember bool) error {
+	token, err := user.CreatePendingLogin(c.Tx, username, remember)
+	if err != nil {
+		return err
+	}
+	session, err := store.Get(r, sessionName)
+	if err != nil {
+		log.Debug("secure session exists, but could not be decoded")
+	}
+	session.Values[pendingLoginKey] = token
+	err = session.Save(r, w)
+	if err != nil {
+		return ErrInternal.Here()
+	}
+	return nil
+}
+
+// pendingLoginToken returns the browser's pending login token, or an empty
+// string if it doesn't have one.
+func pendingLoginToken(r *http.Request) string {
+	session, err := store.Get(r, sessionName)
+	if err != nil {
+		log.Debug("secure session exists, but could not be decoded")
+	}
+	token, _ := session.Values[pendingLoginKey].(string)
+	return token
+}
+
+// getPendingLogin returns the username awaiting a second factor, or an empty
+// string if there isn't one (or it has expired or used all of its attempts).
+func getPendingLogin(c *Context, r *http.Request) (username string, remember bool,
+	err error) {
+	token := pendingLoginToken(r)
+	if token == "" {
+		return "", false, nil
+	}
+	username, remember, err = user.GetPendingLogin(c.Tx, token)
+	if merry.Is(err, user.ErrorPendingLoginInvalid) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return username, remember, nil
+}
+
+// usePendingLoginAttempt counts an attempt to provide a second factor for the
+// browser's pending login. Returns user.ErrorPendingLoginInvalid if it has no
+// attempts left.
+func usePendingLoginAttempt(c *Context, r *http.Request) error {
+	token := pendingLoginToken(r)
+	if token == "" {
+		return user.ErrorPendingLoginInvalid.Here()
+	}
+	_, err := user.UsePendingLoginAttempt(c.Tx, token)
+	return err
+}
+
+// clearPendingLogin removes any pending login, forcing them to start over.
+func clearPendingLogin(c *Context, w http.ResponseWriter, r *http.Request) error {
+	session, err := store.Get(r, sessionName)
+	if err != nil {
+		log.Debug("secure session exists, but could not be decoded")
+	}
+	token, _ := session.Values[pendingLoginKey].(string)
+	if token == "" {
+		return nil
+	}
+	err = user.DeletePendingLogin(c.Tx, token)
+	if err != nil {
+		return err
+	}
+	delete(session.Values, pendingLoginKey)
+	err = session.Save(r, w)
+	if err != nil {
+		return ErrInternal.Here()
+	}
+	return nil
+}
+
+// LoginTwoFactorGetPost asks a user who provided the correct password for
+// their TOTP or recovery code, and logs them in if it's correct.
+func LoginTwoFactorGetPost(c *Context, w http.ResponseWriter, r *http.Request) error {
+	if c.User != nil {
+		http.Redirect(w, r, "/users/"+c.User.Username, http.StatusFound)
+		return nil
+	}
+	username, remember, err := getPendingLogin(c, r)
+	if err != nil {
+		return err
+	}
+	if username == "" {
+		c.AddNormalFlash("Please login with your username and password first.")
+		http.Redirect(w, r, urlLogin, http.StatusFound)
+		return nil
+	}
+
+	pendingUser, err := user.GetUserWithGroups(c.Tx, username)
+	if err != nil {
+		return err
+	}
+	data := loginTwoFactorPageData{CSRFField: csrf.TemplateField(r),
+		CSRFToken: csrf.Token(r), Message: c.NormalFlashMessage,
+		Error: c.ErrorFlashMessage, TOTPEnabled: pendingUser.TOTPEnabled,
+		HasPasskeys: pendingUser.HasPasskeys && passkey.Enabled()}
+
+	switch r.Method {
+	case "GET":
+		Render(w, "login_2fa.html", data)
+	case "POST":
+		// Count the attempt first, so simultaneous requests can't get extra
+		err = usePendingLoginAttempt(c, r)
+		if merry.Is(err, user.ErrorPendingLoginInvalid) {
+			err = clearPendingLogin(c, w, r)
+			if err != nil {
+				return err
+			}
+			c.AddErrorFlash("Too many invalid codes. Please login again.")
+			http.Redirect(w, r, urlLogin, http.StatusFound)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		code := strings.TrimSpace(r.FormValue("code"))
+		err = user.CheckSecondFactor(c.Tx, webActor(r, username), username, code)
+		if err != nil {
+			log.Info(err)
+			data.Error = "Invalid code. Please try again."
+			Render(w, "login_2fa.html", data)
+			return nil
+		}
+		return completeLogin(c, w, r, username, remember, "password+totp")
+	}
+	return nil
+}
@@ -2,17 +2,24 @@ package httpserver
 
 import (
 	"net/http"
+
+	"github.com/joshsziegler/zauth/pkg/user"
 )
 
 // LogoutGet handles a user's request to logout of zauth.
 func LogoutGet(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Revoke the session, so the token can't be used again
+	err := user.DeleteSession(c.Tx, c.Actor(), c.User.ID, c.SessionID)
+	if err != nil {
+		return err
+	}
 	// Always returns a session, even if it's empty
 	session, err := store.Get(r, sessionName)
 	if err != nil {
 		return ErrGetSecureSession.Here()
 	}
-	// Delete the Username key-value pair
-	delete(session.Values, "Username")
+	// Delete the session token
+	delete(session.Values, sessionTokenKey)
 	// Save the updated session BEFORE writing the response so it's sent
 	err = session.Save(r, w)
 	if err != nil {
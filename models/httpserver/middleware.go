@@ -9,6 +9,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 
+	"github.com/joshsziegler/zauth/pkg/audit"
 	"github.com/joshsziegler/zauth/pkg/user"
 	"github.com/joshsziegler/zgo/pkg/log"
 )
@@ -30,7 +31,9 @@ type Context struct {
 	// Tx is the database transaction that is started for you.
 	Tx *sqlx.Tx
 	// User is the person making this HTTP request.
-	User               *user.User
+	User *user.User
+	// SessionID is the database ID of the User's session.
+	SessionID          int64
 	NormalFlashMessage string
 	ErrorFlashMessage  string
 	RouteVariables     map[string]string
@@ -38,22 +41,44 @@ type Context struct {
 	Request            *http.Request
 }
 
-// get the username from the secure session. If it doesn't exist, redirect to
-// the login page.
-//
-// Does NOT use the database!
-func getUsername(w http.ResponseWriter, r *http.Request) *string {
+// Actor returns who is making this request, for the audit log. The username
+// is empty if they aren't logged in.
+func (c *Context) Actor() audit.Actor {
+	if c.User == nil {
+		return webActor(c.Request, "")
+	}
+	return webActor(c.Request, c.User.Username)
+}
+
+// getSession returns the username and session ID of the logged in user, or an
+// empty username if they aren't logged in. If their session was revoked or has
+// expired, it's removed from their cookie and loggedOutReason explains why.
+func getSession(tx *sqlx.Tx, w http.ResponseWriter, r *http.Request) (
+	username string, sessionID int64, loggedOutReason string) {
 	// Always returns a session, even if it's empty
-	session, err := store.Get(r, sessionName)
+	session, err := store.Get(r, loginSessionName)
 	if err != nil {
 		log.Debug("secure session exists, but could not be decoded")
 	}
-	val := session.Values["Username"]
-	if val == nil { // User not logged in
-		return nil
+	token, ok := session.Values[sessionTokenKey].(string)
+	if !ok || token == "" { // User not logged in
+		return "", 0, ""
 	}
-	username := fmt.Sprintf("%v", val)
-	return &username
+	username, sessionID, err = user.GetSession(tx, token)
+	if err != nil && !merry.Is(err, user.ErrorSessionInvalid) {
+		log.Error(err)
+		return "", 0, ""
+	} else if err != nil {
+		log.Info(err)
+		// Delete the cookie, so we only tell them why once
+		session.Options.MaxAge = -1
+		err2 := session.Save(r, w)
+		if err2 != nil {
+			log.Error(err2)
+		}
+		return "", 0, merry.UserMessage(err)
+	}
+	return username, sessionID, ""
 }
 
 // GetUser returns the specified User. This potentially avoids a second DB call
@@ -108,19 +133,22 @@ func Wrap(router *mux.Router, subHandler Handler, requireLogin bool) http.Handle
 				"Sorry, but the server encountered an error.", nil)
 		}
 		c.Tx = tx
-		// Get username of user (or nil if they are not logged in)
-		username := getUsername(w, r)
+		// Get username of user (or "" if they are not logged in)
+		username, sessionID, loggedOutReason := getSession(tx, w, r)
 		// Log this request, including their username if they are logged in
-		if username == nil {
+		if username == "" {
 			log.Infof("anonymous %s %s", r.Method, r.RequestURI)
 		} else {
-			log.Infof("%s %s %s", *username, r.Method, r.RequestURI)
+			log.Infof("%s %s %s", username, r.Method, r.RequestURI)
 		}
 		// Redirect if this page requires authentication
-		if requireLogin && username == nil { // Not logged in
-			c.AddNormalFlash("Sorry, but that page requires you to " +
-				"login first. If you were previously logged in, your session " +
-				"has expired.")
+		if requireLogin && username == "" { // Not logged in
+			if loggedOutReason != "" {
+				c.AddNormalFlash(loggedOutReason)
+			} else {
+				c.AddNormalFlash("Sorry, but that page requires you to " +
+					"login first.")
+			}
 			http.Redirect(w, r, urlLogin, http.StatusFound)
 			err = c.Tx.Commit()
 			if err != nil {
@@ -129,8 +157,8 @@ func Wrap(router *mux.Router, subHandler Handler, requireLogin bool) http.Handle
 			return
 		}
 		// Get and save user struct if they are logged in
-		if username != nil {
-			tempUser, err := user.GetUserWithGroups(tx, *username)
+		if username != "" {
+			tempUser, err := user.GetUserWithGroups(tx, username)
 			if err != nil {
 				log.Error(err)
 				Error(w, 500, "Error",
@@ -143,9 +171,34 @@ func Wrap(router *mux.Router, subHandler Handler, requireLogin bool) http.Handle
 			}
 			// Convert to pointer to allow us to check for an empty User using nil
 			c.User = &tempUser
+			c.SessionID = sessionID
+			// Force users whose groups require 2FA to enroll before continuing
+			if mustEnrollTwoFactor(c.User, r) {
+				c.AddNormalFlash("One of your groups requires two-factor " +
+					"authentication. Please set it up before continuing.")
+				http.Redirect(w, r, "/users/"+c.User.Username+"/2fa", http.StatusFound)
+				err = c.Tx.Commit()
+				if err != nil {
+					log.Error(err)
+				}
+				return
+			}
+			// Force users whose password expired to change it before continuing
+			if mustChangePassword(c.User, r) {
+				c.AddErrorFlash(merry.UserMessage(user.ErrorPasswordExpired))
+				http.Redirect(w, r, "/users/"+c.User.Username+"/password", http.StatusFound)
+				err = c.Tx.Commit()
+				if err != nil {
+					log.Error(err)
+				}
+				return
+			}
 		}
 		// Get flash messages, if any
 		c.getFlashMessages()
+		if loggedOutReason != "" && c.NormalFlashMessage == "" {
+			c.NormalFlashMessage = loggedOutReason
+		}
 		// Run the page-specific handler, which renders the page unless there is an error
 		err = subHandler(&c, w, r)
 		if err != nil {
@@ -155,6 +208,8 @@ func Wrap(router *mux.Router, subHandler Handler, requireLogin bool) http.Handle
 				Error(w, 500, "Error", merry.UserMessage(err), c.User)
 			} else if merry.Is(err, ErrBadRequest) {
 				Error(w, 400, "Error", merry.UserMessage(err), c.User)
+			} else if merry.Is(err, ErrNotFound) {
+				Error(w, 404, "Error", merry.UserMessage(err), c.User)
 			} else {
 				// We can't guarantee this error has a nice UserMessage
 				Error(w, 500, "Error", merry.Details(err), nil)
@@ -173,6 +228,41 @@ func Wrap(router *mux.Router, subHandler Handler, requireLogin bool) http.Handle
 	})
 }
 
+// setupPage returns true if this request is one of the pages a user may need
+// before they can use the rest of the site: enrolling in two-factor
+// authentication, changing their password, or logging out. Neither gate below
+// redirects away from any of them, so a user who must do both isn't sent back
+// and forth between them.
+func setupPage(u *user.User, r *http.Request) bool {
+	userURL := "/users/" + u.Username
+	switch r.URL.Path {
+	case userURL + "/2fa", userURL + "/password", userURL + "/password/strength",
+		"/logout":
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, userURL+"/passkeys/") ||
+		r.URL.Path == userURL+"/passkeys"
+}
+
+// mustEnrollTwoFactor returns true if this user is required to use two-factor
+// authentication but hasn't enrolled yet, and this request is not one of the
+// pages they need to get there (see setupPage).
+func mustEnrollTwoFactor(u *user.User, r *http.Request) bool {
+	if !u.TwoFactorRequired || u.HasSecondFactor() {
+		return false
+	}
+	return !setupPage(u, r)
+}
+
+// mustChangePassword returns true if this user's password has expired, and this
+// request is not one of the pages they need to change it (see setupPage).
+func mustChangePassword(u *user.User, r *http.Request) bool {
+	if !u.PasswordExpired() {
+		return false
+	}
+	return !setupPage(u, r)
+}
+
 // getFlashMessages gets a single error flash message and a single normal
 // flash message. This is to restrict the HTTP handlers to a single, most-
 // important message of each type.
@@ -0,0 +1,68 @@
+package httpserver
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/joshsziegler/zauth/pkg/password"
+	"github.com/joshsziegler/zauth/pkg/user"
+)
+
+func TestSetupGates(t *testing.T) {
+	err := password.Init(password.Config{Policy: password.Policy{MaxAgeDays: 90}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer password.Init(password.Config{})
+	// Must enroll in two-factor AND change their expired password
+	u := &user.User{Username: "jane.doe", TwoFactorRequired: true,
+		PasswordSet: time.Now().AddDate(0, 0, -100)}
+	if !u.PasswordExpired() {
+		t.Fatal("expected jane.doe's password to have expired")
+	}
+
+	// Every page needed for either is allowed by both gates, so they can't
+	// redirect back and forth
+	for _, path := range []string{
+		"/users/jane.doe/2fa",
+		"/users/jane.doe/password",
+		"/users/jane.doe/password/strength",
+		"/users/jane.doe/passkeys",
+		"/users/jane.doe/passkeys/register/begin",
+		"/logout",
+	} {
+		r := httptest.NewRequest("GET", path, nil)
+		if mustEnrollTwoFactor(u, r) {
+			t.Errorf("%s redirected to two-factor enrollment", path)
+		}
+		if mustChangePassword(u, r) {
+			t.Errorf("%s redirected to change password", path)
+		}
+	}
+
+	// Other pages are not, including other users' pages
+	for _, path := range []string{
+		"/users/jane.doe",
+		"/users/jane.doe/sessions",
+		"/users/jane.doe/passkeysx",
+		"/users/john.smith/password",
+		"/groups",
+	} {
+		r := httptest.NewRequest("GET", path, nil)
+		if !mustEnrollTwoFactor(u, r) {
+			t.Errorf("%s didn't redirect to two-factor enrollment", path)
+		}
+		if !mustChangePassword(u, r) {
+			t.Errorf("%s didn't redirect to change password", path)
+		}
+	}
+
+	// Neither applies once they're done
+	u.TOTPEnabled = true
+	u.PasswordSet = time.Now()
+	r := httptest.NewRequest("GET", "/groups", nil)
+	if mustEnrollTwoFactor(u, r) || mustChangePassword(u, r) {
+		t.Error("a user with two-factor and a new password was redirected")
+	}
+}
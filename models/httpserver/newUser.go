@@ -12,9 +12,13 @@ import (
 )
 
 type formNewUser struct {
+	// Username is optional, and made from their name if empty.
+	Username  string
 	FirstName string
 	LastName  string
 	Email     string
+	// ExpiresAt is the account's optional expiration date (see dateInputFormat).
+	ExpiresAt string
 }
 
 type newUserPageData struct {
@@ -26,16 +30,18 @@ type newUserPageData struct {
 
 func newFormNewUser(r *http.Request) formNewUser {
 	f := formNewUser{}
+	f.Username = strings.Trim(r.FormValue("Username"), " ")
 	f.FirstName = strings.Trim(r.FormValue("FirstName"), " ")
 	f.LastName = strings.Trim(r.FormValue("LastName"), " ")
 	f.Email = strings.Trim(r.FormValue("Email"), " ")
+	f.ExpiresAt = strings.Trim(r.FormValue("ExpiresAt"), " ")
 	return f
 }
 
 // NewUserGet is a sub-handler that shows the User creation page.
 func NewUserGet(c *Context, w http.ResponseWriter, r *http.Request) error {
 	// Check permissions
-	if !c.User.IsAdmin() {
+	if !c.User.Can(user.PermUsersCreate) {
 		return ErrPermissionDenied.Here()
 	}
 	// Handle the request
@@ -47,13 +53,20 @@ func NewUserGet(c *Context, w http.ResponseWriter, r *http.Request) error {
 // NewUserPost is a sub-handler that processes the User creation form.
 func NewUserPost(c *Context, w http.ResponseWriter, r *http.Request) error {
 	// Check permissions
-	if !c.User.IsAdmin() {
+	if !c.User.Can(user.PermUsersCreate) {
 		return ErrPermissionDenied.Here()
 	}
 	// Handle the request
 	data := newUserPageData{User: c.User, CSRFField: csrf.TemplateField(r)}
 	form := newFormNewUser(r)
-	newUser, err := user.NewUser(c.Tx, form.FirstName, form.LastName, form.Email)
+	expiresAt, err := parseDateInput(form.ExpiresAt)
+	if err != nil {
+		data.Form = form
+		data.ErrorMessage = "Expiration date must be a valid date."
+		Render(w, "user_new.html", data)
+		return nil
+	}
+	newUser, err := user.NewUser(c.Tx, c.Actor(), form.Username, form.FirstName, form.LastName, form.Email)
 	if err != nil {
 		data.Form = form // Show current form values along with error
 		data.ErrorMessage = merry.UserMessage(err)
@@ -61,25 +74,17 @@ func NewUserPost(c *Context, w http.ResponseWriter, r *http.Request) error {
 		// Don't return the error, since we rendered a custom error page
 		return nil
 	}
-	// Commit here, so that errors further along should not undo this new user
-	// operation (e.g. from the Email process)
-	// TODO: We don't use the next Tx, but we need it for the wrapper. So what
-	//       should we do? -JZ
-	c.Tx.Commit()
-	c.Tx, err = DB.Beginx()
-	if err != nil {
-		return err
+	if !expiresAt.IsZero() {
+		err = user.SetExpiresAt(c.Tx, c.Actor(), newUser.Username, expiresAt)
+		if err != nil {
+			return err
+		}
 	}
-
-	// Send new user an email asking them to login and set their password
-	newUser.SendPasswordResetEmail()
+	// Send new user an email asking them to login and set their password. It's
+	// queued in this transaction, so it's sent IFF the user is created.
+	err = newUser.SendPasswordResetEmail(c.Tx)
 	if err != nil {
-		// User was created successfully, but the password email failed
-		msg := fmt.Sprintf("User %s successfully created, but their password reset email failed to send. '%s'",
-			newUser.Username, err)
-		c.AddNormalFlash(msg)
-		http.Redirect(w, r, "/users/"+newUser.Username, 302)
-		return nil
+		return err
 	}
 	// New User created successfully, redirect them to its page
 	msg := fmt.Sprintf("User %s successfully created. They were sent an email to set their password.",
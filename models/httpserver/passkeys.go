@@ -0,0 +1,388 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
+
+	"github.com/joshsziegler/zauth/pkg/passkey"
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+const (
+	// webAuthnSessionKey holds the token for the WebAuthn ceremony in progress
+	// in the user's secure session, between the begin and finish requests. The
+	// ceremony's data (including its challenge) is kept in the database.
+	webAuthnSessionKey = `WebAuthnSession`
+)
+
+type userPasskeysPageData struct {
+	Message string
+	Error   string
+	// RequestingUser is the one who asked for this page.
+	RequestingUser user.User
+	// RequestedUser is the User whose passkeys are shown.
+	RequestedUser   user.User
+	Passkeys        []user.Passkey
+	PasskeysEnabled bool
+	CSRFField       template.HTML
+	CSRFToken       string
+}
+
+// writeJSON responds with the value encoded as JSON, for the API endpoints
+// used by our WebAuthn JavaScript.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// writeJSONError responds with an error message the JavaScript can show to the
+// user, and logs the full error.
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	log.Info(err)
+	writeJSON(w, code, map[string]string{"error": merry.UserMessage(err)})
+}
+
+// saveWebAuthnSession stores the ceremony's session data, replacing any
+// ceremony already in progress.
+func saveWebAuthnSession(c *Context, w http.ResponseWriter, r *http.Request,
+	data []byte) error {
+	session, err := store.Get(r, sessionName)
+	if err != nil {
+		log.Debug("secure session exists, but could not be decoded")
+	}
+	if old, ok := session.Values[webAuthnSessionKey].(string); ok && old != "" {
+		err = user.DeleteWebAuthnSession(c.Tx, old)
+		if err != nil {
+			return err
+		}
+	}
+	token, err := user.SaveWebAuthnSession(c.Tx, data)
+	if err != nil {
+		return err
+	}
+	session.Values[webAuthnSessionKey] = token
+	err = session.Save(r, w)
+	if err != nil {
+		return ErrInternal.Here()
+	}
+	return nil
+}
+
+// takeWebAuthnSession returns and removes the ceremony's session data, so
+// each challenge can only be used once.
+func takeWebAuthnSession(c *Context, w http.ResponseWriter, r *http.Request) ([]byte,
+	error) {
+	session, err := store.Get(r, sessionName)
+	if err != nil {
+		log.Debug("secure session exists, but could not be decoded")
+	}
+	token, ok := session.Values[webAuthnSessionKey].(string)
+	if !ok || token == "" {
+		return nil, merry.Here(ErrBadRequest).
+			WithMessage("no WebAuthn ceremony in progress").
+			WithUserMessage("Your request expired. Please try again.")
+	}
+	delete(session.Values, webAuthnSessionKey)
+	err = session.Save(r, w)
+	if err != nil {
+		return nil, ErrInternal.Here()
+	}
+	return user.TakeWebAuthnSession(c.Tx, token)
+}
+
+// getAccount returns the WebAuthn account for this user.
+func getAccount(c *Context, u user.User) (*passkey.Account, error) {
+	passkeys, err := user.GetPasskeys(c.Tx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return passkey.NewAccount(u, passkeys)
+}
+
+// userPasskeys is a sub-handler that lists a user's passkeys, and lets them
+// register new ones. Users with PermUsersEdit may view and delete other users'
+// passkeys, but only the user themself can register one.
+func userPasskeys(c *Context, w http.ResponseWriter, r *http.Request) error {
+	requestedUsername := c.GetRouteVarTrim("username")
+	if !c.User.CanViewUser(requestedUsername) {
+		return ErrPermissionDenied.Here()
+	}
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !c.User.CanEditUser(requestedUser) {
+		return ErrPermissionDenied.Here()
+	}
+	passkeys, err := user.GetPasskeys(c.Tx, requestedUser.ID)
+	if err != nil {
+		return err
+	}
+	data := userPasskeysPageData{
+		Message:         c.NormalFlashMessage,
+		Error:           c.ErrorFlashMessage,
+		RequestingUser:  *c.User,
+		RequestedUser:   requestedUser,
+		Passkeys:        passkeys,
+		PasskeysEnabled: passkey.Enabled(),
+		CSRFField:       csrf.TemplateField(r),
+		CSRFToken:       csrf.Token(r),
+	}
+	Render(w, "user_passkeys.html", data)
+	return nil
+}
+
+// userPasskeyRegisterBegin is a JSON sub-handler that starts registering a
+// new passkey for the logged in user.
+func userPasskeyRegisterBegin(c *Context, w http.ResponseWriter, r *http.Request) error {
+	if c.User.Username != c.GetRouteVarTrim("username") {
+		return ErrPermissionDenied.Here()
+	}
+	account, err := getAccount(c, *c.User)
+	if err != nil {
+		return err
+	}
+	options, session, err := passkey.BeginRegistration(account)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil
+	}
+	err = saveWebAuthnSession(c, w, r, session)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(options))
+	return nil
+}
+
+// userPasskeyRegisterFinish is a JSON sub-handler that verifies and saves the
+// browser's new passkey.
+func userPasskeyRegisterFinish(c *Context, w http.ResponseWriter, r *http.Request) error {
+	if c.User.Username != c.GetRouteVarTrim("username") {
+		return ErrPermissionDenied.Here()
+	}
+	session, err := takeWebAuthnSession(c, w, r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil
+	}
+	account, err := getAccount(c, *c.User)
+	if err != nil {
+		return err
+	}
+	result, err := passkey.FinishRegistration(account, session, r.Body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	err = user.AddPasskey(c.Tx, c.Actor(), c.User.ID, name, result.CredentialID,
+		result.Credential, result.SignCount)
+	if err != nil {
+		return err
+	}
+	c.AddNormalFlash("Passkey added.")
+	writeJSON(w, http.StatusOK, map[string]string{
+		"redirect": "/users/" + c.User.Username + "/passkeys"})
+	return nil
+}
+
+// userPasskeyDelete is a sub-handler that removes one of a user's passkeys.
+func userPasskeyDelete(c *Context, w http.ResponseWriter, r *http.Request) error {
+	requestedUsername := c.GetRouteVarTrim("username")
+	if !c.User.CanViewUser(requestedUsername) {
+		return ErrPermissionDenied.Here()
+	}
+	id, err := strconv.ParseInt(c.GetRouteVarTrim("id"), 10, 64)
+	if err != nil {
+		return merry.Here(ErrRequestArgument).WithCause(err)
+	}
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !c.User.CanEditUser(requestedUser) {
+		return ErrPermissionDenied.Here()
+	}
+	err = user.DeletePasskey(c.Tx, c.Actor(), requestedUser.ID, id)
+	if err != nil {
+		c.AddErrorFlash(merry.UserMessage(err))
+	} else {
+		c.AddNormalFlash("Passkey removed.")
+	}
+	http.Redirect(w, r, "/users/"+requestedUsername+"/passkeys", http.StatusFound)
+	return nil
+}
+
+// loginPasskeyBegin is a JSON sub-handler that starts a passwordless login.
+func loginPasskeyBegin(c *Context, w http.ResponseWriter, r *http.Request) error {
+	options, session, err := passkey.BeginPasswordlessLogin()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil
+	}
+	err = saveWebAuthnSession(c, w, r, session)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(options))
+	return nil
+}
+
+// loginPasskeyFinish is a JSON sub-handler that verifies a passwordless login,
+// and logs the user in if it's valid. A passkey is both something the user has
+// and (with user verification) something they know or are, so no password or
+// other second factor is needed.
+func loginPasskeyFinish(c *Context, w http.ResponseWriter, r *http.Request) error {
+	session, err := takeWebAuthnSession(c, w, r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil
+	}
+	lookup := func(userID int64) (*passkey.Account, error) {
+		username, err := user.GetUsernameByID(c.Tx, userID)
+		if err != nil {
+			return nil, err
+		}
+		u, err := user.GetUserWithGroups(c.Tx, username)
+		if err != nil {
+			return nil, err
+		}
+		return getAccount(c, u)
+	}
+	account, result, err := passkey.FinishPasswordlessLogin(lookup, session, r.Body)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, err)
+		return nil
+	}
+	return finishPasskeyLogin(c, w, r, account, result,
+		r.URL.Query().Get("remember") == "true", "passkey")
+}
+
+// loginTwoFactorPasskeyBegin is a JSON sub-handler that starts using a passkey
+// as the second factor for a user who already provided their password.
+func loginTwoFactorPasskeyBegin(c *Context, w http.ResponseWriter, r *http.Request) error {
+	username, _, err := getPendingLogin(c, r)
+	if err != nil {
+		return err
+	}
+	if username == "" {
+		writeJSONError(w, http.StatusUnauthorized, merry.New("no pending login").
+			WithUserMessage("Please login with your username and password first."))
+		return nil
+	}
+	u, err := user.GetUserWithGroups(c.Tx, username)
+	if err != nil {
+		return err
+	}
+	account, err := getAccount(c, u)
+	if err != nil {
+		return err
+	}
+	options, session, err := passkey.BeginLogin(account)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil
+	}
+	err = saveWebAuthnSession(c, w, r, session)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(options))
+	return nil
+}
+
+// loginTwoFactorPasskeyFinish is a JSON sub-handler that verifies the passkey
+// used as a second factor, and logs the user in if it's valid.
+func loginTwoFactorPasskeyFinish(c *Context, w http.ResponseWriter, r *http.Request) error {
+	username, remember, err := getPendingLogin(c, r)
+	if err != nil {
+		return err
+	}
+	if username == "" {
+		writeJSONError(w, http.StatusUnauthorized, merry.New("no pending login").
+			WithUserMessage("Please login with your username and password first."))
+		return nil
+	}
+	err = usePendingLoginAttempt(c, r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, err)
+		return nil
+	}
+	session, err := takeWebAuthnSession(c, w, r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil
+	}
+	u, err := user.GetUserWithGroups(c.Tx, username)
+	if err != nil {
+		return err
+	}
+	account, err := getAccount(c, u)
+	if err != nil {
+		return err
+	}
+	result, err := passkey.FinishLogin(account, session, r.Body)
+	if err != nil {
+		recordErr := user.RecordSecondFactorFailure(c.Tx, webActor(r, username),
+			username, "passkey")
+		if recordErr != nil {
+			return recordErr
+		}
+		writeJSONError(w, http.StatusUnauthorized, err)
+		return nil
+	}
+	return finishPasskeyLogin(c, w, r, account, result, remember, "password+passkey")
+}
+
+// finishPasskeyLogin saves the passkey's new counter, and records the login and
+// the method used (passkey or password+passkey) and logs the user in.
+func finishPasskeyLogin(c *Context, w http.ResponseWriter, r *http.Request,
+	account *passkey.Account, result passkey.Result, remember bool,
+	method string) error {
+	if account.User.Disabled {
+		err := user.ErrorLoginDisabled.Here().
+			WithMessagef("user '%s' is disabled", account.User.Username).
+			WithUserMessage("This account has been disabled.")
+		writeJSONError(w, http.StatusForbidden, err)
+		return nil
+	}
+	if account.User.Expired() {
+		err := user.ErrorLoginExpired.Here().
+			WithMessagef("user '%s' has expired", account.User.Username).
+			WithUserMessage("This account has expired.")
+		writeJSONError(w, http.StatusForbidden, err)
+		return nil
+	}
+	err := user.UpdatePasskeyAfterLogin(c.Tx, result.PasskeyID, result.Credential,
+		result.SignCount)
+	if err != nil {
+		return err
+	}
+	err = user.UpdateLastLogin(c.Tx, c.Actor(), account.User.Username, method)
+	if err != nil {
+		return err
+	}
+	err = setLoggedIn(c, w, r, account.User.Username, remember)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"redirect": fmt.Sprintf("/users/%s", account.User.Username)})
+	return nil
+}
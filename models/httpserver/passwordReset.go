@@ -7,7 +7,6 @@ import (
 	"github.com/ansel1/merry"
 	"github.com/gorilla/csrf"
 
-	"github.com/joshsziegler/zauth/pkg/password"
 	"github.com/joshsziegler/zauth/pkg/user"
 	"github.com/joshsziegler/zgo/pkg/log"
 )
@@ -47,12 +46,11 @@ func PasswordResetGetPost(c *Context, w http.ResponseWriter, r *http.Request) er
 	}
 	data := userSetPasswordPageData{
 		//RequestingUser:    nil,
-		Message:           c.NormalFlashMessage,
-		Error:             c.ErrorFlashMessage,
-		RequestedUser:     requestedUser,
-		CSRFField:         csrf.TemplateField(r),
-		PasswordMinLength: password.MinLength,
-		PasswordMaxLength: password.MaxLength,
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestedUser:  requestedUser,
+		CSRFField:      csrf.TemplateField(r),
+		PasswordPolicy: requestedUser.PasswordPolicy(),
 	}
 
 	switch r.Method {
@@ -62,7 +60,8 @@ func PasswordResetGetPost(c *Context, w http.ResponseWriter, r *http.Request) er
 	case "POST":
 		newPassword := r.FormValue("NewPassword")
 		// Try to set the user's password (will check password rules)
-		err = user.SetUserPassword(c.Tx, requestedUsername, newPassword)
+		err = user.SetUserPassword(c.Tx, webActor(r, requestedUsername), requestedUsername,
+			newPassword)
 		if err != nil {
 			data.Error = merry.UserMessage(err)
 			Render(w, "user_set_password.html", data)
@@ -76,3 +75,22 @@ func PasswordResetGetPost(c *Context, w http.ResponseWriter, r *http.Request) er
 	}
 	return nil
 }
+
+// passwordResetStrength is a sub-handler that checks a new password for the
+// password reset page. Like that page, it needs a valid reset token.
+func passwordResetStrength(c *Context, w http.ResponseWriter, r *http.Request) error {
+	if c.User != nil {
+		return ErrPermissionDenied.Here()
+	}
+	requestedUsername, err := user.ValidatePasswordResetToken(c.Tx, c.GetRouteVarTrim("token"))
+	if err != nil {
+		log.Errorf("invalid password reset token: %s", err)
+		return ErrPermissionDenied.Here()
+	}
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return err
+	}
+	writePasswordStrength(w, r, requestedUser)
+	return nil
+}
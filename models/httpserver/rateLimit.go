@@ -0,0 +1,56 @@
+package httpserver
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter allows up to `limit` events per key within a sliding `window`.
+// It's kept in memory, so limits reset if the server restarts. That's fine for
+// its purpose: slowing down abuse, rather than enforcing hard quotas.
+//
+// It's goroutine-safe.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window,
+		events: make(map[string][]time.Time)}
+}
+
+// Allow records an event for the key (ignoring case) and returns true if it's
+// within the limit. Events over the limit are not recorded, so a client that
+// keeps retrying is allowed again once its earlier events leave the window.
+func (l *rateLimiter) Allow(key string) bool {
+	key = strings.ToLower(key)
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Forget events outside of the window
+	recent := l.events[key][:0]
+	for _, t := range l.events[key] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.events[key] = recent
+		return false
+	}
+	l.events[key] = append(recent, now)
+	// Occasionally drop keys with no recent events, so the map doesn't grow
+	// forever
+	if len(l.events) > 10000 {
+		for k, times := range l.events {
+			if len(times) == 0 || now.Sub(times[len(times)-1]) >= l.window {
+				delete(l.events, k)
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+package httpserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Hour)
+	if !l.Allow("Jane@Email.com") || !l.Allow("jane@email.com") {
+		t.Fatal("events within the limit were not allowed")
+	}
+	// Keys ignore case, so this is the third event for the same address
+	if l.Allow("JANE@EMAIL.COM") {
+		t.Error("event over the limit was allowed")
+	}
+	if !l.Allow("john@email.com") {
+		t.Error("another key was limited by jane's events")
+	}
+	// Rejected events aren't recorded, so once the first leaves the window,
+	// one more is allowed
+	if n := len(l.events["jane@email.com"]); n != 2 {
+		t.Fatalf("expected 2 recorded events, not %d", n)
+	}
+	l.events["jane@email.com"][0] = time.Now().Add(-2 * time.Hour)
+	if !l.Allow("jane@email.com") {
+		t.Error("event was not allowed after an earlier one left the window")
+	}
+	if l.Allow("jane@email.com") {
+		t.Error("event over the limit was allowed")
+	}
+}
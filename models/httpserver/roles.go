@@ -0,0 +1,191 @@
+package httpserver
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+type roleListPageData struct {
+	Message        string
+	Error          string
+	RequestingUser user.User
+	Roles          []*user.Role
+	CSRFField      template.HTML
+}
+
+type roleDetailPageData struct {
+	Message        string
+	Error          string
+	RequestingUser user.User
+	Role           *user.Role
+	// Permissions lists every permission, so they can be toggled.
+	Permissions []user.PermissionInfo
+	// Groups lists every group name, so they can be bound to this role.
+	Groups    []*user.Group
+	CSRFField template.HTML
+}
+
+// roleChangeError turns errors from the role functions in pkg/user that need
+// the transaction rolled back into a user-friendly ErrBadRequest.
+func roleChangeError(err error) error {
+	if merry.Is(err, user.ErrorNoRoleManagers) {
+		return merry.Here(ErrBadRequest).WithUserMessage(merry.UserMessage(err))
+	}
+	return err
+}
+
+// roleList is a sub-handler that shows all roles, and lets the user create new
+// ones.
+func roleList(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermRolesManage) {
+		return ErrPermissionDenied.Here()
+	}
+	roles, err := user.GetRoles(c.Tx)
+	if err != nil {
+		return err
+	}
+	data := roleListPageData{
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		Roles:          roles,
+		CSRFField:      csrf.TemplateField(r),
+	}
+
+	switch r.Method {
+	case "GET":
+		Render(w, "role_list.html", data)
+		return nil
+	case "POST":
+		name := strings.TrimSpace(r.FormValue("Name"))
+		err = user.AddRole(c.Tx, c.Actor(), name, strings.TrimSpace(r.FormValue("Description")))
+		if err != nil {
+			data.Error = merry.UserMessage(err)
+			Render(w, "role_list.html", data)
+			return nil
+		}
+		log.Infof("%s created role %s", c.User.Username, name)
+		c.AddNormalFlash(fmt.Sprintf("Created role %s.", name))
+		http.Redirect(w, r, "/roles/"+name, http.StatusFound)
+		return nil
+	}
+	return nil
+}
+
+// roleDetail is a sub-handler that shows a single role, and lets the user
+// change its permissions.
+func roleDetail(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermRolesManage) {
+		return ErrPermissionDenied.Here()
+	}
+	name := c.GetRouteVarTrim("rolename")
+	role, err := user.GetRole(c.Tx, name)
+	if merry.Is(err, sql.ErrNoRows) {
+		return ErrNotFound.Here()
+	} else if err != nil {
+		return err
+	}
+	groups, err := user.GetGroupsSliceWithoutUsers(c.Tx)
+	if err != nil {
+		return err
+	}
+	data := roleDetailPageData{
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		Role:           role,
+		Permissions:    user.Permissions,
+		Groups:         groups,
+		CSRFField:      csrf.TemplateField(r),
+	}
+
+	switch r.Method {
+	case "GET":
+		Render(w, "role_detail.html", data)
+		return nil
+	case "POST":
+		err = r.ParseForm()
+		if err != nil {
+			return merry.Here(ErrRequestArgument).WithCause(err)
+		}
+		var permissions []user.Permission
+		for _, p := range r.PostForm["Permission"] {
+			permissions = append(permissions, user.Permission(p))
+		}
+		err = user.SetRolePermissions(c.Tx, c.Actor(), name, permissions)
+		if merry.Is(err, user.ErrorInvalidPermission) {
+			data.Error = merry.UserMessage(err)
+			Render(w, "role_detail.html", data)
+			return nil
+		} else if err != nil {
+			return roleChangeError(err)
+		}
+		log.Infof("%s set the permissions of role %s to %v", c.User.Username,
+			name, permissions)
+		c.AddNormalFlash("Permissions updated.")
+		http.Redirect(w, r, "/roles/"+name, http.StatusFound)
+		return nil
+	}
+	return nil
+}
+
+// roleAddRemoveGroup is a sub-handler that binds a group (from the form) to a
+// role, or unbinds one (from the URL).
+func roleAddRemoveGroup(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermRolesManage) {
+		return ErrPermissionDenied.Here()
+	}
+	role := c.GetRouteVarTrim("rolename")
+	group := c.GetRouteVarTrim("groupname")
+	var err error
+	if group == "" {
+		group = strings.TrimSpace(r.FormValue("Group"))
+		err = user.AddGroupToRole(c.Tx, c.Actor(), role, group)
+		if err != nil && merry.UserMessage(err) != "" {
+			c.AddErrorFlash(merry.UserMessage(err))
+			http.Redirect(w, r, "/roles/"+role, http.StatusFound)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		c.AddNormalFlash(fmt.Sprintf("Members of %s now have the %s role.", group, role))
+	} else {
+		err = user.RemoveGroupFromRole(c.Tx, c.Actor(), role, group)
+		if err != nil {
+			return roleChangeError(err)
+		}
+		c.AddNormalFlash(fmt.Sprintf("Members of %s no longer have the %s role.", group, role))
+	}
+	log.Infof("%s changed the groups of role %s", c.User.Username, role)
+	http.Redirect(w, r, "/roles/"+role, http.StatusFound)
+	return nil
+}
+
+// roleDelete is a sub-handler that deletes a role.
+func roleDelete(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermRolesManage) {
+		return ErrPermissionDenied.Here()
+	}
+	role := c.GetRouteVarTrim("rolename")
+	err := user.DeleteRole(c.Tx, c.Actor(), role)
+	if err != nil {
+		return roleChangeError(err)
+	}
+	log.Infof("%s deleted role %s", c.User.Username, role)
+	c.AddNormalFlash(fmt.Sprintf("Deleted role %s.", role))
+	http.Redirect(w, r, "/roles", http.StatusFound)
+	return nil
+}
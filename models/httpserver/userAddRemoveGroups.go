@@ -11,36 +11,58 @@ import (
 // userAddRemoveGroups is a sub-handler that handles adding or removing a single
 // user from a single group.
 func userAddRemoveGroups(c *Context, w http.ResponseWriter, r *http.Request) error {
-	// Check permissions
-	if !c.User.IsAdmin() {
-		return ErrPermissionDenied.Here()
-	}
 	var err error
 	// Get the requested username from the URL
 	requestedUsername := c.GetRouteVarTrim("username")
 	group := c.GetRouteVarTrim("groupname")
+	// Check permissions
+	if !c.User.CanManageGroupMembers(group) {
+		return ErrPermissionDenied.Here()
+	}
 
 	// Handle the request
 	var flash string
 	operation := c.GetRouteVarTrim("addOrRemove")
 	if operation == "add" {
+		var canAdd bool
+		canAdd, err = canAddGroupMember(c, group)
+		if err != nil {
+			return err
+		} else if !canAdd {
+			return ErrPermissionDenied.Here()
+		}
 		flash = fmt.Sprintf("Adding user %s to group %s ", requestedUsername, group)
-		err = user.AddUserToGroup(c.Tx, requestedUsername, group)
+		err = user.AddUserToGroup(c.Tx, c.Actor(), requestedUsername, group)
 	} else if operation == "remove" {
+		var member user.User
+		member, err = c.GetUser(requestedUsername)
+		if err != nil {
+			return err
+		} else if !c.User.CanRemoveGroupMember(group, member) {
+			return ErrPermissionDenied.Here()
+		}
 		flash = fmt.Sprintf("Removing user %s from group %s ", requestedUsername, group)
-		err = user.RemoveUserFromGroup(c.Tx, requestedUsername, group)
+		err = user.RemoveUserFromGroup(c.Tx, c.Actor(), requestedUsername, group)
 	} else {
 		return merry.Here(ErrRequestArgument).
 			WithMessagef("invalid operation '%s' (must be 'add' or 'remove')",
 				operation)
 	}
 	// Set flash message indicating result
-	if err != nil {
+	if merry.Is(err, user.ErrorUserArchived) {
+		c.AddErrorFlash(merry.UserMessage(err))
+	} else if err != nil {
 		c.AddNormalFlash(flash + "failed.")
-		return err
+		return roleChangeError(err)
+	} else {
+		c.AddNormalFlash(flash + "succeeded.")
+	}
+	// Redirect them to the requested user's details page, or the group's page if
+	// they are a group manager who can't view that user.
+	if c.User.CanViewUser(requestedUsername) {
+		http.Redirect(w, r, "/users/"+requestedUsername, http.StatusFound)
+	} else {
+		http.Redirect(w, r, "/groups/"+group, http.StatusFound)
 	}
-	c.AddNormalFlash(flash + "succeeded.")
-	// Redirect them to the requested user's details page
-	http.Redirect(w, r, "/users/"+requestedUsername, http.StatusFound)
 	return nil
 }
@@ -0,0 +1,107 @@
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+type userDeletePageData struct {
+	Message        string
+	Error          string
+	RequestingUser user.User
+	RequestedUser  user.User
+	// Delete is true when deleting the user, and false when archiving them.
+	Delete    bool
+	CSRFField template.HTML
+}
+
+// userArchiveDelete asks the user to confirm archiving or deleting another
+// user, and then does it. Deleting also requires typing their username, since
+// it can't be undone.
+func userArchiveDelete(c *Context, w http.ResponseWriter, r *http.Request) error {
+	requestedUsername := c.GetRouteVarTrim("username")
+	// Check permissions
+	if !c.User.CanViewUser(requestedUsername) {
+		return ErrPermissionDenied.Here()
+	}
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !c.User.CanDeleteUser(requestedUser) {
+		return ErrPermissionDenied.Here()
+	}
+	// Handle the request
+	data := userDeletePageData{
+		RequestingUser: *c.User,
+		RequestedUser:  requestedUser,
+		Delete:         c.GetRouteVarTrim("action") == "delete",
+		CSRFField:      csrf.TemplateField(r),
+	}
+	if r.Method == "GET" {
+		Render(w, "user_delete.html", data)
+		return nil
+	}
+	if data.Delete {
+		if strings.TrimSpace(r.FormValue("Confirm")) != requestedUsername {
+			data.Error = "The username you typed does not match this user's username."
+			Render(w, "user_delete.html", data)
+			return nil
+		}
+		err = user.DeleteUser(c.Tx, c.Actor(), requestedUsername)
+		if err != nil {
+			return roleChangeError(err)
+		}
+		log.Infof("%s deleted user %s", c.User.Username, requestedUsername)
+		c.AddNormalFlash(fmt.Sprintf("Deleted user %s.", requestedUsername))
+		http.Redirect(w, r, "/users", http.StatusFound)
+		return nil
+	}
+	err = user.ArchiveUser(c.Tx, c.Actor(), requestedUsername)
+	if merry.Is(err, user.ErrorUserArchived) {
+		data.Error = merry.UserMessage(err)
+		Render(w, "user_delete.html", data)
+		return nil
+	} else if err != nil {
+		return roleChangeError(err)
+	}
+	log.Infof("%s archived user %s", c.User.Username, requestedUsername)
+	c.AddNormalFlash(fmt.Sprintf("Archived user %s.", requestedUsername))
+	http.Redirect(w, r, "/users/"+requestedUsername, http.StatusFound)
+	return nil
+}
+
+// userRestore un-archives a user. They stay disabled until enabled.
+func userRestore(c *Context, w http.ResponseWriter, r *http.Request) error {
+	requestedUsername := c.GetRouteVarTrim("username")
+	// Check permissions
+	if !c.User.CanViewUser(requestedUsername) {
+		return ErrPermissionDenied.Here()
+	}
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !c.User.CanDeleteUser(requestedUser) {
+		return ErrPermissionDenied.Here()
+	}
+	// Handle the request
+	err = user.RestoreUser(c.Tx, c.Actor(), requestedUsername)
+	if merry.Is(err, user.ErrorUserNotArchived) {
+		c.AddErrorFlash(merry.UserMessage(err))
+	} else if err != nil {
+		return err
+	} else {
+		c.AddNormalFlash("User restored. Enable them to let them login again.")
+	}
+	http.Redirect(w, r, "/users/"+requestedUsername, http.StatusFound)
+	return nil
+}
@@ -1,9 +1,11 @@
 package httpserver
 
 import (
+	"html/template"
 	"net/http"
 
 	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
 
 	"github.com/joshsziegler/zauth/pkg/user"
 )
@@ -17,6 +19,7 @@ type userDetailData struct {
 	RequestedUser user.User
 	// GroupMembership holds all Groups, and whether RequestedUser is a member.
 	GroupMembership []user.GroupMembership
+	CSRFField       template.HTML
 }
 
 // UserDetailGet is a sub-handler that shows the details for a specific user.
@@ -24,7 +27,7 @@ func UserDetailGet(c *Context, w http.ResponseWriter, r *http.Request) error {
 	// Get the requested username from the URL
 	requestedUsername := c.GetRouteVarTrim("username")
 	// Check permissions
-	if !c.User.CanEditUser(requestedUsername) {
+	if !c.User.CanViewUser(requestedUsername) {
 		return ErrPermissionDenied.Here()
 	}
 	// Handle the request
@@ -46,6 +49,7 @@ func UserDetailGet(c *Context, w http.ResponseWriter, r *http.Request) error {
 		Message:         c.NormalFlashMessage,
 		Error:           c.ErrorFlashMessage,
 		GroupMembership: groupMembership,
+		CSRFField:       csrf.TemplateField(r),
 	}
 
 	// User is viewing this user (or viewing the edit results)
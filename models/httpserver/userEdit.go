@@ -0,0 +1,124 @@
+package httpserver
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+type formEditUser struct {
+	FirstName string
+	LastName  string
+	Email     string
+}
+
+type userEditPageData struct {
+	Message string
+	Error   string
+	// RequestingUser is the one who asked for this page.
+	RequestingUser user.User
+	// RequestedUser is the User they want to edit on this page.
+	RequestedUser user.User
+	Form          formEditUser
+	CSRFField     template.HTML
+}
+
+func newFormEditUser(r *http.Request) formEditUser {
+	f := formEditUser{}
+	f.FirstName = strings.Trim(r.FormValue("FirstName"), " ")
+	f.LastName = strings.Trim(r.FormValue("LastName"), " ")
+	f.Email = strings.Trim(r.FormValue("Email"), " ")
+	return f
+}
+
+// userEdit is a sub-handler that lets users edit their own name and email, and
+// those with PermUsersEdit edit others'. Email changes only take effect once
+// the new address is verified.
+func userEdit(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Get the requested username from the URL
+	requestedUsername := c.GetRouteVarTrim("username")
+	// Check permissions
+	if !c.User.CanViewUser(requestedUsername) {
+		return ErrPermissionDenied.Here()
+	}
+	// Handle the request (RequestedUser is not necessarily RequestingUser!)
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !c.User.CanEditUser(requestedUser) {
+		return ErrPermissionDenied.Here()
+	}
+	data := userEditPageData{
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		RequestedUser:  requestedUser,
+		CSRFField:      csrf.TemplateField(r),
+		Form: formEditUser{FirstName: requestedUser.FirstName,
+			LastName: requestedUser.LastName, Email: requestedUser.Email},
+	}
+
+	switch r.Method {
+	case "GET":
+		Render(w, "user_edit.html", data)
+		return nil
+	case "POST":
+		form := newFormEditUser(r)
+		data.Form = form
+		var messages []string
+		if form.FirstName != requestedUser.FirstName || form.LastName != requestedUser.LastName {
+			err = user.UpdateName(c.Tx, c.Actor(), requestedUsername, form.FirstName, form.LastName)
+			if err != nil {
+				data.Error = merry.UserMessage(err)
+				Render(w, "user_edit.html", data)
+				return nil
+			}
+			messages = append(messages, "Name updated.")
+		}
+		if !strings.EqualFold(form.Email, requestedUser.Email) {
+			err = requestedUser.RequestEmailChange(c.Tx, form.Email)
+			if err != nil {
+				log.Info(err)
+				data.Error = merry.UserMessage(err)
+				Render(w, "user_edit.html", data)
+				return nil
+			}
+			messages = append(messages, "A verification link was sent to "+
+				form.Email+". The email address will change once it's followed.")
+		}
+		if len(messages) < 1 {
+			messages = append(messages, "Nothing changed.")
+		}
+		c.AddNormalFlash(strings.Join(messages, " "))
+		http.Redirect(w, r, "/users/"+requestedUsername, http.StatusFound)
+		return nil
+	}
+	return nil
+}
+
+// VerifyEmailGet is a sub-handler that completes an email address change when
+// the user follows the link sent to their new address.
+func VerifyEmailGet(c *Context, w http.ResponseWriter, r *http.Request) error {
+	token := c.GetRouteVarTrim("token")
+	username, err := user.ConfirmEmailChange(c.Tx, c.Actor(), token)
+	if err != nil {
+		log.Errorf("invalid email verification token: %s", err)
+		c.AddErrorFlash(merry.UserMessage(err))
+	} else {
+		log.Infof("verified new email address for %s", username)
+		c.AddNormalFlash("Your email address has been changed.")
+	}
+	if c.User != nil {
+		http.Redirect(w, r, "/users/"+c.User.Username, http.StatusFound)
+		return nil
+	}
+	http.Redirect(w, r, urlLogin, http.StatusFound)
+	return nil
+}
@@ -0,0 +1,136 @@
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+// maxImportSize limits the size of uploaded import files (in bytes).
+const maxImportSize = 4 << 20
+
+type userImportPageData struct {
+	Message        string
+	Error          string
+	RequestingUser user.User
+	// Data is the CSV or LDIF being imported, so a dry-run can be followed by
+	// the real import without uploading the file again.
+	Data      string
+	Format    string
+	Email     string
+	Results   []user.ImportResult
+	DryRun    bool
+	CSRFField template.HTML
+}
+
+// Counts returns the number of results with each status, for the summary.
+func (d userImportPageData) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, r := range d.Results {
+		counts[r.Status]++
+	}
+	return counts
+}
+
+// parseImport parses the import data in the requested format ("csv" or "ldif").
+func parseImport(format string, data string) ([]user.ImportRow, error) {
+	switch format {
+	case "ldif":
+		return user.ParseImportLDIF(strings.NewReader(data))
+	case "csv":
+		return user.ParseImportCSV(strings.NewReader(data))
+	}
+	return nil, merry.Here(ErrRequestArgument).
+		WithMessagef("invalid import format '%s'", format)
+}
+
+// userImport is a sub-handler that creates many users at once from a CSV or
+// LDIF file. The user must preview the import (a dry-run) before running it.
+func userImport(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Check permissions
+	if !c.User.Can(user.PermUsersCreate) {
+		return ErrPermissionDenied.Here()
+	}
+	data := userImportPageData{
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		Format:         "csv",
+		Email:          user.ImportEmailWelcome,
+		CSRFField:      csrf.TemplateField(r),
+	}
+	if r.Method == "GET" {
+		Render(w, "user_import.html", data)
+		return nil
+	}
+
+	// Use the uploaded file if there is one, otherwise the text area
+	err := r.ParseMultipartForm(maxImportSize)
+	if err != nil && err != http.ErrNotMultipart {
+		return merry.Here(ErrRequestArgument).WithCause(err)
+	}
+	data.Format = r.FormValue("Format")
+	data.Email = r.FormValue("Email")
+	data.Data = r.FormValue("Data")
+	if file, header, err := r.FormFile("File"); err == nil {
+		defer file.Close()
+		content, err := io.ReadAll(io.LimitReader(file, maxImportSize))
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		data.Data = string(content)
+		if strings.EqualFold(filepath.Ext(header.Filename), ".ldif") {
+			data.Format = "ldif"
+		}
+	}
+	rows, err := parseImport(data.Format, data.Data)
+	if err != nil {
+		if merry.Is(err, ErrRequestArgument) {
+			return err
+		}
+		data.Error = merry.UserMessage(err)
+		Render(w, "user_import.html", data)
+		return nil
+	}
+	groupPermissions, err := user.GetGroupPermissions(c.Tx)
+	if err != nil {
+		return err
+	}
+	canAssign := func(group string) bool {
+		return c.User.CanAddGroupMember(group, groupPermissions[group])
+	}
+
+	if r.FormValue("Action") != "import" {
+		data.DryRun = true
+		data.Results, err = user.PlanImport(c.Tx, rows, canAssign)
+		if err != nil {
+			return err
+		}
+		Render(w, "user_import.html", data)
+		return nil
+	}
+	results, created, err := user.Import(c.Tx, c.Actor(), rows, canAssign)
+	if err != nil {
+		return err
+	}
+	log.Infof("%s imported %d users", c.User.Username, len(created))
+	// Queued in this transaction, so they're sent IFF the import is committed
+	err = user.SendImportEmails(c.Tx, created, data.Email)
+	if err != nil {
+		return err
+	}
+	data.Results = results
+	data.Data = "" // Don't let them import the same users twice
+	data.Message = fmt.Sprintf("Imported %d users.", len(created))
+	Render(w, "user_import.html", data)
+	return nil
+}
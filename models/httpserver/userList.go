@@ -9,20 +9,24 @@ import (
 type userListData struct {
 	User  user.User
 	Users map[int64]*(user.User)
+	// ShowArchived is true if archived users are included.
+	ShowArchived bool
 }
 
-// UserListGet shows the user a list of all current zauth users.
+// UserListGet shows the user a list of all current zauth users. Archived users
+// are only shown if the "archived" query parameter is set.
 func UserListGet(c *Context, w http.ResponseWriter, r *http.Request) error {
-	// Only admins can view this page
-	if !c.User.IsAdmin() {
+	// Check permissions
+	if !c.User.Can(user.PermUsersView) {
 		return ErrPermissionDenied.Here()
 	}
 
-	users, err := user.GetUsersMapWithoutGroups(c.Tx)
+	showArchived := r.URL.Query().Get("archived") != ""
+	users, err := user.GetUsersMapWithoutGroups(c.Tx, showArchived)
 	if err != nil {
 		return ErrInternal.Here()
 	}
-	data := userListData{User: *c.User, Users: users}
+	data := userListData{User: *c.User, Users: users, ShowArchived: showArchived}
 	Render(w, "user_list.html", data)
 	return nil
 }
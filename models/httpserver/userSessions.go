@@ -0,0 +1,116 @@
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+type userSessionsPageData struct {
+	Message string
+	Error   string
+	// RequestingUser is the one who asked for this page.
+	RequestingUser user.User
+	// RequestedUser is the User whose sessions are shown.
+	RequestedUser user.User
+	Sessions      []user.Session
+	// CurrentSessionID is the session making this request, so it can be
+	// labeled (it only matches if RequestedUser is RequestingUser).
+	CurrentSessionID int64
+	CSRFField        template.HTML
+}
+
+// getSessionsUser returns the user named in the URL, if the requesting user
+// can see and revoke their sessions.
+func getSessionsUser(c *Context) (user.User, error) {
+	requestedUsername := c.GetRouteVarTrim("username")
+	if !c.User.CanViewUser(requestedUsername) {
+		return user.User{}, ErrPermissionDenied.Here()
+	}
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return user.User{}, merry.Wrap(err)
+	}
+	if !c.User.CanRevokeSessions(requestedUser) {
+		return user.User{}, ErrPermissionDenied.Here()
+	}
+	return requestedUser, nil
+}
+
+// userSessions is a sub-handler that lists a user's active sessions.
+func userSessions(c *Context, w http.ResponseWriter, r *http.Request) error {
+	requestedUser, err := getSessionsUser(c)
+	if err != nil {
+		return err
+	}
+	sessions, err := user.GetSessions(c.Tx, requestedUser.ID)
+	if err != nil {
+		return err
+	}
+	data := userSessionsPageData{
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		RequestedUser:  requestedUser,
+		Sessions:       sessions,
+		CSRFField:      csrf.TemplateField(r),
+	}
+	if requestedUser.Username == c.User.Username {
+		data.CurrentSessionID = c.SessionID
+	}
+	Render(w, "user_sessions.html", data)
+	return nil
+}
+
+// userSessionRevoke is a sub-handler that logs out one of a user's sessions.
+func userSessionRevoke(c *Context, w http.ResponseWriter, r *http.Request) error {
+	requestedUser, err := getSessionsUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(c.GetRouteVarTrim("id"), 10, 64)
+	if err != nil {
+		return merry.Here(ErrRequestArgument).WithCause(err)
+	}
+	err = user.DeleteSession(c.Tx, c.Actor(), requestedUser.ID, id)
+	if err != nil {
+		c.AddErrorFlash(merry.UserMessage(err))
+	} else {
+		log.Infof("%s revoked session %d for %s", c.User.Username, id,
+			requestedUser.Username)
+		c.AddNormalFlash("Session logged out.")
+	}
+	if id == c.SessionID {
+		http.Redirect(w, r, urlLogin, http.StatusFound)
+		return nil
+	}
+	http.Redirect(w, r, "/users/"+requestedUser.Username+"/sessions", http.StatusFound)
+	return nil
+}
+
+// userSessionsRevokeAll is a sub-handler that logs a user out everywhere. If
+// they're logging themself out, this includes the session making the request.
+func userSessionsRevokeAll(c *Context, w http.ResponseWriter, r *http.Request) error {
+	requestedUser, err := getSessionsUser(c)
+	if err != nil {
+		return err
+	}
+	n, err := user.DeleteUserSessions(c.Tx, c.Actor(), requestedUser.Username)
+	if err != nil {
+		return err
+	}
+	c.AddNormalFlash(fmt.Sprintf("Logged out %d sessions.", n))
+	if requestedUser.Username == c.User.Username {
+		http.Redirect(w, r, urlLogin, http.StatusFound)
+		return nil
+	}
+	http.Redirect(w, r, "/users/"+requestedUser.Username+"/sessions", http.StatusFound)
+	return nil
+}
@@ -13,22 +13,33 @@ func userSetEnabled(c *Context, w http.ResponseWriter, r *http.Request) (err err
 	// Get the requested username from the URL
 	requestedUsername := c.GetRouteVarTrim("username")
 	// Check permissions
-	if !c.User.IsAdmin() {
+	if !c.User.CanViewUser(requestedUsername) {
+		return ErrPermissionDenied.Here()
+	}
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !c.User.CanDisableUser(requestedUser) {
 		return ErrPermissionDenied.Here()
 	}
 	// Handle the request
 	operation := c.GetRouteVarTrim("isEnabled")
 	if operation == "enable" {
-		err = user.UserEnable(c.Tx, requestedUsername)
+		err = user.UserEnable(c.Tx, c.Actor(), requestedUsername)
 	} else if operation == "disable" {
-		err = user.UserDisable(c.Tx, requestedUsername)
+		err = user.UserDisable(c.Tx, c.Actor(), requestedUsername)
 	} else {
 		return merry.Here(ErrRequestArgument).
 			WithMessagef("invalid operation '%s' (must be 'enable' or 'disable')",
 				operation)
 	}
 	// Set flash message indicating result
-	if err != nil {
+	if merry.Is(err, user.ErrorNoRoleManagers) {
+		return roleChangeError(err)
+	} else if merry.Is(err, user.ErrorUserArchived) {
+		c.AddErrorFlash(merry.UserMessage(err))
+	} else if err != nil {
 		c.AddNormalFlash(fmt.Sprintf("Failed to %s user.", operation))
 	} else {
 		c.AddNormalFlash(fmt.Sprintf("User successfully %sd.",
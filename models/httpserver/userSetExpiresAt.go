@@ -0,0 +1,45 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/ansel1/merry"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+)
+
+// userSetExpiresAt is a sub-handler that sets or clears when a User's account
+// expires.
+func userSetExpiresAt(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Get the requested username from the URL
+	requestedUsername := c.GetRouteVarTrim("username")
+	// Check permissions
+	if !c.User.CanViewUser(requestedUsername) {
+		return ErrPermissionDenied.Here()
+	}
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !c.User.CanDisableUser(requestedUser) {
+		return ErrPermissionDenied.Here()
+	}
+	// Handle the request
+	expiresAt, err := parseDateInput(r.FormValue("ExpiresAt"))
+	if err != nil {
+		c.AddErrorFlash("Expiration date must be a valid date.")
+		http.Redirect(w, r, "/users/"+requestedUsername, http.StatusFound)
+		return nil
+	}
+	err = user.SetExpiresAt(c.Tx, c.Actor(), requestedUsername, expiresAt)
+	if err != nil {
+		return err
+	}
+	if expiresAt.IsZero() {
+		c.AddNormalFlash("Account will not expire.")
+	} else {
+		c.AddNormalFlash("Account will expire on " + expiresAt.Format("January 2, 2006") + ".")
+	}
+	http.Redirect(w, r, "/users/"+requestedUsername, http.StatusFound)
+	return nil
+}
@@ -16,10 +16,57 @@ type userSetPasswordPageData struct {
 	// RequestingUser is the one who asked for this page.
 	RequestingUser user.User
 	// RequestedUser is the User they want to view on this page.
-	RequestedUser     user.User
-	CSRFField         template.HTML
-	PasswordMinLength int
-	PasswordMaxLength int
+	RequestedUser user.User
+	CSRFField     template.HTML
+	// PasswordPolicy is the RequestedUser's, which the page's rules are
+	// generated from.
+	PasswordPolicy password.Policy
+}
+
+// passwordStrengthResponse is the JSON returned to the password pages, so they
+// can give feedback while the user types.
+type passwordStrengthResponse struct {
+	password.Strength
+	MinScore int `json:"minScore"`
+	// Error is why the password would be rejected, if it would be.
+	Error string `json:"error,omitempty"`
+}
+
+// writePasswordStrength responds with the strength of the POSTed password for
+// the user. It doesn't check their password history, since this doesn't
+// require their current password.
+func writePasswordStrength(w http.ResponseWriter, r *http.Request, u user.User) {
+	newPassword := r.FormValue("Password")
+	policy := u.PasswordPolicy()
+	res := passwordStrengthResponse{
+		Strength: password.EstimateStrength(newPassword, u.Username, u.FirstName,
+			u.LastName),
+		MinScore: policy.MinScore,
+	}
+	err := password.CheckPasswordRules(policy, u.Username, u.FirstName, u.LastName,
+		newPassword)
+	if err != nil {
+		res.Error = merry.UserMessage(err)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// userPasswordStrength is a sub-handler that checks a new password for the
+// set password page.
+func userPasswordStrength(c *Context, w http.ResponseWriter, r *http.Request) error {
+	requestedUsername := c.GetRouteVarTrim("username")
+	if !c.User.CanViewUser(requestedUsername) {
+		return ErrPermissionDenied.Here()
+	}
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !c.User.CanSetPassword(requestedUser) {
+		return ErrPermissionDenied.Here()
+	}
+	writePasswordStrength(w, r, requestedUser)
+	return nil
 }
 
 // userSetPassword is a sub-handler that handles password changes
@@ -27,7 +74,7 @@ func userSetPassword(c *Context, w http.ResponseWriter, r *http.Request) error {
 	// Get the requested username from the URL
 	requestedUsername := c.GetRouteVarTrim("username")
 	// Check permissions
-	if !c.User.CanEditUser(requestedUsername) {
+	if !c.User.CanViewUser(requestedUsername) {
 		return ErrPermissionDenied.Here()
 	}
 	// Handle the request (RequestedUser is not necessarily RequestingUser!)
@@ -35,14 +82,16 @@ func userSetPassword(c *Context, w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return merry.Wrap(err)
 	}
+	if !c.User.CanSetPassword(requestedUser) {
+		return ErrPermissionDenied.Here()
+	}
 	data := userSetPasswordPageData{
-		Message:           c.NormalFlashMessage,
-		Error:             c.ErrorFlashMessage,
-		RequestingUser:    *c.User,
-		RequestedUser:     requestedUser,
-		CSRFField:         csrf.TemplateField(r),
-		PasswordMinLength: password.MinLength,
-		PasswordMaxLength: password.MaxLength,
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		RequestedUser:  requestedUser,
+		CSRFField:      csrf.TemplateField(r),
+		PasswordPolicy: requestedUser.PasswordPolicy(),
 	}
 
 	switch r.Method {
@@ -51,16 +100,47 @@ func userSetPassword(c *Context, w http.ResponseWriter, r *http.Request) error {
 		return nil
 	case "POST":
 		newPassword := r.FormValue("NewPassword")
+		// Keep them remembered if they changed their own password
+		remember := false
+		if requestedUsername == c.User.Username {
+			remember, err = isSessionRemembered(c)
+			if err != nil {
+				return err
+			}
+		}
 		// Try to set the user's password (will check password rules)
-		err = user.SetUserPassword(c.Tx, requestedUsername, newPassword)
+		err = user.SetUserPassword(c.Tx, c.Actor(), requestedUsername, newPassword)
 		if err != nil {
 			data.Error = merry.UserMessage(err)
 			Render(w, "user_set_password.html", data)
 			return nil
 		}
+		// Changing the password revoked all of their sessions, including this
+		// one, so give them a new session if they changed their own
+		if requestedUsername == c.User.Username {
+			err = setLoggedIn(c, w, r, requestedUsername, remember)
+			if err != nil {
+				return err
+			}
+		}
 		c.AddNormalFlash("Password changed successfully.")
 		http.Redirect(w, r, "/users/"+requestedUsername, http.StatusFound)
 		return nil
 	}
 	return nil
 }
+
+// isSessionRemembered returns true if the requesting user's current session
+// was remembered (i.e. they checked "Remember me").
+func isSessionRemembered(c *Context) (bool, error) {
+	sessions, err := user.GetSessions(c.Tx, c.User.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range sessions {
+		if s.ID == c.SessionID {
+			return s.Remember, nil
+		}
+	}
+	return false, nil
+}
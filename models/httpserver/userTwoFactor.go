@@ -0,0 +1,137 @@
+package httpserver
+
+import (
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/csrf"
+	"github.com/skip2/go-qrcode"
+
+	"github.com/joshsziegler/zauth/pkg/totp"
+	"github.com/joshsziegler/zauth/pkg/user"
+)
+
+const (
+	// totpIssuer is the name shown for this account in authenticator apps.
+	totpIssuer = `zauth`
+)
+
+type userTwoFactorPageData struct {
+	Message string
+	Error   string
+	// RequestingUser is the one who asked for this page.
+	RequestingUser user.User
+	// RequestedUser is the User whose two-factor settings are shown.
+	RequestedUser user.User
+	// Secret and QRCode are only set while the user is enrolling.
+	Secret string
+	QRCode template.URL
+	// RecoveryCodes are only set immediately after they are (re)generated.
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
+	CSRFField         template.HTML
+}
+
+// userTwoFactor is a sub-handler that lets a user enroll in, or disable,
+// two-factor authentication using an authenticator app (TOTP). Users with
+// PermUsersEdit may disable two-factor authentication for other users (e.g. a
+// lost phone), but only the user themself can enroll.
+func userTwoFactor(c *Context, w http.ResponseWriter, r *http.Request) error {
+	// Get the requested username from the URL
+	requestedUsername := c.GetRouteVarTrim("username")
+	isSelf := c.User.Username == requestedUsername
+	// Check permissions
+	if !c.User.CanViewUser(requestedUsername) {
+		return ErrPermissionDenied.Here()
+	}
+	requestedUser, err := c.GetUser(requestedUsername)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !c.User.CanEditUser(requestedUser) {
+		return ErrPermissionDenied.Here()
+	}
+	data := userTwoFactorPageData{
+		Message:        c.NormalFlashMessage,
+		Error:          c.ErrorFlashMessage,
+		RequestingUser: *c.User,
+		RequestedUser:  requestedUser,
+		CSRFField:      csrf.TemplateField(r),
+	}
+
+	if r.Method == "POST" {
+		code := strings.TrimSpace(r.FormValue("code"))
+		switch r.FormValue("action") {
+		case "confirm":
+			if !isSelf {
+				return ErrPermissionDenied.Here()
+			}
+			data.RecoveryCodes, err = user.ConfirmTOTPEnrollment(c.Tx, c.Actor(), requestedUsername, code)
+			if err != nil {
+				data.Error = merry.UserMessage(err)
+				break
+			}
+			data.Message = "Two-factor authentication is now enabled."
+			data.RequestedUser.TOTPEnabled = true
+		case "recovery":
+			if !isSelf {
+				return ErrPermissionDenied.Here()
+			}
+			err = user.CheckSecondFactor(c.Tx, c.Actor(), requestedUsername, code)
+			if err != nil {
+				data.Error = merry.UserMessage(err)
+				break
+			}
+			data.RecoveryCodes, err = user.RegenerateRecoveryCodes(c.Tx, c.Actor(), requestedUsername)
+			if err != nil {
+				return err
+			}
+			data.Message = "New recovery codes created. Your old codes no longer work."
+		case "disable":
+			// Users must prove they still have their device. Those resetting
+			// another user's account do not need to.
+			if isSelf {
+				err = user.CheckSecondFactor(c.Tx, c.Actor(), requestedUsername, code)
+				if err != nil {
+					data.Error = merry.UserMessage(err)
+					break
+				}
+			}
+			err = user.DisableTOTP(c.Tx, c.Actor(), requestedUsername)
+			if err != nil {
+				return err
+			}
+			c.AddNormalFlash("Two-factor authentication disabled.")
+			http.Redirect(w, r, "/users/"+requestedUsername+"/2fa", http.StatusFound)
+			return nil
+		default:
+			return ErrBadRequest.Here()
+		}
+	}
+
+	if !data.RequestedUser.TOTPEnabled && isSelf {
+		data.Secret, err = user.BeginTOTPEnrollment(c.Tx, requestedUsername)
+		if err != nil {
+			return err
+		}
+		uri := totp.URI(totpIssuer, requestedUsername, data.Secret)
+		png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		data.QRCode = template.URL("data:image/png;base64," +
+			base64.StdEncoding.EncodeToString(png))
+	}
+	if data.RequestedUser.TOTPEnabled {
+		data.RecoveryCodesLeft, err = user.GetUnusedRecoveryCodeCount(c.Tx,
+			requestedUser.ID)
+		if err != nil {
+			return err
+		}
+	}
+	Render(w, "user_2fa.html", data)
+	return nil
+}
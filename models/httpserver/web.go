@@ -3,6 +3,7 @@ package httpserver
 import (
 	"html/template"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/joshsziegler/zauth/pkg/httpserver"
 	"github.com/joshsziegler/zauth/pkg/secrets"
+	"github.com/joshsziegler/zauth/pkg/user"
 	"github.com/joshsziegler/zgo/pkg/log"
 )
 
@@ -24,26 +26,73 @@ var (
 	store *sessions.CookieStore
 	// templates holds our loaded Go/HTML templates
 	templates *template.Template
+	// rememberMeEnabled is true if users may choose to stay logged in
+	rememberMeEnabled bool
 )
 
+// Config is used to pass configuration options for the Web server. Zero values
+// use the defaults.
+type Config struct {
+	ListenTo string
+	// IdleTimeoutMinutes is how long a session may go unused before the user
+	// is logged out (default: 15).
+	IdleTimeoutMinutes int
+	// LifetimeHours is how long a session lasts, even if it's in use
+	// (default: 12).
+	LifetimeHours int
+	// RememberMeDays is how long users stay logged in if they check "Remember
+	// me" when logging in. Remembered sessions don't time out when idle. Zero
+	// hides the checkbox.
+	RememberMeDays int
+	// CookieSecure only sends cookies over HTTPS. This is always true in
+	// production.
+	CookieSecure bool
+	// CookieDomain allows cookies to be sent to this domain and its subdomains.
+	// By default, they are only sent to the exact host.
+	CookieDomain string
+}
+
 const (
 	sessionName = `zauth-session`
-	urlLogin    = `/login`
+	// loginSessionName is the cookie holding the user's session token. It's
+	// separate from sessionName so it can be remembered after the browser
+	// closes (see setLoggedIn).
+	loginSessionName  = `zauth-login`
+	sessionTokenKey   = `SessionToken`
+	urlLogin          = `/login`
+	urlLoginTwoFactor = `/login/2fa`
 )
 
 // Listen performs setup and runs the Web server (blocking)
-func Listen(database *sqlx.DB, listenTo string, isProduction bool) {
+func Listen(database *sqlx.DB, config Config, isProduction bool) {
 	DB = database
 
-	// Setup sessions using secure cookies
+	// Configure how long sessions last
+	if config.IdleTimeoutMinutes > 0 {
+		user.SessionIdleTimeout = time.Duration(config.IdleTimeoutMinutes) * time.Minute
+	}
+	if config.LifetimeHours > 0 {
+		user.SessionLifetime = time.Duration(config.LifetimeHours) * time.Hour
+	}
+	rememberMeEnabled = config.RememberMeDays > 0
+	if rememberMeEnabled {
+		user.SessionRememberLifetime = time.Duration(config.RememberMeDays) * 24 * time.Hour
+	}
+
+	// Setup sessions using secure cookies. Logins are stored in the database,
+	// and the cookie only holds the session's token (see user.CreateSession).
 	store = sessions.NewCookieStore(secrets.AuthKey(), secrets.EncryptionKey())
+	// Cookies can't outlive the longest session (this also sets the cookie's
+	// MaxAge, which is overridden below)
+	store.MaxAge(int(maxDuration(user.SessionLifetime, user.SessionRememberLifetime).Seconds()))
 	// Set Cookie options to expire sessions and protect against some attacks
 	store.Options = &sessions.Options{
-		Path:     "/",                  // Send cookies with every page request for this domain
-		MaxAge:   60 * 15,              // Expire in 15 minutes to force logout TODO: Allow to be configured
-		SameSite: http.SameSiteLaxMode, // XSS protection; See:  https://developer.mozilla.org/en-US/docs/Web/HTTP/Cookies#SameSite_cookies
-		HttpOnly: true,                 // Prevent JavaScript access to this cookie
-		// Secure:   true,                    // Only sent over HTTPS
+		Path:     "/",                                 // Send cookies with every page request for this domain
+		Domain:   config.CookieDomain,                 // Empty means only this host
+		MaxAge:   0,                                   // Delete when the browser closes, unless remembered (see setLoggedIn)
+		SameSite: http.SameSiteLaxMode,                // XSS protection; See:  https://developer.mozilla.org/en-US/docs/Web/HTTP/Cookies#SameSite_cookies
+		HttpOnly: true,                                // Prevent JavaScript access to this cookie
+		Secure:   config.CookieSecure || isProduction, // Only sent over HTTPS
 	}
 	// Load static assets (from disk [dev] or binary [build])
 	boxStatic := packr.NewBox("../../public")
@@ -57,25 +106,73 @@ func Listen(database *sqlx.DB, listenTo string, isProduction bool) {
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(boxStatic)))
 	r.Handle("/", Wrap(r, LoginOrUserPageGet, false)).Methods("GET")
 	r.Handle(urlLogin, Wrap(r, LoginGetPost, false)).Methods("GET", "POST").Name("login")
+	r.Handle(urlLoginTwoFactor, Wrap(r, LoginTwoFactorGetPost, false)).Methods("GET", "POST")
+	r.Handle(urlLoginTwoFactor+"/passkey/begin", Wrap(r, loginTwoFactorPasskeyBegin, false)).Methods("POST")
+	r.Handle(urlLoginTwoFactor+"/passkey/finish", Wrap(r, loginTwoFactorPasskeyFinish, false)).Methods("POST")
+	r.Handle("/login/passkey/begin", Wrap(r, loginPasskeyBegin, false)).Methods("POST")
+	r.Handle("/login/passkey/finish", Wrap(r, loginPasskeyFinish, false)).Methods("POST")
 	r.Handle("/logout", Wrap(r, LogoutGet, true)).Methods("GET")
 	r.Handle("/user/new", Wrap(r, NewUserGet, true)).Methods("GET")
 	r.Handle("/user/new", Wrap(r, NewUserPost, true)).Methods("POST")
 	r.Handle("/users", Wrap(r, UserListGet, true)).Methods("GET")
+	r.Handle("/users/import", Wrap(r, userImport, true)).Methods("GET", "POST")
 	r.Handle("/users/{username}", Wrap(r, UserDetailGet, true)).Methods("GET").Name("userDetail")
 	r.Handle("/users/{username}/password", Wrap(r, userSetPassword, true)).Methods("GET", "POST")
-	r.Handle("/users/{username}/{isEnabled:(?:enable|disable)}", Wrap(r, userSetEnabled, true)).Methods("GET")
-	r.Handle("/users/{username}/groups/{groupname}/{addOrRemove:(?:add|remove)}", Wrap(r, userAddRemoveGroups, true)).Methods("GET")
+	r.Handle("/users/{username}/password/strength", Wrap(r, userPasswordStrength, true)).Methods("POST")
+	r.Handle("/users/{username}/edit", Wrap(r, userEdit, true)).Methods("GET", "POST")
+	r.Handle("/users/{username}/2fa", Wrap(r, userTwoFactor, true)).Methods("GET", "POST")
+	r.Handle("/users/{username}/passkeys", Wrap(r, userPasskeys, true)).Methods("GET")
+	r.Handle("/users/{username}/passkeys/register/begin", Wrap(r, userPasskeyRegisterBegin, true)).Methods("POST")
+	r.Handle("/users/{username}/passkeys/register/finish", Wrap(r, userPasskeyRegisterFinish, true)).Methods("POST")
+	r.Handle("/users/{username}/passkeys/{id:[0-9]+}/delete", Wrap(r, userPasskeyDelete, true)).Methods("POST")
+	r.Handle("/users/{username}/sessions", Wrap(r, userSessions, true)).Methods("GET")
+	r.Handle("/users/{username}/sessions/revoke", Wrap(r, userSessionsRevokeAll, true)).Methods("POST")
+	r.Handle("/users/{username}/sessions/{id:[0-9]+}/revoke", Wrap(r, userSessionRevoke, true)).Methods("POST")
+	r.Handle("/users/{username}/expires", Wrap(r, userSetExpiresAt, true)).Methods("POST")
+	r.Handle("/users/{username}/{isEnabled:(?:enable|disable)}", Wrap(r, userSetEnabled, true)).Methods("POST")
+	r.Handle("/users/{username}/{action:(?:archive|delete)}", Wrap(r, userArchiveDelete, true)).Methods("GET", "POST")
+	r.Handle("/users/{username}/restore", Wrap(r, userRestore, true)).Methods("POST")
+	r.Handle("/users/{username}/groups/{groupname}/{addOrRemove:(?:add|remove)}", Wrap(r, userAddRemoveGroups, true)).Methods("POST")
 	r.Handle("/groups", Wrap(r, GroupListGet, true)).Methods("GET")
 	r.Handle("/group/new", Wrap(r, NewGroupGet, true)).Methods("GET")
 	r.Handle("/group/new", Wrap(r, NewGroupPost, true)).Methods("POST")
-	// /groups/{groupname} - If none, show all if admin or redirect to self TODO: implement
+	r.Handle("/groups/{groupname}/2fa/{requireOrOptional:(?:require|optional)}", Wrap(r, groupRequire2FA, true)).Methods("POST")
+	r.Handle("/groups/{groupname}", Wrap(r, groupDetail, true)).Methods("GET")
+	r.Handle("/groups/{groupname}/description", Wrap(r, groupSetDescription, true)).Methods("POST")
+	r.Handle("/groups/{groupname}/members", Wrap(r, groupAddRemoveMember, true)).Methods("POST")
+	r.Handle("/groups/{groupname}/members/{username}/remove", Wrap(r, groupAddRemoveMember, true)).Methods("POST")
+	r.Handle("/groups/{groupname}/managers", Wrap(r, groupAddRemoveManager, true)).Methods("POST")
+	r.Handle("/groups/{groupname}/managers/{username}/remove", Wrap(r, groupAddRemoveManager, true)).Methods("POST")
+	r.Handle("/groups/{groupname}/delete", Wrap(r, groupDelete, true)).Methods("GET", "POST")
+	r.Handle("/export", Wrap(r, exportDirectory, true)).Methods("GET", "POST")
+	r.Handle("/audit", Wrap(r, auditLog, true)).Methods("GET")
+	r.Handle("/emails", Wrap(r, emailPreview, true)).Methods("GET")
+	r.Handle("/emails/outbox", Wrap(r, emailOutbox, true)).Methods("GET")
+	r.Handle("/emails/outbox/{id:[0-9]+}/resend", Wrap(r, emailResend, true)).Methods("POST")
+	r.Handle("/emails/{name}", Wrap(r, emailPreview, true)).Methods("GET")
+	r.Handle("/roles", Wrap(r, roleList, true)).Methods("GET", "POST")
+	r.Handle("/roles/{rolename}", Wrap(r, roleDetail, true)).Methods("GET", "POST")
+	r.Handle("/roles/{rolename}/groups", Wrap(r, roleAddRemoveGroup, true)).Methods("POST")
+	r.Handle("/roles/{rolename}/groups/{groupname}/remove", Wrap(r, roleAddRemoveGroup, true)).Methods("POST")
+	r.Handle("/roles/{rolename}/delete", Wrap(r, roleDelete, true)).Methods("POST")
 	r.Handle("/reset-password/{token}", Wrap(r, PasswordResetGetPost, false)).Methods("GET", "POST")
+	r.Handle("/reset-password/{token}/strength", Wrap(r, passwordResetStrength, false)).Methods("POST")
+	r.Handle("/forgot-password", Wrap(r, ForgotPasswordGetPost, false)).Methods("GET", "POST")
+	r.Handle("/verify-email/{token}", Wrap(r, VerifyEmailGet, false)).Methods("GET")
 
 	// Start the HTTP servers
-	log.Infof("HTTP server listening on: %s", listenTo)
-	err := http.ListenAndServe(listenTo,
+	log.Infof("HTTP server listening on: %s", config.ListenTo)
+	err := http.ListenAndServe(config.ListenTo,
 		csrf.Protect(secrets.CSRFKey(), csrf.Secure(isProduction))(r))
 	if err != nil {
 		log.Fatalf("error running http server: %s", err)
 	}
 }
+
+// maxDuration returns the longer of the two durations.
+func maxDuration(a time.Duration, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,189 @@
+// Package audit records who changed what, so administrative and authentication
+// events can be reviewed later.
+//
+// Events are written in the same transaction as the change they describe, so
+// an event exists IFF the change was committed.
+package audit
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+)
+
+// Channel is how the actor made the change.
+type Channel string
+
+const (
+	ChannelWeb  Channel = "web"
+	ChannelLDAP Channel = "ldap"
+	ChannelAPI  Channel = "api"
+	// ChannelCLI is used by our command line sub-commands (e.g. import).
+	ChannelCLI Channel = "cli"
+	// ChannelSystem is used by zauth's background jobs (e.g. expiring
+	// accounts).
+	ChannelSystem Channel = "system"
+)
+
+const (
+	// maxValueLength is the size of the ValueBefore and ValueAfter columns.
+	maxValueLength = 1000
+	// DefaultLimit is how many events Search returns if the filter has no
+	// limit.
+	DefaultLimit = 200
+)
+
+// Actor is who made a change, and from where.
+type Actor struct {
+	// Username is who made the change. It's the user being logged in for
+	// authentication events, even if they aren't logged in yet.
+	Username string
+	IP       string
+	Channel  Channel
+}
+
+// As returns a copy of the actor with a different username, for
+// authentication events where the user isn't logged in yet.
+func (a Actor) As(username string) Actor {
+	a.Username = username
+	return a
+}
+
+// Event is a single recorded change or authentication attempt.
+type Event struct {
+	ID   int64     `db:"ID"` // Database ID
+	Time time.Time `db:"Time"`
+	// Actor is the username of who made the change.
+	Actor string `db:"Actor"`
+	// Action is what happened, such as 'user.disable' or 'group.member_add'.
+	Action string `db:"Action"`
+	// Target is the name of the user, group, or role that was changed.
+	Target string `db:"Target"`
+	// Before and After are the changed values, if any.
+	Before  string  `db:"ValueBefore"`
+	After   string  `db:"ValueAfter"`
+	IP      string  `db:"IP"`
+	Channel Channel `db:"Channel"`
+}
+
+// Filter selects which events to return from Search. Empty fields match
+// everything.
+type Filter struct {
+	Actor string
+	// Action matches actions starting with this (e.g. 'group.' matches all
+	// group events).
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// truncate shortens the value to fit its column.
+func truncate(value string) string {
+	if len(value) <= maxValueLength {
+		return value
+	}
+	value = value[:maxValueLength-3]
+	// Don't split a multi-byte character
+	for !utf8.ValidString(value) {
+		value = value[:len(value)-1]
+	}
+	return value + "..."
+}
+
+// Record saves an event for the action the actor took on the target. Before
+// and after may be empty if they don't apply (e.g. deleting a group).
+func Record(tx *sqlx.Tx, actor Actor, action string, target string,
+	before string, after string) error {
+	_, err := tx.Exec(`INSERT INTO AuditEvents (Time, Actor, Action, Target,
+							ValueBefore, ValueAfter, IP, Channel)
+					   VALUES (?,?,?,?,?,?,?,?)`,
+		time.Now(), actor.Username, action, target, truncate(before),
+		truncate(after), actor.IP, actor.Channel)
+	if err != nil {
+		return merry.Prependf(err, "error recording audit event %s on '%s'",
+			action, target)
+	}
+	return nil
+}
+
+// Search returns the events matching the filter, newest first.
+func Search(tx *sqlx.Tx, filter Filter) (events []Event, err error) {
+	var where []string
+	var args []interface{}
+	if filter.Actor != "" {
+		where = append(where, "Actor=?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		where = append(where, "Action LIKE ?")
+		args = append(args, escapeLike(filter.Action)+"%")
+	}
+	if filter.Target != "" {
+		where = append(where, "Target=?")
+		args = append(args, filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "Time>=?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "Time<?")
+		args = append(args, filter.Until)
+	}
+	query := `SELECT * FROM AuditEvents`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	query += ` ORDER BY Time DESC, ID DESC LIMIT ` + strconv.Itoa(limit)
+	err = tx.Select(&events, query, args...)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return events, nil
+}
+
+// escapeLike escapes the wildcards in a MySQL LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// WriteCSV writes the events as CSV, with a header row.
+func WriteCSV(w io.Writer, events []Event) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"Time", "Actor", "Action", "Target", "Before",
+		"After", "IP", "Channel"})
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	for _, e := range events {
+		err = cw.Write([]string{e.Time.UTC().Format(time.RFC3339), e.Actor,
+			e.Action, e.Target, csvSafe(e.Before), csvSafe(e.After), e.IP,
+			string(e.Channel)})
+		if err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	cw.Flush()
+	return merry.Wrap(cw.Error())
+}
+
+// csvSafe prevents values from being run as formulas when the CSV is opened in
+// a spreadsheet, since users control some of them (e.g. names).
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@") {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	if truncate("short") != "short" {
+		t.Error("short values should not be changed")
+	}
+	// Each é is two bytes, so the cut lands in the middle of one
+	long := "a" + strings.Repeat("é", maxValueLength)
+	got := truncate(long)
+	if len(got) > maxValueLength || !utf8.ValidString(got) ||
+		!strings.HasSuffix(got, "...") {
+		t.Errorf("truncate returned invalid value of length %d", len(got))
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	events := []Event{{Time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Actor: "root", Action: "user.name", Target: "bob", Before: "Bob",
+		After: "=HYPERLINK(\"x\")", IP: "10.0.0.1", Channel: ChannelWeb}}
+	var sb strings.Builder
+	err := WriteCSV(&sb, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Time,Actor,Action,Target,Before,After,IP,Channel\n" +
+		"2020-01-02T03:04:05Z,root,user.name,bob,Bob,\"'=HYPERLINK(\"\"x\"\")\",10.0.0.1,web\n"
+	if sb.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`user_%\`); got != `user\_\%\\` {
+		t.Errorf("escapeLike returned %s", got)
+	}
+}
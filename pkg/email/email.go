@@ -1,32 +1,140 @@
+// Package email sends our emails using one of several transports (see
+// Mailer), chosen in the config.
 package email
 
 import (
+	"sync"
+
 	"github.com/ansel1/merry"
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
-var errorNotAccepted = merry.New("email request not accepted by SendGrid")
-var sendGridAPIKey string
+const (
+	// TransportSendGrid sends email using SendGrid's API.
+	TransportSendGrid = "sendgrid"
+	// TransportSMTP sends email using an SMTP server (e.g. a local relay).
+	TransportSMTP = "smtp"
+	// TransportFile writes each email to a file, for development.
+	TransportFile = "file"
+	// TransportLog writes each email to our log, for development. This is the
+	// default, so nothing is sent until a transport is configured.
+	TransportLog = "log"
+)
+
+// Message is a single email to a single recipient, without attachments.
+type Message struct {
+	FromName  string
+	FromEmail string
+	ToName    string
+	ToEmail   string
+	Subject   string
+	// Plain is the plain text body, and HTML is the same message in HTML.
+	// Either may be empty, but not both.
+	Plain string
+	HTML  string
+}
+
+// Mailer sends emails. It MUST be safe to use from multiple goroutines.
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Config chooses and configures how email is sent.
+type Config struct {
+	// Transport is one of TransportSendGrid, TransportSMTP, TransportFile, or
+	// TransportLog. Zero uses TransportLog.
+	Transport      string
+	SendGridAPIKey string
+	SMTP           SMTPConfig
+	// Directory is where TransportFile writes emails.
+	Directory string
+}
+
+var (
+	// mailer is used by Send. It's only changed by Init and SetMailer.
+	mailer   Mailer = logMailer{}
+	mailerMu sync.RWMutex
+)
 
-// Init sets the API key for SendGrid
-func Init(SendGridAPIKey string) {
-	sendGridAPIKey = SendGridAPIKey
+// Init chooses the Mailer used by Send.
+func Init(config Config) error {
+	var m Mailer
+	switch config.Transport {
+	case TransportSendGrid:
+		if config.SendGridAPIKey == "" {
+			return merry.New("email SendGridAPIKey is required for the sendgrid transport")
+		}
+		m = sendGridMailer{apiKey: config.SendGridAPIKey}
+	case TransportSMTP:
+		smtpMailer, err := newSMTPMailer(config.SMTP)
+		if err != nil {
+			return err
+		}
+		m = smtpMailer
+	case TransportFile:
+		if config.Directory == "" {
+			return merry.New("email Directory is required for the file transport")
+		}
+		m = fileMailer{directory: config.Directory}
+	case TransportLog, "":
+		m = logMailer{}
+	default:
+		return merry.Errorf("unknown email transport '%s' (must be sendgrid, smtp, "+
+			"file, or log)", config.Transport)
+	}
+	SetMailer(m)
+	return nil
 }
 
-// Send a single email to a single user without attachments.
+// SetMailer replaces the Mailer used by Send, and returns the previous one.
+// This is mostly for tests, which can capture outgoing email with a Recorder.
+func SetMailer(m Mailer) (previous Mailer) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	previous, mailer = mailer, m
+	return previous
+}
+
+// Send a single email to a single user without attachments, using the
+// configured Mailer.
 func Send(fromName string, fromEmail string,
 	toName string, toEmail string,
 	subject string, plainMsg string, htmlMsg string) error {
-	from := mail.NewEmail(fromName, fromEmail)
-	to := mail.NewEmail(toName, toEmail)
-	message := mail.NewSingleEmail(from, subject, to, plainMsg, htmlMsg)
-	client := sendgrid.NewSendClient(sendGridAPIKey)
-	response, err := client.Send(message)
-	if err != nil {
-		return merry.Wrap(err)
-	} else if response.StatusCode != 202 {
-		return errorNotAccepted.Here()
-	}
+	return SendMessage(Message{
+		FromName:  fromName,
+		FromEmail: fromEmail,
+		ToName:    toName,
+		ToEmail:   toEmail,
+		Subject:   subject,
+		Plain:     plainMsg,
+		HTML:      htmlMsg,
+	})
+}
+
+// SendMessage sends the message using the configured Mailer.
+func SendMessage(message Message) error {
+	mailerMu.RLock()
+	m := mailer
+	mailerMu.RUnlock()
+	return m.Send(message)
+}
+
+// Recorder is a Mailer which keeps every message instead of sending it, so
+// tests can check what would have been sent.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (r *Recorder) Send(message Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, message)
 	return nil
 }
+
+// Messages returns a copy of every message sent so far.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Message(nil), r.messages...)
+}
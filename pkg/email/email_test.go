@@ -0,0 +1,214 @@
+package email
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = Message{
+	FromName:  "zauth",
+	FromEmail: "no-reply@example.com",
+	ToName:    "José Núñez",
+	ToEmail:   "jose@example.com",
+	Subject:   "Your New Account ✓",
+	Plain:     "Hello José,\n\nWelcome!",
+	HTML:      "<p>Hello José,</p><p>Welcome!</p>",
+}
+
+// checkMessage parses an email from Message.Bytes, and checks it matches
+// testMessage.
+func checkMessage(t *testing.T, data []byte) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := mail.ParseAddress(msg.Header.Get("To"))
+	if err != nil || to.Name != testMessage.ToName || to.Address != testMessage.ToEmail {
+		t.Errorf("wrong To: %v, %v", to, err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != testMessage.Subject {
+		t.Errorf("wrong Subject: %s, %v", subject, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("wrong Content-Type: %s, %v", mediaType, err)
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for _, expected := range []string{testMessage.Plain, testMessage.HTML} {
+		part, err := parts.NextPart() // Decodes quoted-printable
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		// Line breaks are sent as CRLF
+		if strings.ReplaceAll(string(body), "\r\n", "\n") != expected {
+			t.Errorf("wrong body: %q, expected %q", body, expected)
+		}
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	data, err := testMessage.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, data)
+
+	// Line breaks in headers must not add other headers
+	evil := testMessage
+	evil.Subject = "Hi\r\nBcc: victim@example.com"
+	data, err = evil.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil || msg.Header.Get("Bcc") != "" {
+		t.Errorf("subject added a header: %v", err)
+	}
+	if _, err = (Message{ToEmail: "a@example.com"}).Bytes(); err == nil {
+		t.Error("messages without a body should return an error")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	directory := t.TempDir()
+	err := Init(Config{Transport: TransportFile, Directory: directory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetMailer(logMailer{})
+	err = Send(testMessage.FromName, testMessage.FromEmail, testMessage.ToName,
+		testMessage.ToEmail, testMessage.Subject, testMessage.Plain, testMessage.HTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(directory, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 email file, found %d", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, data)
+}
+
+func TestInitInvalid(t *testing.T) {
+	for _, config := range []Config{
+		{Transport: "carrier-pigeon"},
+		{Transport: TransportSendGrid},
+		{Transport: TransportFile},
+		{Transport: TransportSMTP},
+		{Transport: TransportSMTP, SMTP: SMTPConfig{Host: "localhost", Security: "maybe"}},
+		{Transport: TransportSMTP, SMTP: SMTPConfig{Host: "localhost",
+			Security: SMTPSecurityNone, Username: "user"}},
+	} {
+		if err := Init(config); err == nil {
+			t.Errorf("%+v should be invalid", config)
+		}
+	}
+}
+
+// fakeSMTPServer accepts a single email on a local port, and sends it to the
+// returned channel.
+func fakeSMTPServer(t *testing.T) (port int, received chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received = make(chan string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		write := func(line string) { io.WriteString(conn, line+"\r\n") }
+		write("220 localhost ESMTP")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.Fields(line + " x")[0]); command {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				write("250 OK")
+			case "DATA":
+				write("354 Go ahead")
+				for {
+					line, err = r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				write("250 Queued")
+			case "QUIT":
+				write("221 Bye")
+				return
+			default:
+				write("502 Not implemented")
+			}
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	port, received := fakeSMTPServer(t)
+	m, err := newSMTPMailer(SMTPConfig{Host: "127.0.0.1", Port: port,
+		Security: SMTPSecurityNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Send(testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, []byte(<-received))
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := &Recorder{}
+	previous := SetMailer(recorder)
+	defer SetMailer(previous)
+	err := Send("a", "a@example.com", "b", "b@example.com", "Subject", "Plain", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := recorder.Messages()
+	if len(messages) != 1 || messages[0].ToEmail != "b@example.com" ||
+		messages[0].Subject != "Subject" {
+		t.Errorf("unexpected messages: %+v", messages)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		5:  16 * time.Minute,
+		9:  256 * time.Minute,
+		10: outboxMaxRetryDelay,
+		50: outboxMaxRetryDelay,
+	} {
+		if delay := retryDelay(attempts); delay != expected {
+			t.Errorf("after %d attempts, waited %s, expected %s", attempts, delay, expected)
+		}
+	}
+}
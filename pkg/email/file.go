@@ -0,0 +1,55 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+// fileMailer writes each email to its own .eml file in a directory, which most
+// email clients can open. It's meant for development.
+type fileMailer struct {
+	directory string
+}
+
+func (m fileMailer) Send(message Message) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(m.directory, 0700)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	random := make([]byte, 4)
+	_, _ = rand.Read(random) // Never returns an error
+	name := time.Now().Format("20060102-150405.000000") + "-" +
+		hex.EncodeToString(random) + ".eml"
+	path := filepath.Join(m.directory, name)
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	log.Infof("email: wrote '%s' to %s as %s", message.Subject, message.ToEmail, path)
+	return nil
+}
+
+// logMailer writes each email's plain text to our log, instead of sending it.
+// It's meant for development.
+type logMailer struct{}
+
+func (m logMailer) Send(message Message) error {
+	body := message.Plain
+	if body == "" {
+		body = message.HTML
+	}
+	log.Infof("email: not sending (the log transport is configured)\nFrom: %s <%s>\n"+
+		"To: %s <%s>\nSubject: %s\n\n%s", message.FromName, message.FromEmail,
+		message.ToName, message.ToEmail, message.Subject, body)
+	return nil
+}
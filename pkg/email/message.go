@@ -0,0 +1,104 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// Bytes returns the message in the Internet Message Format (RFC 5322), as
+// sent over SMTP. It has both a plain text and an HTML part if both are set.
+func (m Message) Bytes() ([]byte, error) {
+	if m.Plain == "" && m.HTML == "" {
+		return nil, merry.New("email has no body")
+	}
+	from := mail.Address{Name: m.FromName, Address: m.FromEmail}
+	to := mail.Address{Name: m.ToName, Address: m.ToEmail}
+	var buf bytes.Buffer
+	writeHeader := func(name string, value string) {
+		// Remove line breaks, so values can't add their own headers
+		value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	writeHeader("From", from.String())
+	writeHeader("To", to.String())
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-ID", messageID(m.FromEmail))
+	writeHeader("MIME-Version", "1.0")
+
+	if m.Plain == "" || m.HTML == "" {
+		contentType, body := "text/plain", m.Plain
+		if m.Plain == "" {
+			contentType, body = "text/html", m.HTML
+		}
+		writeHeader("Content-Type", contentType+"; charset=utf-8")
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		err := writeQuotedPrintable(&buf, body)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&buf)
+	writeHeader("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buf.WriteString("\r\n")
+	// Clients show the last part they understand, so HTML is last
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", m.Plain},
+		{"text/html", m.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		err = writeQuotedPrintable(w, part.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := parts.Close()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeQuotedPrintable writes the body with quoted-printable encoding, which
+// keeps lines short enough for SMTP.
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	_, err := qp.Write([]byte(body))
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(qp.Close())
+}
+
+// messageID returns a new, unique Message-ID header value using the domain of
+// the sender's address.
+func messageID(fromEmail string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(fromEmail, "@"); i >= 0 && i < len(fromEmail)-1 {
+		domain = fromEmail[i+1:]
+	}
+	random := make([]byte, 16)
+	_, _ = rand.Read(random) // Never returns an error
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().Unix(), hex.EncodeToString(random), domain)
+}
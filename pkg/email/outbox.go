@@ -0,0 +1,253 @@
+package email
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+// Outbox statuses
+const (
+	// OutboxPending messages will be sent at their NextAttempt.
+	OutboxPending = "pending"
+	// OutboxSent messages were accepted by the Mailer.
+	OutboxSent = "sent"
+	// OutboxFailed messages weren't sent after outboxMaxAttempts, and won't
+	// be retried unless Resend is called.
+	OutboxFailed = "failed"
+)
+
+const (
+	// outboxPollInterval is how often RunOutbox checks for messages to send.
+	outboxPollInterval = 5 * time.Second
+	// outboxBatchSize is how many messages are claimed at once.
+	outboxBatchSize = 20
+	// outboxClaimTimeout is how long a claimed message is left alone by other
+	// workers, in case the worker sending it dies.
+	outboxClaimTimeout = 10 * time.Minute
+	// outboxRetryDelay is how long to wait after the first failed attempt.
+	// It doubles after each failure, up to outboxMaxRetryDelay.
+	outboxRetryDelay    = time.Minute
+	outboxMaxRetryDelay = 6 * time.Hour
+	// outboxMaxAttempts is how many times a message is tried before it's
+	// marked as failed. With the delays above, this is about 8.5 hours.
+	outboxMaxAttempts = 10
+	// outboxKeepSent is how long sent messages are kept. They may have
+	// password reset links, so they shouldn't be kept forever.
+	outboxKeepSent = 7 * 24 * time.Hour
+	// maxErrorLength is the size of the LastError column.
+	maxErrorLength = 1000
+)
+
+var ErrorOutboxNotFound = merry.New("outbox message not found").
+	WithUserMessage("That email doesn't exist, or isn't waiting to be sent.")
+
+// OutboxMessage is a message in the outbox, along with its delivery status.
+type OutboxMessage struct {
+	ID        int64     `db:"ID"`
+	Created   time.Time `db:"Created"`
+	FromName  string    `db:"FromName"`
+	FromEmail string    `db:"FromEmail"`
+	ToName    string    `db:"ToName"`
+	ToEmail   string    `db:"ToEmail"`
+	Subject   string    `db:"Subject"`
+	Plain     string    `db:"Plain"`
+	HTML      string    `db:"HTML"`
+	// Status is one of OutboxPending, OutboxSent, or OutboxFailed.
+	Status   string `db:"Status"`
+	Attempts int    `db:"Attempts"`
+	// NextAttempt is when a pending message will next be tried.
+	NextAttempt time.Time `db:"NextAttempt"`
+	// LastError is why the last attempt failed, if it did.
+	LastError string    `db:"LastError"`
+	Sent      time.Time `db:"Sent"`
+}
+
+// Message returns the email to send.
+func (m OutboxMessage) Message() Message {
+	return Message{FromName: m.FromName, FromEmail: m.FromEmail, ToName: m.ToName,
+		ToEmail: m.ToEmail, Subject: m.Subject, Plain: m.Plain, HTML: m.HTML}
+}
+
+// Enqueue adds the message to the outbox, to be sent by RunOutbox once the
+// transaction commits. This means the message is only sent IFF the change it's
+// about was committed, and isn't lost if the Mailer is down.
+func Enqueue(tx *sqlx.Tx, message Message) error {
+	if message.ToEmail == "" {
+		return merry.New("email has no recipient")
+	}
+	if message.Plain == "" && message.HTML == "" {
+		return merry.New("email has no body")
+	}
+	now := time.Now()
+	_, err := tx.Exec(`INSERT INTO EmailOutbox (Created, FromName, FromEmail,
+							ToName, ToEmail, Subject, Plain, HTML, Status,
+							NextAttempt)
+					   VALUES (?,?,?,?,?,?,?,?,?,?)`,
+		now, message.FromName, message.FromEmail, message.ToName,
+		message.ToEmail, message.Subject, message.Plain, message.HTML,
+		OutboxPending, now)
+	if err != nil {
+		return merry.Prependf(err, "error adding email to %s to the outbox",
+			message.ToEmail)
+	}
+	return nil
+}
+
+// GetUnsent returns the pending and failed messages, oldest first.
+func GetUnsent(tx *sqlx.Tx) (messages []OutboxMessage, err error) {
+	err = tx.Select(&messages, `SELECT *
+								FROM EmailOutbox
+								WHERE Status<>?
+								ORDER BY Created ASC, ID ASC`, OutboxSent)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return messages, nil
+}
+
+// Resend makes a failed message pending again, so it's sent right away with
+// a fresh set of attempts. It returns the message.
+func Resend(tx *sqlx.Tx, id int64) (message OutboxMessage, err error) {
+	err = tx.Get(&message, `SELECT * FROM EmailOutbox WHERE ID=? AND Status=?`,
+		id, OutboxFailed)
+	if err != nil {
+		return message, ErrorOutboxNotFound.Here()
+	}
+	_, err = tx.Exec(`UPDATE EmailOutbox
+					  SET Status=?, Attempts=0, NextAttempt=?
+					  WHERE ID=?`, OutboxPending, time.Now(), id)
+	if err != nil {
+		return message, merry.Wrap(err)
+	}
+	return message, nil
+}
+
+// retryDelay returns how long to wait before trying again, after the message
+// failed this many attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := outboxRetryDelay
+	for i := 1; i < attempts && delay < outboxMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxRetryDelay {
+		delay = outboxMaxRetryDelay
+	}
+	return delay
+}
+
+// truncateError shortens the error to fit its column.
+func truncateError(err error) string {
+	value := err.Error()
+	if len(value) <= maxErrorLength {
+		return value
+	}
+	value = value[:maxErrorLength-3]
+	// Don't split a multi-byte character
+	for !utf8.ValidString(value) {
+		value = value[:len(value)-1]
+	}
+	return value + "..."
+}
+
+// claimDue returns the pending messages which are due, and pushes back their
+// NextAttempt so no other worker sends them at the same time.
+func claimDue(db *sqlx.DB) (messages []OutboxMessage, err error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	err = tx.Select(&messages, `SELECT *
+								FROM EmailOutbox
+								WHERE Status=? AND NextAttempt<=?
+								ORDER BY NextAttempt ASC, ID ASC
+								LIMIT ?
+								FOR UPDATE`, OutboxPending, now, outboxBatchSize)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	for _, m := range messages {
+		_, err = tx.Exec(`UPDATE EmailOutbox SET NextAttempt=? WHERE ID=?`,
+			now.Add(outboxClaimTimeout), m.ID)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return messages, nil
+}
+
+// deliver sends the message, and records whether it was sent, or when to try
+// again.
+func deliver(db *sqlx.DB, m OutboxMessage) error {
+	sendErr := SendMessage(m.Message())
+	attempts := m.Attempts + 1
+	now := time.Now()
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = db.Exec(`UPDATE EmailOutbox
+						  SET Status=?, Attempts=?, Sent=?, LastError=''
+						  WHERE ID=?`, OutboxSent, attempts, now, m.ID)
+	case attempts >= outboxMaxAttempts:
+		log.Errorf("email: giving up on '%s' to %s after %d attempts: %s",
+			m.Subject, m.ToEmail, attempts, sendErr)
+		_, err = db.Exec(`UPDATE EmailOutbox
+						  SET Status=?, Attempts=?, LastError=?
+						  WHERE ID=?`, OutboxFailed, attempts, truncateError(sendErr), m.ID)
+	default:
+		delay := retryDelay(attempts)
+		log.Errorf("email: failed to send '%s' to %s (attempt %d, retrying in %s): %s",
+			m.Subject, m.ToEmail, attempts, delay, sendErr)
+		_, err = db.Exec(`UPDATE EmailOutbox
+						  SET Attempts=?, NextAttempt=?, LastError=?
+						  WHERE ID=?`, attempts, now.Add(delay), truncateError(sendErr), m.ID)
+	}
+	return merry.Wrap(err)
+}
+
+// sendDue sends every message which is due, until none are left.
+func sendDue(db *sqlx.DB) error {
+	for {
+		messages, err := claimDue(db)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		for _, m := range messages {
+			err = deliver(db, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// RunOutbox sends the messages added by Enqueue using the configured Mailer,
+// retrying failures with exponential backoff. It also deletes old sent
+// messages. It checks for new messages every few seconds (blocking).
+func RunOutbox(db *sqlx.DB) {
+	for {
+		err := sendDue(db)
+		if err != nil {
+			log.Errorf("email: outbox: %s", err)
+		}
+		_, err = db.Exec(`DELETE FROM EmailOutbox WHERE Status=? AND Sent<?`,
+			OutboxSent, time.Now().Add(-outboxKeepSent))
+		if err != nil {
+			log.Errorf("email: outbox: %s", merry.Wrap(err))
+		}
+		time.Sleep(outboxPollInterval)
+	}
+}
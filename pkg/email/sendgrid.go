@@ -0,0 +1,28 @@
+package email
+
+import (
+	"github.com/ansel1/merry"
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+var errorNotAccepted = merry.New("email request not accepted by SendGrid")
+
+// sendGridMailer sends email using SendGrid's API.
+type sendGridMailer struct {
+	apiKey string
+}
+
+func (m sendGridMailer) Send(message Message) error {
+	from := mail.NewEmail(message.FromName, message.FromEmail)
+	to := mail.NewEmail(message.ToName, message.ToEmail)
+	email := mail.NewSingleEmail(from, message.Subject, to, message.Plain, message.HTML)
+	client := sendgrid.NewSendClient(m.apiKey)
+	response, err := client.Send(email)
+	if err != nil {
+		return merry.Wrap(err)
+	} else if response.StatusCode != 202 {
+		return errorNotAccepted.Here().WithValue("status", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package email
+
+import (
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+const (
+	// SMTPSecurityStartTLS connects without TLS, and then upgrades the
+	// connection with STARTTLS. The server MUST support it. This is the
+	// default, usually on port 587.
+	SMTPSecurityStartTLS = "starttls"
+	// SMTPSecurityTLS connects using TLS (i.e. implicit TLS, or SMTPS),
+	// usually on port 465.
+	SMTPSecurityTLS = "tls"
+	// SMTPSecurityNone never uses TLS. This is only allowed without a username,
+	// and is meant for relays on the same host or a trusted network.
+	SMTPSecurityNone = "none"
+	// smtpTimeout limits how long connecting and sending may take.
+	smtpTimeout = 30 * time.Second
+)
+
+// SMTPConfig is how to connect to an SMTP server.
+type SMTPConfig struct {
+	Host string
+	// Port defaults to 465 for SMTPSecurityTLS, and 587 otherwise.
+	Port int
+	// Security is one of SMTPSecurityStartTLS, SMTPSecurityTLS, or
+	// SMTPSecurityNone. Zero uses SMTPSecurityStartTLS.
+	Security string
+	// Username and Password are used for PLAIN authentication, if set.
+	Username string
+	Password string
+	// InsecureSkipVerify disables checking the server's certificate. Only use
+	// this for testing.
+	InsecureSkipVerify bool
+}
+
+// smtpMailer sends email using an SMTP server.
+type smtpMailer struct {
+	config SMTPConfig
+}
+
+// newSMTPMailer validates the config, and fills in its defaults.
+func newSMTPMailer(config SMTPConfig) (smtpMailer, error) {
+	if config.Host == "" {
+		return smtpMailer{}, merry.New("email SMTP Host is required for the smtp transport")
+	}
+	if config.Security == "" {
+		config.Security = SMTPSecurityStartTLS
+	}
+	switch config.Security {
+	case SMTPSecurityStartTLS, SMTPSecurityTLS:
+	case SMTPSecurityNone:
+		if config.Username != "" {
+			return smtpMailer{}, merry.New("email SMTP Username requires TLS " +
+				"(Security must be starttls or tls)")
+		}
+	default:
+		return smtpMailer{}, merry.Errorf("unknown email SMTP Security '%s' (must be "+
+			"starttls, tls, or none)", config.Security)
+	}
+	if config.Port == 0 {
+		config.Port = 587
+		if config.Security == SMTPSecurityTLS {
+			config.Port = 465
+		}
+	}
+	return smtpMailer{config: config}, nil
+}
+
+func (m smtpMailer) Send(message Message) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	address := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	tlsConfig := &tls.Config{
+		ServerName:         m.config.Host,
+		InsecureSkipVerify: m.config.InsecureSkipVerify,
+	}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	if m.config.Security == SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return merry.Prependf(err, "connecting to SMTP server %s", address)
+	}
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return merry.Prependf(err, "connecting to SMTP server %s", address)
+	}
+	defer client.Close()
+
+	if m.config.Security == SMTPSecurityStartTLS {
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			return merry.Prepend(err, "SMTP STARTTLS failed")
+		}
+	}
+	if m.config.Username != "" {
+		auth := smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+		err = client.Auth(auth)
+		if err != nil {
+			return merry.Prepend(err, "SMTP authentication failed")
+		}
+	}
+	err = client.Mail(message.FromEmail)
+	if err != nil {
+		return merry.Prepend(err, "SMTP MAIL FROM failed")
+	}
+	err = client.Rcpt(message.ToEmail)
+	if err != nil {
+		return merry.Prependf(err, "SMTP server rejected recipient %s", message.ToEmail)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return merry.Prepend(err, "SMTP DATA failed")
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return merry.Prepend(err, "writing email to SMTP server")
+	}
+	err = w.Close()
+	if err != nil {
+		return merry.Prepend(err, "SMTP server rejected email")
+	}
+	return merry.Wrap(client.Quit())
+}
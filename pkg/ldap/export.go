@@ -0,0 +1,204 @@
+package ldap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+	nmLdap "github.com/nmcclain/ldap"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+)
+
+const (
+	// FormatLDIF exports entries exactly as the LDAP server returns them.
+	FormatLDIF = "ldif"
+	// FormatJSON exports the same information as JSON.
+	FormatJSON = "json"
+)
+
+// Export writes every user (including archived users), group, and membership
+// in the requested format (FormatLDIF or FormatJSON). Users and groups are
+// sorted by name, and their attributes are always in the same order, so exports
+// can be diffed.
+//
+// Password hashes are only included if includeHashes is true.
+func Export(w io.Writer, tx *sqlx.Tx, format string, includeHashes bool) error {
+	usersMap, groupsMap, err := user.GetAllUsersAndGroups(tx, true)
+	if err != nil {
+		return err
+	}
+	users := make([]*user.User, 0, len(usersMap))
+	for _, u := range usersMap {
+		sort.Strings(u.Groups)
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	groups := make([]*user.Group, 0, len(groupsMap))
+	for _, g := range groupsMap {
+		sort.Strings(g.Members)
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return writeExport(w, users, groups, format, includeHashes)
+}
+
+// writeExport writes the (already sorted) users and groups in the format.
+func writeExport(w io.Writer, users []*user.User, groups []*user.Group,
+	format string, includeHashes bool) error {
+	switch format {
+	case FormatLDIF:
+		return writeLDIF(w, users, groups, includeHashes)
+	case FormatJSON:
+		return writeJSON(w, users, groups, includeHashes)
+	}
+	return merry.Errorf("unknown export format '%s' (must be ldif or json)", format)
+}
+
+// passwordHashToLDAP returns the user's password hash as an LDAP userPassword
+// value, or an empty string if they don't have a password.
+func passwordHashToLDAP(hash string) string {
+	if strings.HasPrefix(hash, "$argon2") {
+		return "{ARGON2}" + hash // OpenLDAP's argon2 module
+	}
+	if strings.HasPrefix(hash, "$") {
+		return "{CRYPT}" + hash // e.g. bcrypt
+	}
+	if strings.HasPrefix(hash, "{") {
+		return hash // Already has a scheme, e.g. {SSHA}
+	}
+	return ""
+}
+
+// writeLDIF writes each entry in LDIF, using the same DNs and attributes as
+// the LDAP server.
+func writeLDIF(w io.Writer, users []*user.User, groups []*user.Group, includeHashes bool) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("version: 1\n")
+	for _, u := range users {
+		entry := userToLDAPEntry(u)
+		if includeHashes {
+			if hash := passwordHashToLDAP(u.PasswordHash); hash != "" {
+				entry.Attributes = append(entry.Attributes,
+					&nmLdap.EntryAttribute{Name: "userPassword", Values: []string{hash}})
+			}
+		}
+		writeLDIFEntry(bw, entry)
+	}
+	for _, g := range groups {
+		writeLDIFEntry(bw, groupToLDAPEntry(g))
+	}
+	return merry.Wrap(bw.Flush())
+}
+
+// writeLDIFEntry writes a single entry, preceded by a blank line. Empty values
+// are skipped.
+func writeLDIFEntry(w *bufio.Writer, entry *nmLdap.Entry) {
+	w.WriteString("\n")
+	writeLDIFLine(w, "dn", entry.DN)
+	for _, attribute := range entry.Attributes {
+		for _, value := range attribute.Values {
+			if value != "" {
+				writeLDIFLine(w, attribute.Name, value)
+			}
+		}
+	}
+}
+
+// writeLDIFLine writes "name: value", or "name:: base64" if the value isn't a
+// safe string as defined by RFC 2849.
+func writeLDIFLine(w *bufio.Writer, name string, value string) {
+	if isLDIFSafe(value) {
+		w.WriteString(name + ": " + value + "\n")
+	} else {
+		w.WriteString(name + ":: " + base64.StdEncoding.EncodeToString([]byte(value)) + "\n")
+	}
+}
+
+// isLDIFSafe returns true if the value can be written as-is in LDIF. It must
+// be printable ASCII, and not start with a space, colon, or less-than sign, or
+// end with a space.
+func isLDIFSafe(value string) bool {
+	if value == "" {
+		return true
+	}
+	if strings.ContainsAny(value[:1], " :<") || strings.HasSuffix(value, " ") {
+		return false
+	}
+	for _, r := range value {
+		if r < 0x20 || r > 0x7E || r == utf8.RuneError {
+			return false
+		}
+	}
+	return true
+}
+
+// exportUser is how users are written in JSON exports.
+type exportUser struct {
+	DN            string   `json:"dn"`
+	Username      string   `json:"username"`
+	FirstName     string   `json:"firstName"`
+	LastName      string   `json:"lastName"`
+	Email         string   `json:"email"`
+	UIDNumber     int64    `json:"uidNumber"`
+	GIDNumber     int64    `json:"gidNumber"`
+	HomeDirectory string   `json:"homeDirectory"`
+	Disabled      bool     `json:"disabled"`
+	Archived      bool     `json:"archived"`
+	Groups        []string `json:"groups"`
+	PasswordHash  string   `json:"passwordHash,omitempty"`
+}
+
+// exportGroup is how groups are written in JSON exports.
+type exportGroup struct {
+	DN          string   `json:"dn"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	GIDNumber   int64    `json:"gidNumber"`
+	Members     []string `json:"members"`
+}
+
+// writeJSON writes the users and groups as a single, indented JSON object.
+func writeJSON(w io.Writer, users []*user.User, groups []*user.Group, includeHashes bool) error {
+	export := struct {
+		Users  []exportUser  `json:"users"`
+		Groups []exportGroup `json:"groups"`
+	}{Users: []exportUser{}, Groups: []exportGroup{}}
+	for _, u := range users {
+		e := exportUser{
+			DN:            userToLDAPEntry(u).DN,
+			Username:      u.Username,
+			FirstName:     u.FirstName,
+			LastName:      u.LastName,
+			Email:         u.Email,
+			UIDNumber:     u.UnixUserID(),
+			GIDNumber:     u.UnixGroupID(),
+			HomeDirectory: u.HomeDirectory(),
+			Disabled:      u.Disabled,
+			Archived:      u.Archived(),
+			Groups:        append([]string{}, u.Groups...),
+		}
+		if includeHashes {
+			e.PasswordHash = passwordHashToLDAP(u.PasswordHash)
+		}
+		export.Users = append(export.Users, e)
+	}
+	for _, g := range groups {
+		export.Groups = append(export.Groups, exportGroup{
+			DN:          groupToLDAPEntry(g).DN,
+			Name:        g.Name,
+			Description: g.Description,
+			GIDNumber:   g.UnixGroupID(),
+			Members:     append([]string{}, g.Members...),
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return merry.Wrap(encoder.Encode(export))
+}
@@ -0,0 +1,124 @@
+package ldap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+)
+
+func testExportData() ([]*user.User, []*user.Group) {
+	users := []*user.User{
+		{ID: 1, Username: "jane.doe", FirstName: "Jane", LastName: "Doe",
+			Email: "jane@example.com", PasswordHash: "$2a$10$abcdefghijklmnopqrstuv",
+			Groups: []string{"admin", "lab"}},
+		{ID: 2, Username: "jose.garcia", FirstName: "José", LastName: "Garcia",
+			Email: "jose@example.com", PasswordHash: "-", Groups: []string{"lab"}},
+		{ID: 3, Username: "old.user", FirstName: "Old", LastName: "User",
+			Email: "old@example.com", PasswordHash: "-", Disabled: true,
+			ArchivedAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	groups := []*user.Group{
+		{ID: 1, Name: "admin", Description: "Administrators", Members: []string{"jane.doe"}},
+		{ID: 2, Name: "lab", Members: []string{"jane.doe", "jose.garcia"}},
+	}
+	return users, groups
+}
+
+func TestExportLDIF(t *testing.T) {
+	config = Config{BaseDN: "dc=example,dc=com", UserOU: "ou=People", GroupOU: "ou=Group,"}
+	users, groups := testExportData()
+	var out bytes.Buffer
+	err := writeExport(&out, users, groups, FormatLDIF, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldif := out.String()
+	for _, expected := range []string{
+		"version: 1\n\ndn: uid=jane.doe,ou=People,dc=example,dc=com\nuid: jane.doe\n",
+		"memberOf: admin\nmemberOf: lab\n",
+		"givenName:: Sm9zw6k=\n",
+		"dn: cn=lab,ou=Group,dc=example,dc=com\ncn: lab\ngidNumber: 102\n",
+		"member: jane.doe\nmember: jose.garcia\n",
+	} {
+		if !strings.Contains(ldif, expected) {
+			t.Errorf("expected LDIF to contain %q, got:\n%s", expected, ldif)
+		}
+	}
+	if strings.Contains(ldif, "userPassword") {
+		t.Error("password hashes must not be exported unless requested")
+	}
+	if strings.Contains(ldif, "description: \n") || strings.Contains(ldif, "description:\n") {
+		t.Error("empty values should be skipped")
+	}
+
+	// The same input must give the same output
+	var again bytes.Buffer
+	err = writeExport(&again, users, groups, FormatLDIF, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != ldif {
+		t.Error("export output is not stable")
+	}
+}
+
+func TestExportPasswordHashes(t *testing.T) {
+	users, groups := testExportData()
+	var ldif, json bytes.Buffer
+	err := writeExport(&ldif, users, groups, FormatLDIF, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ldif.String(), "userPassword: {CRYPT}$2a$10$abcdefghijklmnopqrstuv\n") {
+		t.Errorf("expected a userPassword for jane.doe, got:\n%s", ldif.String())
+	}
+	if strings.Count(ldif.String(), "userPassword") != 1 {
+		t.Error("users without a password should not get a userPassword")
+	}
+	err = writeExport(&json, users, groups, FormatJSON, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(json.String(), `"passwordHash": "{CRYPT}$2a$10$abcdefghijklmnopqrstuv"`) {
+		t.Errorf("expected a passwordHash in JSON, got:\n%s", json.String())
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	config = Config{BaseDN: "dc=example,dc=com", UserOU: "ou=People", GroupOU: "ou=Group"}
+	users, groups := testExportData()
+	var out bytes.Buffer
+	err := writeExport(&out, users, groups, FormatJSON, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"dn": "uid=jane.doe,ou=People,dc=example,dc=com"`,
+		`"groups": [
+        "admin",
+        "lab"
+      ]`,
+		`"firstName": "José"`,
+		`"gidNumber": 101,`,
+		`"username": "old.user",`,
+		`"archived": true,`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected JSON to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "passwordHash") {
+		t.Error("password hashes must not be exported unless requested")
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	users, groups := testExportData()
+	err := writeExport(&bytes.Buffer{}, users, groups, "xml", false)
+	if err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	nmLdap "github.com/nmcclain/ldap"
 
+	"github.com/joshsziegler/zauth/pkg/audit"
 	"github.com/joshsziegler/zauth/pkg/user"
 	"github.com/joshsziegler/zgo/pkg/log"
 )
@@ -29,8 +30,14 @@ var (
 	DB *sqlx.DB
 )
 
+// Init sets the configuration used by the LDAP server and exports. It MUST be
+// called before Listen or Export.
+func Init(c Config) {
+	config = c
+}
+
 // Listen performs setup and runs the LDAP server (blocking)
-func Listen(database *sqlx.DB, config Config) {
+func Listen(database *sqlx.DB) {
 	DB = database
 	// Create our LDAP-server
 	s := nmLdap.NewServer()
@@ -66,6 +73,18 @@ func getUsernameFromUID(uid string) (username string, err error) {
 	return username[4:], nil
 }
 
+// remoteIP returns the IP address of the LDAP client.
+func remoteIP(conn net.Conn) string {
+	if conn == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
 // Backend interface for LDAP using MySQL as it's datastore
 type mysqlBackend struct {
 }
@@ -95,7 +114,12 @@ func (h mysqlBackend) Bind(bindDN, bindPassword string, conn net.Conn) (
 		log.Errorf("LDAP: error starting transaction during Bind: %s", err)
 		return nmLdap.LDAPResultOperationsError, nil
 	}
-	err = user.Login(tx, username, bindPassword)
+	actor := audit.Actor{Username: username, IP: remoteIP(conn), Channel: audit.ChannelLDAP}
+	err = user.Login(tx, actor, username, bindPassword)
+	// Expired passwords are refused like wrong ones, since users must change
+	// them using the web UI. Our LDAP library can't attach the password-expired
+	// control (2.16.840.1.113730.3.4.4) to the bind response, so clients can't
+	// tell the difference; the log can.
 	if err != nil {
 		log.Errorf("LDAP: bind failure as %s: %s", username, err)
 		err = tx.Commit()
@@ -105,6 +129,13 @@ func (h mysqlBackend) Bind(bindDN, bindPassword string, conn net.Conn) (
 		}
 		return nmLdap.LDAPResultInvalidCredentials, nil
 	}
+	// Binds don't use a second factor, so this is the whole login
+	err = user.UpdateLastLogin(tx, actor, username, "password")
+	if err != nil {
+		log.Errorf("LDAP: error recording bind as %s: %s", username, err)
+		tx.Rollback()
+		return nmLdap.LDAPResultOperationsError, nil
+	}
 	log.Infof("LDAP: bind success as %s", username)
 	err = tx.Commit()
 	if err != nil {
@@ -153,7 +184,7 @@ func (h mysqlBackend) getAllUsersAndGroups() (entries []*nmLdap.Entry, err error
 		err = merry.Append(err, "error starting transaction")
 		return
 	}
-	users, groups, err := user.GetAllUsersAndGroups(tx)
+	users, groups, err := user.GetAllUsersAndGroups(tx, false)
 	if err != nil {
 		_ = tx.Commit() // ignore error if we're responding to an error
 		if err != nil {
@@ -175,9 +206,21 @@ func (h mysqlBackend) getAllUsersAndGroups() (entries []*nmLdap.Entry, err error
 	return
 }
 
+// joinDN joins the non-empty parts of a DN with commas. This allows the OUs in
+// the config to be given with or without a trailing comma (e.g. "ou=People").
+func joinDN(parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		part = strings.Trim(part, ", ")
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, ",")
+}
+
 func userToLDAPEntry(u *user.User) *nmLdap.Entry {
-	return &nmLdap.Entry{"uid=" + u.Username + "," + config.UserOU +
-		config.BaseDN,
+	return &nmLdap.Entry{joinDN("uid="+u.Username, config.UserOU, config.BaseDN),
 		[]*nmLdap.EntryAttribute{
 			{"uid", []string{u.Username}},
 			{"cn", []string{u.CommonName()}},
@@ -195,8 +238,7 @@ func userToLDAPEntry(u *user.User) *nmLdap.Entry {
 }
 
 func groupToLDAPEntry(g *user.Group) *nmLdap.Entry {
-	return &nmLdap.Entry{"cn=" + g.Name + "," + config.GroupOU +
-		config.BaseDN,
+	return &nmLdap.Entry{joinDN("cn="+g.Name, config.GroupOU, config.BaseDN),
 		[]*nmLdap.EntryAttribute{
 			{"cn", []string{g.Name}},
 			{"gidNumber", []string{strconv.FormatInt(g.UnixGroupID(), 10)}},
@@ -0,0 +1,301 @@
+// Package passkey implements WebAuthn registration and login ceremonies for
+// zauth users, on top of github.com/go-webauthn/webauthn.
+//
+// Ceremonies are split into Begin and Finish steps. Begin returns the options
+// to pass to the browser's navigator.credentials API, and an opaque session
+// value that MUST be stored server-side (e.g. in the user's secure session)
+// and passed to Finish along with the browser's response.
+package passkey
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/ansel1/merry"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+)
+
+// Config is used to pass required configuration options for WebAuthn.
+type Config struct {
+	// RPID is the Relying Party ID, which is the domain name of this site
+	// without the scheme or port (e.g. 'user.example.com').
+	RPID string
+	// RPDisplayName is the site name shown by the browser or authenticator.
+	RPDisplayName string
+	// RPOrigins are the full origins the site is served from, such as
+	// 'https://user.example.com'.
+	RPOrigins []string
+}
+
+var (
+	// wa is our configured WebAuthn relying party, or nil if disabled
+	wa *webauthn.WebAuthn
+
+	ErrDisabled = merry.New("passkeys are not configured").
+			WithUserMessage("Passkeys are not enabled on this server.")
+	ErrFailed = merry.New("passkey verification failed").
+			WithUserMessage("Your passkey could not be verified.")
+	// ErrCloneWarning indicates the authenticator's signature counter did not
+	// increase, which means the credential may have been copied.
+	ErrCloneWarning = merry.WithMessage(ErrFailed,
+		"passkey signature counter did not increase (possible cloned authenticator)")
+)
+
+// Init configures WebAuthn. If the RPID is empty, passkeys are disabled.
+func Init(config Config) error {
+	if config.RPID == "" {
+		wa = nil
+		return nil
+	}
+	var err error
+	wa, err = webauthn.New(&webauthn.Config{
+		RPID:          config.RPID,
+		RPDisplayName: config.RPDisplayName,
+		RPOrigins:     config.RPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+	if err != nil {
+		return merry.Prepend(err, "invalid WebAuthn configuration")
+	}
+	return nil
+}
+
+// Enabled returns true if passkeys have been configured.
+func Enabled() bool {
+	return wa != nil
+}
+
+// Account adapts a zauth User and their stored passkeys to the webauthn.User
+// interface.
+type Account struct {
+	User     user.User
+	Passkeys []user.Passkey
+	// credentials are decoded from Passkeys, in the same order
+	credentials []webauthn.Credential
+}
+
+// NewAccount decodes the user's stored passkeys.
+func NewAccount(u user.User, passkeys []user.Passkey) (*Account, error) {
+	a := &Account{User: u, Passkeys: passkeys}
+	for _, p := range passkeys {
+		var c webauthn.Credential
+		err := json.Unmarshal(p.Credential, &c)
+		if err != nil {
+			return nil, merry.Prependf(err, "error decoding passkey %d", p.ID)
+		}
+		a.credentials = append(a.credentials, c)
+	}
+	return a, nil
+}
+
+// WebAuthnID is the user handle, which is their database ID as 8 bytes. It
+// must not contain personally identifying information such as their username.
+func (a *Account) WebAuthnID() []byte {
+	return UserHandle(a.User.ID)
+}
+
+// WebAuthnName is the username, which helps users pick between accounts.
+func (a *Account) WebAuthnName() string {
+	return a.User.Username
+}
+
+// WebAuthnDisplayName is the user's full name.
+func (a *Account) WebAuthnDisplayName() string {
+	return a.User.CommonName()
+}
+
+// WebAuthnCredentials returns all of the user's registered credentials.
+func (a *Account) WebAuthnCredentials() []webauthn.Credential {
+	return a.credentials
+}
+
+// UserHandle returns the WebAuthn user handle for a database user ID.
+func UserHandle(userID int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(userID))
+	return b
+}
+
+// UserIDFromHandle reverses UserHandle.
+func UserIDFromHandle(handle []byte) (int64, error) {
+	if len(handle) != 8 {
+		return 0, merry.New("invalid user handle")
+	}
+	return int64(binary.BigEndian.Uint64(handle)), nil
+}
+
+// Result is a credential that was just registered or used to login, encoded
+// so it can be saved with user.AddPasskey or user.UpdatePasskeyAfterLogin.
+type Result struct {
+	// PasskeyID is the database ID of the passkey used to login. It's zero
+	// after registration, since the passkey hasn't been saved yet.
+	PasskeyID    int64
+	CredentialID []byte
+	Credential   []byte
+	SignCount    uint32
+}
+
+func newResult(a *Account, c *webauthn.Credential) (Result, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return Result{}, merry.Wrap(err)
+	}
+	r := Result{CredentialID: c.ID, Credential: data, SignCount: c.Authenticator.SignCount}
+	if a != nil {
+		for _, p := range a.Passkeys {
+			if bytes.Equal(p.CredentialID, c.ID) {
+				r.PasskeyID = p.ID
+			}
+		}
+	}
+	return r, nil
+}
+
+// BeginRegistration starts registering a new passkey for the account.
+func BeginRegistration(a *Account) (options []byte, session []byte, err error) {
+	if wa == nil {
+		return nil, nil, ErrDisabled.Here()
+	}
+	// Don't let them register the same authenticator twice
+	exclude := webauthn.Credentials(a.credentials).CredentialDescriptors()
+	creation, sessionData, err := wa.BeginRegistration(a,
+		webauthn.WithExclusions(exclude))
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	return encode(creation, sessionData)
+}
+
+// FinishRegistration verifies the browser's response to BeginRegistration.
+func FinishRegistration(a *Account, session []byte, response io.Reader) (Result, error) {
+	if wa == nil {
+		return Result{}, ErrDisabled.Here()
+	}
+	var sessionData webauthn.SessionData
+	err := json.Unmarshal(session, &sessionData)
+	if err != nil {
+		return Result{}, merry.Wrap(err)
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(response)
+	if err != nil {
+		return Result{}, ErrFailed.Here().WithMessagef("invalid registration response: %s", err)
+	}
+	c, err := wa.CreateCredential(a, sessionData, parsed)
+	if err != nil {
+		return Result{}, ErrFailed.Here().WithMessagef("registration failed: %s", err)
+	}
+	return newResult(nil, c)
+}
+
+// BeginLogin starts a login using one of this account's passkeys, which is
+// used as a second factor after their password.
+func BeginLogin(a *Account) (options []byte, session []byte, err error) {
+	if wa == nil {
+		return nil, nil, ErrDisabled.Here()
+	}
+	assertion, sessionData, err := wa.BeginLogin(a)
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	return encode(assertion, sessionData)
+}
+
+// FinishLogin verifies the browser's response to BeginLogin, including that
+// the authenticator's signature counter increased.
+func FinishLogin(a *Account, session []byte, response io.Reader) (Result, error) {
+	if wa == nil {
+		return Result{}, ErrDisabled.Here()
+	}
+	var sessionData webauthn.SessionData
+	err := json.Unmarshal(session, &sessionData)
+	if err != nil {
+		return Result{}, merry.Wrap(err)
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(response)
+	if err != nil {
+		return Result{}, ErrFailed.Here().WithMessagef("invalid login response: %s", err)
+	}
+	c, err := wa.ValidateLogin(a, sessionData, parsed)
+	if err != nil {
+		return Result{}, ErrFailed.Here().WithMessagef("login failed: %s", err)
+	}
+	if c.Authenticator.CloneWarning {
+		return Result{}, ErrCloneWarning.Here()
+	}
+	return newResult(a, c)
+}
+
+// BeginPasswordlessLogin starts a login where the user hasn't told us who they
+// are yet. The browser will offer any passkeys it has for this site.
+func BeginPasswordlessLogin() (options []byte, session []byte, err error) {
+	if wa == nil {
+		return nil, nil, ErrDisabled.Here()
+	}
+	assertion, sessionData, err := wa.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	return encode(assertion, sessionData)
+}
+
+// FinishPasswordlessLogin verifies the browser's response to
+// BeginPasswordlessLogin. The lookup function is given the user handle from the
+// response, and must return that user's Account.
+func FinishPasswordlessLogin(lookup func(userID int64) (*Account, error),
+	session []byte, response io.Reader) (*Account, Result, error) {
+	if wa == nil {
+		return nil, Result{}, ErrDisabled.Here()
+	}
+	var sessionData webauthn.SessionData
+	err := json.Unmarshal(session, &sessionData)
+	if err != nil {
+		return nil, Result{}, merry.Wrap(err)
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(response)
+	if err != nil {
+		return nil, Result{}, ErrFailed.Here().WithMessagef("invalid login response: %s", err)
+	}
+	var account *Account
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := UserIDFromHandle(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		account, err = lookup(userID)
+		if err != nil {
+			return nil, err
+		}
+		return account, nil
+	}
+	c, err := wa.ValidateDiscoverableLogin(handler, sessionData, parsed)
+	if err != nil {
+		return nil, Result{}, ErrFailed.Here().WithMessagef("login failed: %s", err)
+	}
+	if c.Authenticator.CloneWarning {
+		return nil, Result{}, ErrCloneWarning.Here()
+	}
+	result, err := newResult(account, c)
+	return account, result, err
+}
+
+// encode marshals the browser options and our session data to JSON.
+func encode(options interface{}, session *webauthn.SessionData) ([]byte, []byte, error) {
+	o, err := json.Marshal(options)
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	s, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	return o, s, nil
+}
@@ -0,0 +1,313 @@
+package passkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ansel1/merry"
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/joshsziegler/zauth/pkg/user"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "https://localhost"
+)
+
+var b64 = base64.RawURLEncoding
+
+// softAuthenticator is a minimal software WebAuthn authenticator, which only
+// supports ES256 and "none" attestation.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	counter      uint32
+	origin       string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credentialID: id, origin: testOrigin}
+}
+
+// challenge pulls the challenge out of the options given to the browser.
+func challenge(t *testing.T, options []byte) string {
+	var o struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	err := json.Unmarshal(options, &o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o.PublicKey.Challenge
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    a.origin,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, a.counter)
+	data = append(data, counter...)
+	return append(data, attested...)
+}
+
+// create responds to navigator.credentials.create()
+func (a *softAuthenticator) create(t *testing.T, options []byte) string {
+	var o struct {
+		PublicKey struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	json.Unmarshal(options, &o)
+	a.userHandle, _ = b64.DecodeString(o.PublicKey.User.ID)
+
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // AAGUID of all zeros
+	idLength := make([]byte, 2)
+	binary.BigEndian.PutUint16(idLength, uint16(len(a.credentialID)))
+	attested = append(attested, idLength...)
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, coseKey...)
+	// Flags: User Present, User Verified, Attested credential data included
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(0x01|0x04|0x40, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, _ := json.Marshal(map[string]interface{}{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(a.clientData("webauthn.create", challenge(t, options))),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	})
+	return string(response)
+}
+
+// get responds to navigator.credentials.get()
+func (a *softAuthenticator) get(t *testing.T, options []byte) string {
+	clientData := a.clientData("webauthn.get", challenge(t, options))
+	authData := a.authData(0x01|0x04, nil)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, _ := json.Marshal(map[string]interface{}{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.userHandle),
+		},
+	})
+	return string(response)
+}
+
+func setup(t *testing.T) *Account {
+	err := Init(Config{RPID: testRPID, RPDisplayName: "zauth",
+		RPOrigins: []string{testOrigin}})
+	if err != nil {
+		t.Fatalf("Init failed: %+v", err)
+	}
+	account, err := NewAccount(user.User{ID: 42, Username: "jane.doe",
+		FirstName: "Jane", LastName: "Doe"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+// register runs a full registration ceremony, and returns the account with
+// the new passkey saved to it (as if it had been stored in the database).
+func register(t *testing.T, account *Account, authenticator *softAuthenticator) *Account {
+	options, session, err := BeginRegistration(account)
+	if err != nil {
+		t.Fatalf("BeginRegistration failed: %+v", err)
+	}
+	result, err := FinishRegistration(account, session,
+		strings.NewReader(authenticator.create(t, options)))
+	if err != nil {
+		t.Fatalf("FinishRegistration failed: %+v", err)
+	}
+	passkeys := append(account.Passkeys, user.Passkey{
+		ID:           int64(len(account.Passkeys) + 1),
+		UserID:       account.User.ID,
+		CredentialID: result.CredentialID,
+		Credential:   result.Credential,
+		SignCount:    result.SignCount,
+	})
+	account, err = NewAccount(account.User, passkeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+// login runs a full login ceremony, and saves the updated counter to the
+// account if it succeeds.
+func login(t *testing.T, account *Account, authenticator *softAuthenticator) (*Account, Result, error) {
+	options, session, err := BeginLogin(account)
+	if err != nil {
+		t.Fatalf("BeginLogin failed: %+v", err)
+	}
+	result, err := FinishLogin(account, session,
+		strings.NewReader(authenticator.get(t, options)))
+	if err != nil {
+		return account, result, err
+	}
+	for i, p := range account.Passkeys {
+		if p.ID == result.PasskeyID {
+			account.Passkeys[i].Credential = result.Credential
+			account.Passkeys[i].SignCount = result.SignCount
+		}
+	}
+	account, err = NewAccount(account.User, account.Passkeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account, result, nil
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	account := setup(t)
+	authenticator := newSoftAuthenticator(t)
+	account = register(t, account, authenticator)
+
+	authenticator.counter = 1
+	account, result, err := login(t, account, authenticator)
+	if err != nil {
+		t.Fatalf("FinishLogin failed: %+v", err)
+	}
+	if result.PasskeyID != 1 || result.SignCount != 1 {
+		t.Errorf("FinishLogin returned the wrong passkey or counter: %+v", result)
+	}
+}
+
+func TestLoginCounterMustIncrease(t *testing.T) {
+	account := setup(t)
+	authenticator := newSoftAuthenticator(t)
+	account = register(t, account, authenticator)
+
+	authenticator.counter = 5
+	account, _, err := login(t, account, authenticator)
+	if err != nil {
+		t.Fatalf("FinishLogin failed: %+v", err)
+	}
+	// A cloned authenticator would re-use (or go backwards from) this counter
+	authenticator.counter = 5
+	_, _, err = login(t, account, authenticator)
+	if !merry.Is(err, ErrCloneWarning) {
+		t.Errorf("FinishLogin accepted a counter that did not increase: %v", err)
+	}
+}
+
+func TestMultipleAuthenticators(t *testing.T) {
+	account := setup(t)
+	first := newSoftAuthenticator(t)
+	second := newSoftAuthenticator(t)
+	account = register(t, account, first)
+	account = register(t, account, second)
+	if len(account.WebAuthnCredentials()) != 2 {
+		t.Fatalf("expected 2 credentials, got %d", len(account.WebAuthnCredentials()))
+	}
+
+	second.counter = 1
+	_, result, err := login(t, account, second)
+	if err != nil {
+		t.Fatalf("FinishLogin with second authenticator failed: %+v", err)
+	}
+	if result.PasskeyID != 2 {
+		t.Errorf("FinishLogin matched passkey %d instead of 2", result.PasskeyID)
+	}
+}
+
+func TestRegistrationWrongOrigin(t *testing.T) {
+	account := setup(t)
+	authenticator := newSoftAuthenticator(t)
+	authenticator.origin = "https://evil.example.com"
+	options, session, err := BeginRegistration(account)
+	if err != nil {
+		t.Fatalf("BeginRegistration failed: %+v", err)
+	}
+	_, err = FinishRegistration(account, session,
+		strings.NewReader(authenticator.create(t, options)))
+	if !merry.Is(err, ErrFailed) {
+		t.Errorf("FinishRegistration accepted the wrong origin: %v", err)
+	}
+}
+
+func TestPasswordlessLogin(t *testing.T) {
+	account := setup(t)
+	authenticator := newSoftAuthenticator(t)
+	account = register(t, account, authenticator)
+
+	options, session, err := BeginPasswordlessLogin()
+	if err != nil {
+		t.Fatalf("BeginPasswordlessLogin failed: %+v", err)
+	}
+	authenticator.counter = 1
+	lookup := func(userID int64) (*Account, error) {
+		if userID != account.User.ID {
+			t.Errorf("lookup for the wrong user ID: %d", userID)
+		}
+		return account, nil
+	}
+	found, result, err := FinishPasswordlessLogin(lookup, session,
+		strings.NewReader(authenticator.get(t, options)))
+	if err != nil {
+		t.Fatalf("FinishPasswordlessLogin failed: %+v", err)
+	}
+	if found.User.Username != "jane.doe" || result.PasskeyID != 1 {
+		t.Errorf("FinishPasswordlessLogin returned the wrong user or passkey")
+	}
+}
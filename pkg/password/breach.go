@@ -0,0 +1,150 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+var (
+	// ErrPasswordBreached indicates the password is in our breach corpus, so
+	// attackers are likely to try it.
+	ErrPasswordBreached = merry.
+				WithMessage(ErrPasswordWeak, "password appears in breach data").
+				WithUserMessage("Password appears in breach data or lists of common passwords, so attackers will try it. Please choose a different one.")
+
+	// commonPasswords and breachedHashes are the opened corpus files, or nil
+	// if they aren't configured. They're read-only, so they are goroutine-safe.
+	commonPasswords *sortedFile
+	breachedHashes  *sortedFile
+)
+
+// checkBreached returns ErrPasswordBreached if the password is in either of
+// our corpus files.
+func checkBreached(password string) error {
+	// Common password lists are mostly lowercase, so check that too
+	for _, p := range []string{password, strings.ToLower(password)} {
+		found, err := commonPasswords.contains(p)
+		if err != nil {
+			return err
+		}
+		if found {
+			return ErrPasswordBreached.Here()
+		}
+	}
+	sum := sha1.Sum([]byte(password))
+	found, err := breachedHashes.contains(strings.ToUpper(hex.EncodeToString(sum[:])))
+	if err != nil {
+		return err
+	}
+	if found {
+		return ErrPasswordBreached.Here()
+	}
+	return nil
+}
+
+// sortedFile is a file of lines sorted by key, which is searched without
+// reading the whole file.
+type sortedFile struct {
+	file *os.File
+	size int64
+	// key returns the part of a line which the file is sorted by.
+	key func(line string) string
+}
+
+// wholeLine is the key of each line in the common passwords file.
+func wholeLine(line string) string {
+	return line
+}
+
+// hashBeforeColon is the key of each 'HASH:COUNT' line in the breached hashes
+// file (uppercase hexadecimal).
+func hashBeforeColon(line string) string {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		return strings.ToUpper(line[:i])
+	}
+	return strings.ToUpper(line)
+}
+
+// openSortedFile opens a sorted file, or returns nil if the path is empty.
+func openSortedFile(path string, key func(line string) string) (*sortedFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, merry.Prependf(err, "error opening password corpus %s", path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, merry.Wrap(err)
+	}
+	return &sortedFile{file: file, size: info.Size(), key: key}, nil
+}
+
+// lineAfter returns the first line starting at or after offset, where it
+// starts, and where the next line starts. If no line starts before the end of
+// the file, start is the file's size.
+func (f *sortedFile) lineAfter(offset int64) (line string, start int64, next int64, err error) {
+	// Start reading one byte early, so we know if offset starts a line
+	start = offset
+	if offset > 0 {
+		start = offset - 1
+	}
+	r := bufio.NewReader(io.NewSectionReader(f.file, start, f.size-start))
+	if offset > 0 {
+		skipped, err := r.ReadString('\n')
+		if err == io.EOF {
+			return "", f.size, f.size, nil
+		} else if err != nil {
+			return "", 0, 0, merry.Wrap(err)
+		}
+		start += int64(len(skipped))
+	}
+	if start >= f.size {
+		return "", f.size, f.size, nil
+	}
+	line, err = r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", 0, 0, merry.Wrap(err)
+	}
+	next = start + int64(len(line))
+	return strings.TrimRight(line, "\r\n"), start, next, nil
+}
+
+// contains returns true if a line in the file has the key. Nil files (i.e. not
+// configured) contain nothing.
+func (f *sortedFile) contains(key string) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	// Each step either finds the key, or shrinks [lo, hi), which holds the
+	// start of every line that could have it
+	lo, hi := int64(0), f.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, start, next, err := f.lineAfter(mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+		lineKey := f.key(line)
+		if lineKey == key {
+			return true, nil
+		} else if lineKey < key {
+			lo = next
+		} else {
+			hi = mid
+		}
+	}
+	return false, nil
+}
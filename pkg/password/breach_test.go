@@ -0,0 +1,80 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes the lines to a temporary file, and returns its path.
+func writeTestFile(t *testing.T, name string, lines []string, newline string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(strings.Join(lines, newline)+newline), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestSortedFileContains(t *testing.T) {
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, strings.Repeat("x", i%7)+string(rune('a'+i%26))+
+			strings.Repeat("y", i%13))
+	}
+	sort.Strings(lines)
+	f, err := openSortedFile(writeTestFile(t, "sorted.txt", lines, "\n"), wholeLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		found, err := f.contains(line)
+		if err != nil || !found {
+			t.Fatalf("did not find '%s' (%v)", line, err)
+		}
+	}
+	for _, missing := range []string{"", "0", "zzzzzzz", "a" + strings.Repeat("y", 20)} {
+		found, err := f.contains(missing)
+		if err != nil || found {
+			t.Errorf("found missing line '%s' (%v)", missing, err)
+		}
+	}
+}
+
+func TestCheckBreached(t *testing.T) {
+	defer Init(Config{})
+	hashes := []string{sha1Hex("correct horse battery"), sha1Hex("Tr0ub4dor&3"),
+		sha1Hex("hunter2hunter2")}
+	sort.Strings(hashes)
+	for i := range hashes {
+		hashes[i] += ":42"
+	}
+	err := Init(Config{
+		CommonPasswordsFile: writeTestFile(t, "common.txt",
+			[]string{"123456", "letmein123", "password"}, "\n"),
+		// The Have I Been Pwned files use Windows line endings
+		BreachedHashesFile: writeTestFile(t, "pwned.txt", hashes, "\r\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"LetMeIn123", "password", "Tr0ub4dor&3", "hunter2hunter2"} {
+		if err := checkBreached(p); err == nil {
+			t.Errorf("'%s' should be rejected", p)
+		}
+	}
+	for _, p := range []string{"tr0ub4dor&3", "not in the corpus"} {
+		if err := checkBreached(p); err != nil {
+			t.Errorf("'%s' should be allowed: %s", p, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package password
+
+import "github.com/ansel1/merry"
+
+// Config is used to pass the password policies, and the offline password
+// corpus. Both files are optional, and are searched on disk (using binary
+// search), so they can be very large.
+type Config struct {
+	// Policy applies to every user. Unset lengths and MinScore use our
+	// defaults (e.g. DefaultMinLength).
+	Policy Policy
+	// GroupPolicies are added to Policy for members of each group (by name),
+	// so should be stricter (see Policy.Stricter).
+	GroupPolicies map[string]Policy
+	// CommonPasswordsFile has one password per line, sorted byte-wise (e.g.
+	// `LC_ALL=C sort -u`).
+	CommonPasswordsFile string
+	// BreachedHashesFile is the Have I Been Pwned "ordered by hash" SHA-1 file,
+	// with one 'HASH:COUNT' per line.
+	BreachedHashesFile string
+	// Hashing chooses the algorithm for new passwords, and its parameters.
+	Hashing HashConfig
+}
+
+// Init sets the password policies and hashing algorithm, and opens the offline
+// password corpus. It MUST be called before CheckPasswordRules or Hash to use
+// them.
+func Init(config Config) error {
+	err := initHashers(config.Hashing)
+	if err != nil {
+		return err
+	}
+	policy := config.Policy.withDefaults()
+	err = policy.validate()
+	if err != nil {
+		return err
+	}
+	for group, groupPolicy := range config.GroupPolicies {
+		err = policy.Stricter(groupPolicy).validate()
+		if err != nil {
+			return merry.Prependf(err, "group %s", group)
+		}
+	}
+	defaultPolicy = policy
+	groupPolicies = config.GroupPolicies
+
+	commonPasswords, err = openSortedFile(config.CommonPasswordsFile, wholeLine)
+	if err != nil {
+		return err
+	}
+	breachedHashes, err = openSortedFile(config.BreachedHashesFile, hashBeforeColon)
+	if err != nil {
+		return err
+	}
+	return nil
+}
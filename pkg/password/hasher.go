@@ -0,0 +1,306 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultAlgorithm is the Hasher used for new passwords, unless
+	// HashConfig.Algorithm is set.
+	DefaultAlgorithm = "argon2id"
+	// These are OWASP's recommended Argon2id parameters (as of 2023), which use
+	// 19 MiB of memory per hash.
+	DefaultArgon2idMemory      = 19 * 1024 // KiB
+	DefaultArgon2idIterations  = 2
+	DefaultArgon2idParallelism = 1
+	argon2idSaltLength         = 16
+	argon2idKeyLength          = 32
+)
+
+var (
+	// ErrUnknownHash indicates none of our hashers recognize a password hash
+	// (e.g. '-' for accounts without a password).
+	ErrUnknownHash = merry.New("could not identify password hash type")
+	// ErrVerifyOnly indicates a hasher can't make new hashes, because it is
+	// only used to check passwords from other systems until they're upgraded.
+	ErrVerifyOnly = merry.New("password hash algorithm can only verify passwords")
+
+	// hashers are every algorithm we can check, in the order they are tried.
+	hashers = []Hasher{
+		&argon2idHasher{Argon2idParams{DefaultArgon2idMemory, DefaultArgon2idIterations,
+			DefaultArgon2idParallelism}},
+		&bcryptHasher{cost: bcrypt.DefaultCost},
+		legacyHasher{name: "md5", prefix: "{MD5}", verify: validMD5},
+		legacyHasher{name: "sha", prefix: "{SHA}", verify: validSHA1},
+		legacyHasher{name: "ssha", prefix: "{SSHA}", verify: validSSHA},
+		legacyHasher{name: "ssha256", prefix: "{SSHA256}", verify: validSaltedSHA2},
+		legacyHasher{name: "ssha512", prefix: "{SSHA512}", verify: validSaltedSHA2},
+		legacyHasher{name: "pbkdf2-sha256", prefix: "{PBKDF2-SHA256}", verify: validPBKDF2},
+		legacyHasher{name: "sha256-crypt", prefix: "$5$", verify: validSHACrypt},
+		legacyHasher{name: "sha512-crypt", prefix: "$6$", verify: validSHACrypt},
+		legacyHasher{name: "crypt", prefix: "{CRYPT}", verify: validCrypt},
+	}
+	// defaultHasher is used for new passwords.
+	defaultHasher = hashers[0]
+)
+
+// Hasher is an algorithm for hashing passwords.
+type Hasher interface {
+	// Name is used to choose the algorithm for new passwords (see
+	// HashConfig).
+	Name() string
+	// Identify returns true if the hash was made by this algorithm.
+	Identify(hash string) bool
+	// Hash returns a new hash of the password, using the current parameters.
+	Hash(password string) (string, error)
+	// Verify returns true if the password matches the hash.
+	Verify(password string, hash string) (bool, error)
+	// NeedsRehash returns true if the hash doesn't use the current parameters.
+	NeedsRehash(hash string) bool
+}
+
+// HashConfig chooses and tunes the algorithm for new passwords.
+type HashConfig struct {
+	// Algorithm is the name of the Hasher for new passwords (e.g. "argon2id"
+	// or "bcrypt"). Zero uses DefaultAlgorithm.
+	Algorithm string
+	// Argon2id parameters. Zero values use our defaults (e.g.
+	// DefaultArgon2idMemory).
+	Argon2id Argon2idParams
+	// BcryptCost is the log2 of the number of rounds. Zero uses
+	// bcrypt.DefaultCost.
+	BcryptCost int
+}
+
+// Register adds a Hasher, so its hashes can be checked, and it can be chosen
+// for new passwords. It MUST be called before Init.
+func Register(h Hasher) {
+	hashers = append(hashers, h)
+}
+
+// initHashers sets each hasher's parameters, and chooses the one for new
+// passwords.
+func initHashers(config HashConfig) error {
+	if config.Algorithm == "" {
+		config.Algorithm = DefaultAlgorithm
+	}
+	var chosen Hasher
+	for _, h := range hashers {
+		switch h := h.(type) {
+		case *argon2idHasher:
+			h.params = config.Argon2id.withDefaults()
+		case *bcryptHasher:
+			h.cost = config.BcryptCost
+			if h.cost == 0 {
+				h.cost = bcrypt.DefaultCost
+			}
+			if h.cost < bcrypt.MinCost || h.cost > bcrypt.MaxCost {
+				return merry.Errorf("password BcryptCost must be %d-%d, not %d",
+					bcrypt.MinCost, bcrypt.MaxCost, h.cost)
+			}
+		}
+		if h.Name() == config.Algorithm {
+			chosen = h
+		}
+	}
+	if chosen == nil {
+		return merry.Errorf("unknown password hash algorithm %s", config.Algorithm)
+	}
+	if _, ok := chosen.(legacyHasher); ok {
+		return merry.Errorf("password hash algorithm %s can only verify passwords",
+			config.Algorithm)
+	}
+	defaultHasher = chosen
+	return nil
+}
+
+// Valid returns true if the given password matches the password hash.
+//
+// If the password is valid, it also returns whether the hash is outdated (i.e.
+// isn't using the algorithm and parameters Hash would), and so should be
+// replaced with a new hash.
+func Valid(password string, hashedPassword string) (valid bool,
+	outdated bool, err error) {
+	for _, h := range hashers {
+		if !h.Identify(hashedPassword) {
+			continue
+		}
+		valid, err = h.Verify(password, hashedPassword)
+		if err != nil || !valid {
+			return false, false, err
+		}
+		return true, h.Name() != defaultHasher.Name() || h.NeedsRehash(hashedPassword), nil
+	}
+	return false, false, ErrUnknownHash.Here()
+}
+
+// Hash take a plaintext password and returns a securely hashed version.
+//
+// Use this instead of a specific hashing algorithm so we can change which
+// algorithm is used between versions (see HashConfig).
+func Hash(password string) (string, error) {
+	return defaultHasher.Hash(password)
+}
+
+// Argon2idParams are the cost of an Argon2id hash.
+type Argon2idParams struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// withDefaults returns the parameters, with any unset ones taken from our
+// defaults.
+func (p Argon2idParams) withDefaults() Argon2idParams {
+	if p.Memory == 0 {
+		p.Memory = DefaultArgon2idMemory
+	}
+	if p.Iterations == 0 {
+		p.Iterations = DefaultArgon2idIterations
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = DefaultArgon2idParallelism
+	}
+	return p
+}
+
+// argon2idHasher makes PHC strings, like the reference implementation:
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+type argon2idHasher struct {
+	params Argon2idParams
+}
+
+func (h *argon2idHasher) Name() string {
+	return "argon2id"
+}
+
+func (h *argon2idHasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory,
+		h.params.Parallelism, argon2idKeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// decode returns the hash's parameters, salt, and key.
+func (h *argon2idHasher) decode(hash string) (params Argon2idParams, salt []byte,
+	key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, merry.New("invalid argon2id hash")
+	}
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, merry.New("unsupported argon2id version")
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations,
+		&params.Parallelism)
+	if err != nil {
+		return params, nil, nil, merry.Prepend(err, "invalid argon2id parameters")
+	}
+	// argon2.IDKey panics if these are zero
+	if params.Iterations < 1 || params.Parallelism < 1 {
+		return params, nil, nil, merry.New("invalid argon2id parameters")
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, merry.Prepend(err, "invalid argon2id salt")
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, merry.New("invalid argon2id key")
+	}
+	return params, salt, key, nil
+}
+
+func (h *argon2idHasher) Verify(password string, hash string) (bool, error) {
+	params, salt, key, err := h.decode(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory,
+		params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := h.decode(hash)
+	return err != nil || params != h.params || len(salt) != argon2idSaltLength ||
+		len(key) != argon2idKeyLength
+}
+
+// bcryptHasher accepts the $2a$, $2b$, and $2y$ variants, which only differ in
+// bugs fixed in other implementations, and makes $2a$ hashes.
+type bcryptHasher struct {
+	cost int
+}
+
+func (h *bcryptHasher) Name() string {
+	return "bcrypt"
+}
+
+func (h *bcryptHasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	return hashBCRYPT(password, h.cost)
+}
+
+func (h *bcryptHasher) Verify(password string, hash string) (bool, error) {
+	return validBCRYPT(password, hash)
+}
+
+func (h *bcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost
+}
+
+// legacyHasher checks insecure hashes from other systems, which are always
+// replaced after a successful login.
+type legacyHasher struct {
+	name   string
+	prefix string
+	verify func(password string, hash string) (bool, error)
+}
+
+func (h legacyHasher) Name() string {
+	return h.name
+}
+
+func (h legacyHasher) Identify(hash string) bool {
+	return strings.HasPrefix(hash, h.prefix)
+}
+
+func (h legacyHasher) Hash(password string) (string, error) {
+	return "", ErrVerifyOnly.Here()
+}
+
+func (h legacyHasher) Verify(password string, hash string) (bool, error) {
+	return h.verify(password, hash)
+}
+
+func (h legacyHasher) NeedsRehash(hash string) bool {
+	return true
+}
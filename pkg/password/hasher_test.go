@@ -0,0 +1,91 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2id(t *testing.T) {
+	defer Init(Config{})
+	err := Init(Config{Hashing: HashConfig{Argon2id: Argon2idParams{Memory: 64,
+		Iterations: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := Hash(correctPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected argon2id hash: %s", hash)
+	}
+	valid, outdated, err := Valid(correctPassword, hash)
+	if !valid || outdated || err != nil {
+		t.Errorf("Valid returned %v, %v, %v", valid, outdated, err)
+	}
+	valid, _, err = Valid("wrong password", hash)
+	if valid || err != nil {
+		t.Errorf("wrong password returned %v, %v", valid, err)
+	}
+	// Changing the parameters means the hash should be replaced
+	err = Init(Config{Hashing: HashConfig{Argon2id: Argon2idParams{Memory: 128,
+		Iterations: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, outdated, err = Valid(correctPassword, hash)
+	if !valid || !outdated || err != nil {
+		t.Errorf("Valid after changing parameters returned %v, %v, %v", valid,
+			outdated, err)
+	}
+	_, _, err = Valid(correctPassword, "$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5")
+	if err == nil {
+		t.Error("zero iterations should be an invalid hash")
+	}
+}
+
+func TestValidBcryptVariants(t *testing.T) {
+	defer Init(Config{})
+	err := Init(Config{Hashing: HashConfig{Algorithm: "bcrypt", BcryptCost: bcrypt.MinCost}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := Hash(correctPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		valid, outdated, err := Valid(correctPassword, prefix+hash[4:])
+		if !valid || outdated || err != nil {
+			t.Errorf("%s returned %v, %v, %v", prefix, valid, outdated, err)
+		}
+	}
+	// A higher cost, or a different algorithm, means the hash is outdated
+	err = Init(Config{Hashing: HashConfig{Algorithm: "bcrypt", BcryptCost: bcrypt.MinCost + 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, outdated, _ := Valid(correctPassword, hash); !outdated {
+		t.Error("lower bcrypt cost should be outdated")
+	}
+	Init(Config{})
+	if _, outdated, _ := Valid(correctPassword, hash); !outdated {
+		t.Error("bcrypt should be outdated when argon2id is the default")
+	}
+}
+
+func TestValidLegacy(t *testing.T) {
+	valid, outdated, err := Valid(correctPassword, "{SSHA}fPT83zdW1EJPgm/dUiCe7U46/KxR69Uv")
+	if !valid || !outdated || err != nil {
+		t.Errorf("SSHA returned %v, %v, %v", valid, outdated, err)
+	}
+	if _, _, err = Valid(correctPassword, "-"); err == nil {
+		t.Error("unknown hashes should return an error")
+	}
+	if err = Init(Config{Hashing: HashConfig{Algorithm: "md5"}}); err == nil {
+		t.Error("legacy algorithms should not be allowed for new passwords")
+	}
+	Init(Config{})
+}
@@ -0,0 +1,237 @@
+package password
+
+import (
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+// These verify hashes from OpenLDAP and /etc/shadow, so migrated users can
+// login once and have their password upgraded (see Valid).
+
+const (
+	// cryptAlphabet is the base64 alphabet used by crypt(3).
+	cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// shaCryptDefaultRounds is used when a sha-crypt hash doesn't specify the
+	// rounds, and shaCryptMinRounds and shaCryptMaxRounds are its limits.
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	// shaCryptMaxSalt is the most salt characters sha-crypt uses.
+	shaCryptMaxSalt = 16
+)
+
+var (
+	// shaCrypt256Order and shaCrypt512Order are the order in which the digest
+	// bytes are encoded, three at a time (the last group may be shorter).
+	shaCrypt256Order = []int{0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14,
+		15, 25, 5, 6, 16, 26, 27, 7, 17, 18, 28, 8, 9, 19, 29, 31, 30}
+	shaCrypt512Order = []int{0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4,
+		47, 5, 26, 6, 27, 48, 28, 49, 7, 50, 8, 29, 9, 30, 51, 31, 52, 10, 53, 11,
+		32, 12, 33, 54, 34, 55, 13, 56, 14, 35, 15, 36, 57, 37, 58, 16, 59, 17, 38,
+		18, 39, 60, 40, 61, 19, 62, 20, 41, 63}
+
+	// pbkdf2Base64 is passlib's "adapted base64", used by OpenLDAP's pbkdf2
+	// module: standard base64 using '.' instead of '+', and without padding.
+	pbkdf2Base64 = base64.NewEncoding(
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").
+		WithPadding(base64.NoPadding)
+)
+
+// validCrypt checks OpenLDAP's {CRYPT} scheme, which wraps a crypt(3) hash
+// (e.g. '{CRYPT}$6$...'). Only the sha-crypt and bcrypt formats are supported.
+func validCrypt(password string, hashedPassword string) (bool, error) {
+	if !strings.HasPrefix(hashedPassword, "{CRYPT}") {
+		return false, merry.New("not a CRYPT password")
+	}
+	hashedPassword = hashedPassword[len("{CRYPT}"):]
+	switch {
+	case strings.HasPrefix(hashedPassword, "$5$"), strings.HasPrefix(hashedPassword, "$6$"):
+		return validSHACrypt(password, hashedPassword)
+	case strings.HasPrefix(hashedPassword, "$2a$"), strings.HasPrefix(hashedPassword, "$2b$"),
+		strings.HasPrefix(hashedPassword, "$2y$"):
+		return validBCRYPT(password, hashedPassword)
+	}
+	return false, merry.New("unsupported CRYPT password format")
+}
+
+// validSHACrypt checks sha256-crypt ('$5$') and sha512-crypt ('$6$') hashes,
+// as described in https://www.akkadia.org/drepper/SHA-crypt.txt
+func validSHACrypt(password string, hashedPassword string) (bool, error) {
+	var newHash func() hash.Hash
+	var order []int
+	switch {
+	case strings.HasPrefix(hashedPassword, "$5$"):
+		newHash, order = sha256.New, shaCrypt256Order
+	case strings.HasPrefix(hashedPassword, "$6$"):
+		newHash, order = sha512.New, shaCrypt512Order
+	default:
+		return false, merry.New("not a SHA-crypt password")
+	}
+	parts := strings.Split(hashedPassword[3:], "$")
+	rounds := shaCryptDefaultRounds
+	roundsPrefix := ""
+	if strings.HasPrefix(parts[0], "rounds=") {
+		var err error
+		rounds, err = strconv.Atoi(strings.TrimPrefix(parts[0], "rounds="))
+		if err != nil {
+			return false, merry.New("invalid SHA-crypt rounds")
+		}
+		rounds = min(max(rounds, shaCryptMinRounds), shaCryptMaxRounds)
+		roundsPrefix = parts[0] + "$"
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return false, merry.New("invalid SHA-crypt password")
+	}
+	salt := parts[0]
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+	digest := shaCrypt(newHash, []byte(password), []byte(salt), rounds)
+	want := hashedPassword[:3] + roundsPrefix + salt + "$" + cryptEncode(digest, order)
+	return subtle.ConstantTimeCompare([]byte(want), []byte(hashedPassword)) == 1, nil
+}
+
+// shaCrypt returns the sha-crypt digest of the password.
+func shaCrypt(newHash func() hash.Hash, password []byte, salt []byte, rounds int) []byte {
+	// repeat returns digest repeated to length bytes
+	repeat := func(digest []byte, length int) []byte {
+		return bytes.Repeat(digest, length/len(digest)+1)[:length]
+	}
+
+	h := newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	h = newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(repeat(b, len(password)))
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	h = newHash()
+	for range password {
+		h.Write(password)
+	}
+	p := repeat(h.Sum(nil), len(password))
+
+	h = newHash()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeat(h.Sum(nil), len(salt))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h = newHash()
+		if i%2 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i%2 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+	return c
+}
+
+// cryptEncode encodes the digest using crypt(3)'s base64, taking the bytes
+// three at a time in order.
+func cryptEncode(digest []byte, order []int) string {
+	var sb strings.Builder
+	for i := 0; i < len(order); i += 3 {
+		group := order[i:min(i+3, len(order))]
+		var w uint
+		for _, index := range group {
+			w = w<<8 | uint(digest[index])
+		}
+		// Each byte is 8 bits, so a group of n bytes needs n+1 characters
+		for n := 0; n <= len(group); n++ {
+			sb.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	return sb.String()
+}
+
+// validSaltedSHA2 checks OpenLDAP's {SSHA256} and {SSHA512} schemes, which are
+// base64(SHA2(password + salt) + salt), like validSSHA.
+func validSaltedSHA2(password string, hashedPassword string) (bool, error) {
+	var newHash func() hash.Hash
+	var scheme string
+	switch {
+	case strings.HasPrefix(hashedPassword, "{SSHA256}"):
+		newHash, scheme = sha256.New, "{SSHA256}"
+	case strings.HasPrefix(hashedPassword, "{SSHA512}"):
+		newHash, scheme = sha512.New, "{SSHA512}"
+	default:
+		return false, merry.New("not a SSHA256 or SSHA512 password")
+	}
+	data, err := base64.StdEncoding.DecodeString(hashedPassword[len(scheme):])
+	size := newHash().Size()
+	if err != nil || len(data) <= size {
+		return false, merry.New("base64 Decode Failed")
+	}
+	h := newHash()
+	h.Write([]byte(password))
+	h.Write(data[size:])
+	return subtle.ConstantTimeCompare(h.Sum(nil), data[:size]) == 1, nil
+}
+
+// validPBKDF2 checks OpenLDAP's (and passlib's) {PBKDF2-SHA256} scheme:
+// {PBKDF2-SHA256}<rounds>$<salt>$<key>, where the salt and key use
+// pbkdf2Base64.
+func validPBKDF2(password string, hashedPassword string) (bool, error) {
+	if !strings.HasPrefix(hashedPassword, "{PBKDF2-SHA256}") {
+		return false, merry.New("not a PBKDF2-SHA256 password")
+	}
+	parts := strings.Split(hashedPassword[len("{PBKDF2-SHA256}"):], "$")
+	if len(parts) != 3 {
+		return false, merry.New("invalid PBKDF2-SHA256 password")
+	}
+	rounds, err := strconv.Atoi(parts[0])
+	if err != nil || rounds < 1 {
+		return false, merry.New("invalid PBKDF2-SHA256 rounds")
+	}
+	salt, err := pbkdf2Base64.DecodeString(parts[1])
+	if err != nil {
+		return false, merry.New("invalid PBKDF2-SHA256 salt")
+	}
+	key, err := pbkdf2Base64.DecodeString(parts[2])
+	if err != nil || len(key) == 0 {
+		return false, merry.New("invalid PBKDF2-SHA256 key")
+	}
+	other, err := pbkdf2.Key(sha256.New, password, salt, rounds, len(key))
+	if err != nil {
+		return false, merry.Wrap(err)
+	}
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
@@ -0,0 +1,76 @@
+package password
+
+import (
+	"testing"
+)
+
+func TestSHACrypt(t *testing.T) {
+	for _, hash := range []string{
+		// Generated with `openssl passwd -5` and `-6`
+		"$5$Vr1zQ9bX$yxtktXy0DCs4iHDvQc02Boknqw9Hf0bAj1NgyH5YXV/",
+		"$6$Vr1zQ9bX$HUJYamiVnWiZ9dEbNil8sg5tsVmVk89ZiLr0UnidCQHYLKIo/lAonEznwwTwcrWix82KtS.u1eno9QHL.rExq/",
+		"$5$rounds=1000$Vr1zQ9bX$OJKx/bryY9HFLFYIDaIMUvndvYYwSK4nSparRqL0MF6",
+		"{CRYPT}$6$Vr1zQ9bX$HUJYamiVnWiZ9dEbNil8sg5tsVmVk89ZiLr0UnidCQHYLKIo/lAonEznwwTwcrWix82KtS.u1eno9QHL.rExq/",
+	} {
+		valid, outdated, err := Valid(correctPassword, hash)
+		if !valid || !outdated || err != nil {
+			t.Errorf("%s returned %v, %v, %v", hash, valid, outdated, err)
+		}
+		valid, _, err = Valid("wrong password", hash)
+		if valid || err != nil {
+			t.Errorf("wrong password for %s returned %v, %v", hash, valid, err)
+		}
+	}
+	// Test vectors from https://www.akkadia.org/drepper/SHA-crypt.txt, whose
+	// salts are longer than the 16 characters used.
+	for _, hash := range []string{
+		"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA",
+		"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.",
+	} {
+		valid, err := validSHACrypt("Hello world!", hash)
+		if !valid || err != nil {
+			t.Errorf("%s returned %v, %v", hash, valid, err)
+		}
+	}
+	for _, hash := range []string{"$6$", "$6$rounds=x$salt$hash", "{CRYPT}$1$salt$hash"} {
+		if _, _, err := Valid(correctPassword, hash); err == nil {
+			t.Errorf("%s should return an error", hash)
+		}
+	}
+}
+
+func TestSaltedSHA2(t *testing.T) {
+	for _, hash := range []string{
+		"{SSHA256}oSB4B6c6MBuu2mh2pHkAURdNifpUnpPHj6FGz4Siov6MHy59SltskA==",
+		"{SSHA512}+8SbPUTr4OSXaycqjEsUO1EsrQ8VFSBP/GDWfNMg+fhXkZ29mfqgErKb5P1kMYZhEz1Qts9JTKUXYvx0cgFgRIwfLn1KW2yQ",
+	} {
+		valid, outdated, err := Valid(correctPassword, hash)
+		if !valid || !outdated || err != nil {
+			t.Errorf("%s returned %v, %v, %v", hash, valid, outdated, err)
+		}
+		valid, _, err = Valid("wrong password", hash)
+		if valid || err != nil {
+			t.Errorf("wrong password for %s returned %v, %v", hash, valid, err)
+		}
+	}
+	if _, _, err := Valid(correctPassword, "{SSHA256}c2FsdA=="); err == nil {
+		t.Error("a hash without a digest should return an error")
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	hash := "{PBKDF2-SHA256}29000$ASNFZ4mrze/.3LqYdlQyEA$LPE4K0NkyZWIIxVNEzwo/dtcH30.x48BDmIlaJHQSns"
+	valid, outdated, err := Valid(correctPassword, hash)
+	if !valid || !outdated || err != nil {
+		t.Errorf("PBKDF2-SHA256 returned %v, %v, %v", valid, outdated, err)
+	}
+	valid, _, err = Valid("wrong password", hash)
+	if valid || err != nil {
+		t.Errorf("wrong password returned %v, %v", valid, err)
+	}
+	for _, hash := range []string{"{PBKDF2-SHA256}0$ASNF$LPE4", "{PBKDF2-SHA256}29000$ASNF"} {
+		if _, _, err = Valid(correctPassword, hash); err == nil {
+			t.Errorf("%s should return an error", hash)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"encoding/base64"
-	"strings"
 
 	"github.com/ansel1/merry"
 	"golang.org/x/crypto/bcrypt"
@@ -90,9 +89,8 @@ func validSSHA(password string, hashedPassword string) (bool, error) {
 	return false, nil
 }
 
-func hashBCRYPT(password string) (string, error) {
-	// Default Cost is currently 10. We had it set to 15, but it took too long
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func hashBCRYPT(password string, cost int) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	return string(bytes), merry.Wrap(err)
 }
 
@@ -103,42 +101,3 @@ func validBCRYPT(password string, hashedPassword string) (bool, error) {
 		[]byte(password))
 	return err == nil, nil
 }
-
-// Valid returns true if the given password matches the password hash.
-//
-// If the password is valid, it will also return false if it is not using a
-// secure hashing algorithm and needs updated (currently bcrypt).
-func Valid(password string, hashedPassword string) (valid bool,
-	insecure bool, err error) {
-
-	if strings.HasPrefix(hashedPassword, "$2a$") {
-		insecure = false
-		valid, err = validBCRYPT(password, hashedPassword)
-		return
-	}
-
-	insecure = true
-	switch {
-	case strings.HasPrefix(hashedPassword, "{MD5}"):
-		valid, err = validMD5(password, hashedPassword)
-		return
-	case strings.HasPrefix(hashedPassword, "{SHA}"):
-		valid, err = validSHA1(password, hashedPassword)
-		return
-	case strings.HasPrefix(hashedPassword, "{SSHA}"):
-		valid, err = validSSHA(password, hashedPassword)
-		return
-	default:
-		return false, false, merry.New("could not identify password hash type")
-	}
-}
-
-// Hash take a plaintext password and returns a securely hashed version.
-//
-// Currently uses bcrypt
-//
-// Use this instead of a specific hashing algorithm so we can change which
-// algorithm is used between versions.
-func Hash(password string) (string, error) {
-	return hashBCRYPT(password)
-}
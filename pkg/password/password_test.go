@@ -2,6 +2,8 @@ package password
 
 import (
 	"testing"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -45,7 +47,7 @@ func TestSSHA(t *testing.T) {
 }
 
 func TestBCRYPT(t *testing.T) {
-	hashedPassword, err := hashBCRYPT(correctPassword)
+	hashedPassword, err := hashBCRYPT(correctPassword, bcrypt.DefaultCost)
 	if err != nil {
 		t.Errorf("hashBCRYPT Failed: %s", err)
 	}
@@ -0,0 +1,157 @@
+package password
+
+import (
+	"fmt"
+
+	"github.com/ansel1/merry"
+)
+
+const (
+	// DefaultMinLength is the minimum number of characters a password MUST
+	// contain, if the policy doesn't set it.
+	DefaultMinLength = 10
+	// DefaultMaxLength is the maximum number of characters a password may
+	// contain, if the policy doesn't set it.
+	DefaultMaxLength = 64
+)
+
+// Policy is the rules for a user's passwords. Zero values are not set, so a
+// group's policy only needs the rules that are stricter than the default.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// RequireUpper, RequireLower, RequireDigit, and RequireSymbol require at
+	// least one character of that class. Symbols are anything other than a
+	// letter or digit.
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// MinScore is the lowest strength score (1 to MaxScore, see
+	// EstimateStrength) a new password must have.
+	MinScore int
+	// HistoryLength is how many of the user's previous passwords they may not
+	// reuse. Their current password can never be reused.
+	HistoryLength int
+	// MaxAgeDays is how many days a password may be used before it must be
+	// changed. Zero means passwords never expire.
+	MaxAgeDays int
+}
+
+// Rule is a description of one of a Policy's rules, for users.
+type Rule struct {
+	// ID names the rule, so the web UI can show whether it passes.
+	ID   string
+	Text string
+}
+
+// defaultPolicy is used for every user, and groupPolicies are added for their
+// members (see PolicyFor).
+var (
+	defaultPolicy = Policy{
+		MinLength: DefaultMinLength,
+		MaxLength: DefaultMaxLength,
+		MinScore:  DefaultMinScore,
+	}
+	groupPolicies map[string]Policy
+)
+
+// withDefaults returns the policy, with any unset lengths and score taken from
+// our defaults.
+func (p Policy) withDefaults() Policy {
+	if p.MinLength == 0 {
+		p.MinLength = DefaultMinLength
+	}
+	if p.MaxLength == 0 {
+		p.MaxLength = DefaultMaxLength
+	}
+	if p.MinScore == 0 {
+		p.MinScore = DefaultMinScore
+	}
+	return p
+}
+
+// validate returns an error if no password could meet the policy.
+func (p Policy) validate() error {
+	if p.MinLength < 1 || p.MinLength > p.MaxLength {
+		return merry.Errorf("password MinLength must be 1-%d (MaxLength), not %d",
+			p.MaxLength, p.MinLength)
+	}
+	if p.MinScore < 1 || p.MinScore > MaxScore {
+		return merry.Errorf("password MinScore must be 1-%d, not %d", MaxScore,
+			p.MinScore)
+	}
+	if p.HistoryLength < 0 || p.MaxAgeDays < 0 {
+		return merry.New("password HistoryLength and MaxAgeDays cannot be negative")
+	}
+	return nil
+}
+
+// Stricter returns a policy which requires everything both policies do. Unset
+// (zero) values in either are ignored.
+func (p Policy) Stricter(other Policy) Policy {
+	p.MinLength = max(p.MinLength, other.MinLength)
+	if other.MaxLength > 0 && (p.MaxLength == 0 || other.MaxLength < p.MaxLength) {
+		p.MaxLength = other.MaxLength
+	}
+	p.RequireUpper = p.RequireUpper || other.RequireUpper
+	p.RequireLower = p.RequireLower || other.RequireLower
+	p.RequireDigit = p.RequireDigit || other.RequireDigit
+	p.RequireSymbol = p.RequireSymbol || other.RequireSymbol
+	p.MinScore = max(p.MinScore, other.MinScore)
+	p.HistoryLength = max(p.HistoryLength, other.HistoryLength)
+	if other.MaxAgeDays > 0 && (p.MaxAgeDays == 0 || other.MaxAgeDays < p.MaxAgeDays) {
+		p.MaxAgeDays = other.MaxAgeDays
+	}
+	return p
+}
+
+// PolicyFor returns the policy for a member of the groups, which is the
+// default policy plus the policies of any of their groups.
+func PolicyFor(groups []string) Policy {
+	policy := defaultPolicy
+	for _, group := range groups {
+		if groupPolicy, ok := groupPolicies[group]; ok {
+			policy = policy.Stricter(groupPolicy)
+		}
+	}
+	return policy
+}
+
+// Rules describes each of the policy's rules, in the order CheckPasswordRules
+// checks them.
+//
+// ** Doesn't use a pointer to `p` so it can be use in HTML templates.
+func (p Policy) Rules() []Rule {
+	rules := []Rule{
+		{"length", fmt.Sprintf("Must be %d-%d characters long", p.MinLength, p.MaxLength)},
+	}
+	if p.RequireUpper {
+		rules = append(rules, Rule{"upper", "Must contain an uppercase letter"})
+	}
+	if p.RequireLower {
+		rules = append(rules, Rule{"lower", "Must contain a lowercase letter"})
+	}
+	if p.RequireDigit {
+		rules = append(rules, Rule{"digit", "Must contain a digit"})
+	}
+	if p.RequireSymbol {
+		rules = append(rules, Rule{"symbol", "Must contain a symbol or space"})
+	}
+	rules = append(rules,
+		Rule{"username", "Cannot contain your username"},
+		Rule{"strength", fmt.Sprintf("Must be hard to guess (a strength of at least %d out of %d)",
+			p.MinScore, MaxScore)})
+	if p.HistoryLength > 1 {
+		rules = append(rules, Rule{"", fmt.Sprintf(
+			"Cannot be your current password or one of your last %d passwords",
+			p.HistoryLength)})
+	} else {
+		rules = append(rules, Rule{"", "Cannot be your current password"})
+	}
+	if p.MaxAgeDays > 0 {
+		rules = append(rules, Rule{"", fmt.Sprintf("Must be changed every %d days",
+			p.MaxAgeDays)})
+	}
+	return rules
+}
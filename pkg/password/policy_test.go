@@ -0,0 +1,63 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/ansel1/merry"
+)
+
+func TestPolicyFor(t *testing.T) {
+	err := Init(Config{
+		Policy: Policy{HistoryLength: 3, MaxAgeDays: 365},
+		GroupPolicies: map[string]Policy{
+			"admins": {MinLength: 16, RequireDigit: true, MaxAgeDays: 90},
+			"ops":    {MinScore: 4, HistoryLength: 10, MaxAgeDays: 180},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Init(Config{})
+
+	want := Policy{MinLength: DefaultMinLength, MaxLength: DefaultMaxLength,
+		MinScore: DefaultMinScore, HistoryLength: 3, MaxAgeDays: 365}
+	if got := PolicyFor([]string{"users"}); got != want {
+		t.Errorf("default policy is %+v, want %+v", got, want)
+	}
+	want = Policy{MinLength: 16, MaxLength: DefaultMaxLength, RequireDigit: true,
+		MinScore: MaxScore, HistoryLength: 10, MaxAgeDays: 90}
+	if got := PolicyFor([]string{"admins", "ops"}); got != want {
+		t.Errorf("admins and ops policy is %+v, want %+v", got, want)
+	}
+}
+
+func TestInitInvalidPolicy(t *testing.T) {
+	defer Init(Config{})
+	for _, config := range []Config{
+		{Policy: Policy{MinScore: MaxScore + 1}},
+		{Policy: Policy{MinLength: 20, MaxLength: 12}},
+		{GroupPolicies: map[string]Policy{"admins": {MinLength: DefaultMaxLength + 1}}},
+	} {
+		if Init(config) == nil {
+			t.Errorf("%+v should be invalid", config)
+		}
+	}
+}
+
+func TestCheckPasswordCharacterClasses(t *testing.T) {
+	policy := Policy{MinLength: 10, MaxLength: 64, MinScore: 1, RequireUpper: true,
+		RequireLower: true, RequireDigit: true, RequireSymbol: true}
+	for _, password := range []string{
+		"purple elephants 42", "PURPLE ELEPHANTS 42", "Purple elephants",
+		"PurpleElephants42",
+	} {
+		err := CheckPasswordRules(policy, "jdoe", "", "", password)
+		if !merry.Is(err, ErrPasswordCharacterClass) {
+			t.Errorf("%q returned %v, want %v", password, err, ErrPasswordCharacterClass)
+		}
+	}
+	err := CheckPasswordRules(policy, "jdoe", "", "", "Purple elephants 42")
+	if err != nil {
+		t.Errorf("password with every class returned %v", err)
+	}
+}
@@ -1,58 +1,109 @@
 package password
 
 import (
-	"fmt"
 	"strings"
+	"unicode"
 
 	"github.com/ansel1/merry"
 )
 
-const (
-	// MinLength is the minimum number of characters a password MUST contain.
-	MinLength = 10
-	// MaxLength is the maximum number of characters a password MUST contain.
-	MaxLength = 64
-)
-
 var (
 	// ErrPasswordWeak indicates the password does not meet our rules.
 	ErrPasswordWeak = merry.
 			New("password does not meet the requirements and is considered weak").
 			WithUserMessage("Password does not meet the requirements and is considered too weak.")
-	// ErrPasswordLength indicates the password is not within the min and max
-	// length requirement.
+	// ErrPasswordLength indicates the password is not within the policy's min
+	// and max length.
 	ErrPasswordLength = merry.
-				WithMessage(ErrPasswordWeak, fmt.Sprintf("password must be %d-%d characters long", MinLength, MaxLength)).
-				WithUserMessage(fmt.Sprintf("Password must be %d-%d characters long.", MinLength, MaxLength))
+				WithMessage(ErrPasswordWeak, "password is too short or too long").
+				WithUserMessage("Password is too short or too long.")
+	// ErrPasswordCharacterClass indicates the password is missing a class of
+	// character (e.g. a digit) the policy requires.
+	ErrPasswordCharacterClass = merry.
+					WithMessage(ErrPasswordWeak, "password is missing a required class of character").
+					WithUserMessage("Password is missing a required type of character.")
 	// ErrPasswordContainsName indicates the password is not allowed because it
-	// contains part or all of their name or username.
+	// contains their username.
 	ErrPasswordContainsName = merry.
-				WithMessage(ErrPasswordWeak, "password cannot contain the first, last, and/or username").
-				WithUserMessage("Password cannot contain your any part of your name or username.")
+				WithMessage(ErrPasswordWeak, "password cannot contain the username").
+				WithUserMessage("Password cannot contain your username.")
+	// ErrPasswordGuessable indicates the password's strength score is below
+	// the policy's minimum (see EstimateStrength).
+	ErrPasswordGuessable = merry.
+				WithMessage(ErrPasswordWeak, "password is too easy to guess").
+				WithUserMessage("Password is too easy to guess.")
 )
 
-// CheckPasswordRules returns nil if the password meets all of the requirements.
-// Otherwise, it returns an error describing which rule it currently violates.
+// CheckPasswordRules returns nil if the password meets all of the policy's
+// requirements (see PolicyFor). Otherwise, it returns an error describing
+// which rule it currently violates.
 //
 // Rules:
-//   - Must be at between MinLength and MaxLength
+//   - Must be at between the policy's MinLength and MaxLength
+//   - Must contain each class of character the policy requires
+//   - Must not contain their username
+//   - Must not be in our offline corpus of common and breached passwords (see
+//     Init)
+//   - Must have a strength score of at least the policy's MinScore, where
+//     their username and name are easy to guess (see EstimateStrength)
 //
-// TODO: Check against a list of common passwords - JZ
-// TODO: Check this isn't equal to their current password? - JZ
-func CheckPasswordRules(username string, firstName string, lastName string,
-	password string) error {
+// This doesn't check whether they used the password before, since that needs
+// their password history (see user.SetUserPassword).
+func CheckPasswordRules(policy Policy, username string, firstName string,
+	lastName string, password string) error {
 	// 1. Check password length
 	length := len(password)
-	if length < MinLength || length > MaxLength {
-		return ErrPasswordLength.Here()
+	if length < policy.MinLength || length > policy.MaxLength {
+		return ErrPasswordLength.Here().WithUserMessagef(
+			"Password must be %d-%d characters long.", policy.MinLength, policy.MaxLength)
+	}
+	// 2. Check password for the required classes of characters
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
 	}
-	// 2. Check password against the username, first name, and last name
-	lowerCasePassword := strings.ToLower(password)
-	if strings.Contains(lowerCasePassword, strings.ToLower(username)) ||
-		strings.Contains(lowerCasePassword, strings.ToLower(firstName)) ||
-		strings.Contains(lowerCasePassword, strings.ToLower(lastName)) {
+	switch {
+	case policy.RequireUpper && !upper:
+		return ErrPasswordCharacterClass.Here().WithUserMessage(
+			"Password must contain an uppercase letter.")
+	case policy.RequireLower && !lower:
+		return ErrPasswordCharacterClass.Here().WithUserMessage(
+			"Password must contain a lowercase letter.")
+	case policy.RequireDigit && !digit:
+		return ErrPasswordCharacterClass.Here().WithUserMessage(
+			"Password must contain a digit.")
+	case policy.RequireSymbol && !symbol:
+		return ErrPasswordCharacterClass.Here().WithUserMessage(
+			"Password must contain a symbol or space.")
+	}
+	// 3. Check password against the username. Names aren't rejected outright,
+	// since short ones (e.g. "Al") are in many strong passwords, but they lower
+	// the strength score.
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
 		return ErrPasswordContainsName.Here()
 	}
-	// 3. TODO: Check password against common passwords (reject if found)
+	// 4. Check password against common and breached passwords
+	err := checkBreached(password)
+	if err != nil {
+		return err
+	}
+	// 5. Check how many guesses the password would take
+	strength := EstimateStrength(password, username, firstName, lastName)
+	if strength.Score < policy.MinScore {
+		if strength.Warning != "" {
+			return ErrPasswordGuessable.Here().WithUserMessagef(
+				"Password is too easy to guess. %s", strength.Warning)
+		}
+		return ErrPasswordGuessable.Here()
+	}
 	return nil
 }
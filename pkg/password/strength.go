@@ -0,0 +1,706 @@
+package password
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// This is a simplified version of zxcvbn (Wheeler, "zxcvbn: Low-Budget Password
+// Strength Estimation", USENIX Security 2016). Passwords are split into the
+// patterns an attacker would try first (common words, keyboard rows, repeats,
+// sequences, and dates), and the strength is the fewest guesses needed to find
+// every part.
+
+const (
+	// MaxScore is the score of the hardest to guess passwords.
+	MaxScore = 4
+	// DefaultMinScore is the minimum score when the policy doesn't set one.
+	DefaultMinScore = 3
+
+	// maxEstimateLength bounds the work done for untrusted input. Only the
+	// start of longer passwords is scored, which is enough for MaxScore.
+	maxEstimateLength = 128
+	// minYearSpace is how many years an attacker would try around the current
+	// year.
+	minYearSpace = 20
+	// keyboardKeys and keyboardDegree are the number of keys (including
+	// shifted) and average number of neighbours per key on a QWERTY keyboard.
+	keyboardKeys   = 94
+	keyboardDegree = 4.6
+	// log10MinGuessesBeforeGrowingSequence keeps a short password from scoring
+	// higher by being split into more patterns than needed.
+	log10MinGuessesBeforeGrowingSequence = 4
+)
+
+var (
+	// scoreThresholds are the log10 guesses needed for scores 1 to MaxScore.
+	scoreThresholds = []float64{3, 6, 8, 10}
+
+	// keyboardRows is the QWERTY layout, where each row is shifted right by
+	// offset half keys compared to the row above it.
+	keyboardRows = []struct {
+		keys   string
+		offset int
+	}{
+		{"`1234567890-=", 0},
+		{"qwertyuiop[]\\", 1},
+		{"asdfghjkl;'", 1},
+		{"zxcvbnm,./", 1},
+	}
+	// keyboardPositions maps each unshifted key to its row and column.
+	keyboardPositions = func() map[rune][2]int {
+		positions := map[rune][2]int{}
+		for row, r := range keyboardRows {
+			for col, key := range r.keys {
+				positions[key] = [2]int{row, col + r.offset}
+			}
+		}
+		return positions
+	}()
+	// shiftedKeys maps each shifted symbol to its unshifted key.
+	shiftedKeys = func() map[rune]rune {
+		keys := map[rune]rune{}
+		unshifted := []rune("`1234567890-=[]\\;',./")
+		for i, key := range "~!@#$%^&*()_+{}|:\"<>?" {
+			keys[key] = unshifted[i]
+		}
+		return keys
+	}()
+
+	// l33tTables are the common substitutions for letters. Some symbols could
+	// be more than one letter, so there is a table for each.
+	l33tTables = []map[rune]rune{
+		{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '{': 'c', '[': 'c', '<': 'c',
+			'3': 'e', '6': 'g', '9': 'g', '1': 'i', '!': 'i', '|': 'i', '0': 'o',
+			'$': 's', '5': 's', '7': 't', '+': 't', '%': 'x', '2': 'z'},
+		{'1': 'l', '|': 'l', '7': 'l'},
+	}
+
+	// dateWithSeparators matches dates such as 1/2/99 and 2019-12-31.
+	dateWithSeparators = regexp.MustCompile(`^(\d{1,4})([\s/\\_.-])(\d{1,2})([\s/\\_.-])(\d{1,4})$`)
+)
+
+// Strength is an estimate of how hard a password is to guess, and feedback on
+// how to improve it.
+type Strength struct {
+	// Score is from 0 (too guessable) to MaxScore (very unguessable).
+	Score int `json:"score"`
+	// Guesses is the log10 of the number of guesses needed.
+	Guesses float64 `json:"guesses"`
+	// Warning explains what makes the password guessable, if anything.
+	Warning     string   `json:"warning,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// match is part of a password that fits a pattern.
+type match struct {
+	// i and j are the first and last rune of the match.
+	i, j    int
+	pattern string
+	// guesses is the log10 of the number of guesses needed for this part.
+	guesses float64
+
+	// These describe the match for feedback.
+	token     []rune
+	rank      int
+	userInput bool
+	reversed  bool
+	l33t      bool
+	turns     int
+	unit      []rune
+}
+
+// EstimateStrength returns how hard the password is to guess. The user inputs
+// (e.g. their username and name) are treated as the most common words, since
+// an attacker targeting them would try those first.
+func EstimateStrength(password string, userInputs ...string) Strength {
+	runes := []rune(password)
+	if len(runes) > maxEstimateLength {
+		runes = runes[:maxEstimateLength]
+	}
+	inputs := map[string]int{}
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if input != "" {
+			inputs[input] = 1
+		}
+	}
+	guesses, sequence := estimateGuesses(runes, inputs)
+	s := Strength{Guesses: guesses}
+	for _, threshold := range scoreThresholds {
+		if guesses >= threshold {
+			s.Score++
+		}
+	}
+	s.Warning, s.Suggestions = feedback(s.Score, sequence)
+	return s
+}
+
+// estimateGuesses returns the log10 of the number of guesses needed, and the
+// matches which need the fewest guesses.
+func estimateGuesses(password []rune, inputs map[string]int) (float64, []match) {
+	if len(password) == 0 {
+		return 0, nil
+	}
+	var matches []match
+	matches = append(matches, dictionaryMatches(password, inputs)...)
+	matches = append(matches, spatialMatches(password)...)
+	matches = append(matches, repeatMatches(password, inputs)...)
+	matches = append(matches, sequenceMatches(password)...)
+	matches = append(matches, dateMatches(password)...)
+	return mostGuessableSequence(password, matches)
+}
+
+// mostGuessableSequence finds the non-overlapping matches that cover the
+// password in the fewest guesses. Parts without a match are brute forced.
+//
+// An attacker doesn't know how many patterns are used, or their order, so a
+// sequence of l matches needs l! times the product of their guesses.
+func mostGuessableSequence(password []rune, matches []match) (float64, []match) {
+	n := len(password)
+	type step struct {
+		ok bool
+		// product is the log10 of the product of the guesses of the matches.
+		product float64
+		// guesses is the log10 of the guesses for the whole sequence.
+		guesses float64
+		m       match
+	}
+	// optimal[k][l] is the best sequence of l matches ending at rune k
+	optimal := make([][]step, n)
+	for k := range optimal {
+		optimal[k] = make([]step, n+1)
+	}
+	update := func(m match, l int) {
+		product := m.guesses
+		if l > 1 {
+			product += optimal[m.i-1][l-1].product
+		}
+		factorial, _ := math.Lgamma(float64(l + 1))
+		guesses := logAdd(factorial/math.Ln10+product,
+			float64(log10MinGuessesBeforeGrowingSequence*(l-1)))
+		// Skip if a sequence with as many or fewer matches is as good
+		for other := 1; other <= l; other++ {
+			if s := optimal[m.j][other]; s.ok && s.guesses <= guesses {
+				return
+			}
+		}
+		optimal[m.j][l] = step{ok: true, product: product, guesses: guesses, m: m}
+	}
+	byEnd := make([][]match, n)
+	for _, m := range matches {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+	for k := 0; k < n; k++ {
+		for _, m := range byEnd[k] {
+			if m.i == 0 {
+				update(m, 1)
+				continue
+			}
+			for l, s := range optimal[m.i-1] {
+				if s.ok {
+					update(m, l+1)
+				}
+			}
+		}
+		// Brute force from i to k, unless that continues another brute force
+		update(bruteforceMatch(password, 0, k), 1)
+		for i := 1; i <= k; i++ {
+			for l, s := range optimal[i-1] {
+				if s.ok && s.m.pattern != "bruteforce" {
+					update(bruteforceMatch(password, i, k), l+1)
+				}
+			}
+		}
+	}
+	// Unwind the best sequence ending at the last rune
+	best := 0
+	for l, s := range optimal[n-1] {
+		if s.ok && (best == 0 || s.guesses < optimal[n-1][best].guesses) {
+			best = l
+		}
+	}
+	sequence := make([]match, best)
+	for k, l := n-1, best; l > 0; l-- {
+		sequence[l-1] = optimal[k][l].m
+		k = optimal[k][l].m.i - 1
+	}
+	return optimal[n-1][best].guesses, sequence
+}
+
+// logAdd returns log10(10^a + 10^b).
+func logAdd(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	return a + math.Log10(1+math.Pow(10, b-a))
+}
+
+// newMatch returns a match of password[i:j+1] with at least the minimum
+// guesses for its length, so patterns are never cheaper than a guess.
+func newMatch(password []rune, i, j int, pattern string, guesses float64) match {
+	min := 50.0
+	if i == j {
+		min = 10
+	}
+	return match{i: i, j: j, pattern: pattern, token: password[i : j+1],
+		guesses: math.Log10(math.Max(guesses, min))}
+}
+
+// bruteforceMatch is a match of password[i:j+1] without a pattern.
+func bruteforceMatch(password []rune, i, j int) match {
+	// Each rune is about ten guesses. The minimum is one more than for
+	// patterns, so brute force is never chosen over an equal pattern.
+	length := j - i + 1
+	min := 51.0
+	if length == 1 {
+		min = 11
+	}
+	return match{i: i, j: j, pattern: "bruteforce", token: password[i : j+1],
+		guesses: math.Max(float64(length), math.Log10(min))}
+}
+
+// dictionaryMatches finds common words and user inputs, including reversed
+// and with l33t substitutions (e.g. 'p@ssw0rd').
+func dictionaryMatches(password []rune, inputs map[string]int) []match {
+	n := len(password)
+	lower := []rune(strings.ToLower(string(password)))
+	reversed := make([]rune, n)
+	for i, r := range lower {
+		reversed[n-1-i] = r
+	}
+	var matches []match
+	for _, w := range findWords(lower, inputs) {
+		m := newMatch(password, w.i, w.j, "dictionary",
+			float64(w.rank)*upperVariations(password[w.i:w.j+1]))
+		m.rank, m.userInput = w.rank, w.userInput
+		matches = append(matches, m)
+	}
+	for _, w := range findWords(reversed, inputs) {
+		i, j := n-1-w.j, n-1-w.i
+		if w.word == string(lower[i:j+1]) {
+			continue // Palindromes are already matched
+		}
+		m := newMatch(password, i, j, "dictionary",
+			float64(w.rank)*upperVariations(password[i:j+1])*2)
+		m.rank, m.userInput, m.reversed = w.rank, w.userInput, true
+		matches = append(matches, m)
+	}
+	for _, table := range l33tTables {
+		translated := make([]rune, n)
+		for i, r := range lower {
+			translated[i] = r
+			if letter, ok := table[r]; ok {
+				translated[i] = letter
+			}
+		}
+		for _, w := range findWords(translated, inputs) {
+			substitutions := 0
+			for k := w.i; k <= w.j; k++ {
+				if translated[k] != lower[k] {
+					substitutions++
+				}
+			}
+			if substitutions == 0 {
+				continue // Already matched without substitutions
+			}
+			m := newMatch(password, w.i, w.j, "dictionary",
+				float64(w.rank)*upperVariations(password[w.i:w.j+1])*
+					math.Pow(2, float64(substitutions)))
+			m.rank, m.userInput, m.l33t = w.rank, w.userInput, true
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// word is a common word or user input found in a password.
+type word struct {
+	i, j      int
+	word      string
+	rank      int
+	userInput bool
+}
+
+// findWords returns every common word or user input in the lowercase password.
+func findWords(lower []rune, inputs map[string]int) []word {
+	var words []word
+	for i := range lower {
+		for j := i; j < len(lower); j++ {
+			w := string(lower[i : j+1])
+			if rank, ok := inputs[w]; ok {
+				words = append(words, word{i: i, j: j, word: w, rank: rank, userInput: true})
+			} else if rank, ok := rankedWords[w]; ok {
+				words = append(words, word{i: i, j: j, word: w, rank: rank})
+			}
+		}
+	}
+	return words
+}
+
+// upperVariations returns how many ways the word could have been capitalized
+// like the token. Capitalizing the first or last letter, or all of them, is
+// common, so is only twice as many guesses.
+func upperVariations(token []rune) float64 {
+	var upper, lower int
+	for _, r := range token {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || (upper == 1 && (unicode.IsUpper(token[0]) ||
+		unicode.IsUpper(token[len(token)-1]))) {
+		return 2
+	}
+	variations := 0.0
+	for k := 1; k <= upper && k <= lower; k++ {
+		variations += binomial(upper+lower, k)
+	}
+	return variations
+}
+
+// binomial returns n choose k.
+func binomial(n, k int) float64 {
+	result := 1.0
+	for d := 1; d <= k; d++ {
+		result = result * float64(n-k+d) / float64(d)
+	}
+	return result
+}
+
+// keyboardDirection returns which of the six neighbouring keys b is to a, or -1
+// if they aren't neighbours.
+func keyboardDirection(a, b rune) int {
+	pa, ok := keyboardPositions[a]
+	if !ok {
+		return -1
+	}
+	pb, ok := keyboardPositions[b]
+	if !ok {
+		return -1
+	}
+	dr, dc := pb[0]-pa[0], pb[1]-pa[1]
+	switch {
+	case dr == 0 && dc == -1:
+		return 0
+	case dr == 0 && dc == 1:
+		return 1
+	case dr == -1 && dc == 0:
+		return 2
+	case dr == -1 && dc == 1:
+		return 3
+	case dr == 1 && dc == -1:
+		return 4
+	case dr == 1 && dc == 0:
+		return 5
+	}
+	return -1
+}
+
+// unshift returns the key for the rune, and true if it needs shift.
+func unshift(r rune) (rune, bool) {
+	if key, ok := shiftedKeys[r]; ok {
+		return key, true
+	}
+	if unicode.IsUpper(r) {
+		return unicode.ToLower(r), true
+	}
+	return r, false
+}
+
+// spatialMatches finds runs of three or more neighbouring keys (e.g. 'qwerty'
+// and 'zaq1').
+func spatialMatches(password []rune) []match {
+	var matches []match
+	for i := 0; i < len(password)-1; {
+		j, turns, direction := i, 0, -1
+		_, shifted := unshift(password[i])
+		shiftedCount := 0
+		if shifted {
+			shiftedCount++
+		}
+		for j+1 < len(password) {
+			a, _ := unshift(password[j])
+			b, shifted := unshift(password[j+1])
+			d := keyboardDirection(a, b)
+			if d < 0 {
+				break
+			}
+			if d != direction {
+				turns++
+				direction = d
+			}
+			if shifted {
+				shiftedCount++
+			}
+			j++
+		}
+		if j-i+1 >= 3 {
+			m := newMatch(password, i, j, "spatial",
+				spatialGuesses(j-i+1, turns, shiftedCount))
+			m.turns = turns
+			matches = append(matches, m)
+		}
+		if j == i {
+			i++
+		} else {
+			i = j
+		}
+	}
+	return matches
+}
+
+// spatialGuesses returns how many keyboard patterns up to the length there
+// are, with up to the number of turns.
+func spatialGuesses(length, turns, shifted int) float64 {
+	guesses := 0.0
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= turns && j <= i-1; j++ {
+			guesses += binomial(i-1, j-1) * keyboardKeys * math.Pow(keyboardDegree, float64(j))
+		}
+	}
+	unshifted := length - shifted
+	if shifted > 0 {
+		if unshifted == 0 {
+			guesses *= 2
+		} else {
+			variations := 0.0
+			for k := 1; k <= shifted && k <= unshifted; k++ {
+				variations += binomial(length, k)
+			}
+			guesses *= variations
+		}
+	}
+	return guesses
+}
+
+// repeatMatches finds repeated characters or strings (e.g. 'aaa' and
+// 'abcabc'). Each is as hard to guess as what's repeated, times the repeats.
+func repeatMatches(password []rune, inputs map[string]int) []match {
+	var matches []match
+	for i := 0; i < len(password); {
+		bestUnit, bestCount := 0, 0
+		for unit := 1; i+2*unit <= len(password); unit++ {
+			count := 1
+			for i+(count+1)*unit <= len(password) &&
+				string(password[i+count*unit:i+(count+1)*unit]) == string(password[i:i+unit]) {
+				count++
+			}
+			if count >= 2 && unit*count > bestUnit*bestCount {
+				bestUnit, bestCount = unit, count
+			}
+		}
+		if bestCount == 0 {
+			i++
+			continue
+		}
+		unit := password[i : i+bestUnit]
+		unitGuesses, _ := estimateGuesses(unit, inputs)
+		m := newMatch(password, i, i+bestUnit*bestCount-1, "repeat",
+			math.Pow(10, unitGuesses)*float64(bestCount))
+		m.unit = unit
+		matches = append(matches, m)
+		i += bestUnit * bestCount
+	}
+	return matches
+}
+
+// sequenceMatches finds runs of three or more characters with the same small
+// step between them (e.g. 'abc', '7531', and 'zyx').
+func sequenceMatches(password []rune) []match {
+	var matches []match
+	for i := 0; i < len(password)-1; {
+		delta := password[i+1] - password[i]
+		j := i + 1
+		for j+1 < len(password) && password[j+1]-password[j] == delta {
+			j++
+		}
+		if j-i >= 2 && delta != 0 && delta >= -5 && delta <= 5 {
+			base := 26.0
+			switch {
+			case strings.ContainsRune("aAzZ019", password[i]):
+				base = 4 // Obvious starting points
+			case unicode.IsDigit(password[i]):
+				base = 10
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			matches = append(matches,
+				newMatch(password, i, j, "sequence", base*float64(j-i+1)))
+		}
+		i = j
+	}
+	return matches
+}
+
+// dateMatches finds years (e.g. '1987') and dates with or without separators
+// (e.g. '13/5/87' and '19870513').
+func dateMatches(password []rune) []match {
+	var matches []match
+	for i := range password {
+		for j := i + 3; j < len(password) && j < i+10; j++ {
+			token := string(password[i : j+1])
+			var year int
+			var ok, separated bool
+			if digits := isDigits(token); digits && len(token) == 4 {
+				year, _ = strconv.Atoi(token)
+				if year >= 1900 && year <= 2050 {
+					m := newMatch(password, i, j, "date", yearSpace(year))
+					matches = append(matches, m)
+				}
+				continue
+			} else if digits && len(token) == 6 {
+				year, ok = parseDate(token[:2], token[2:4], token[4:])
+			} else if digits && len(token) == 8 {
+				year, ok = parseDate(token[:4], token[4:6], token[6:])
+				if !ok {
+					year, ok = parseDate(token[:2], token[2:4], token[4:])
+				}
+			} else if parts := dateWithSeparators.FindStringSubmatch(token); parts != nil &&
+				parts[2] == parts[4] {
+				year, ok = parseDate(parts[1], parts[3], parts[5])
+				separated = true
+			}
+			if !ok {
+				continue
+			}
+			guesses := yearSpace(year) * 365
+			if separated {
+				guesses *= 4
+			}
+			matches = append(matches, newMatch(password, i, j, "date", guesses))
+		}
+	}
+	return matches
+}
+
+// isDigits returns true if s is only ASCII digits.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// yearSpace is how many years an attacker would try to find the year.
+func yearSpace(year int) float64 {
+	return math.Max(math.Abs(float64(year-time.Now().Year())), minYearSpace)
+}
+
+// parseDate returns the year if the parts are a valid date in year-month-day,
+// day-month-year, or month-day-year order.
+func parseDate(a, b, c string) (year int, ok bool) {
+	first, _ := strconv.Atoi(a)
+	second, _ := strconv.Atoi(b)
+	third, _ := strconv.Atoi(c)
+	if len(a) == 2 || len(a) == 4 {
+		if year, ok := validDate(first, second, third, len(a)); ok {
+			return year, true
+		}
+	}
+	if len(c) == 2 || len(c) == 4 {
+		if year, ok := validDate(third, second, first, len(c)); ok {
+			return year, true
+		}
+		if year, ok := validDate(third, first, second, len(c)); ok {
+			return year, true
+		}
+	}
+	return 0, false
+}
+
+// validDate returns the year (expanding two digit years) if it's a valid date.
+func validDate(year, month, day, yearDigits int) (int, bool) {
+	if yearDigits == 2 {
+		if year < 50 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+	if year < 1900 || year > 2050 || month < 1 || month > 12 || day < 1 || day > 31 {
+		return 0, false
+	}
+	return year, true
+}
+
+// feedback explains what makes a weak password guessable, using its longest
+// pattern.
+func feedback(score int, sequence []match) (warning string, suggestions []string) {
+	if len(sequence) == 0 {
+		return "", []string{"Use a few words, and avoid common phrases.",
+			"No need for symbols, digits, or uppercase letters."}
+	}
+	if score > 2 {
+		return "", nil
+	}
+	longest := sequence[0]
+	for _, m := range sequence[1:] {
+		if len(m.token) > len(longest.token) {
+			longest = m
+		}
+	}
+	suggestions = []string{"Add another word or two. Uncommon words are better."}
+	switch longest.pattern {
+	case "dictionary":
+		switch {
+		case longest.userInput:
+			warning = "Your name and username are easy to guess."
+		case len(sequence) == 1 && longest.rank <= commonPasswordCount && !longest.l33t && !longest.reversed:
+			warning = "This is a very common password."
+		case len(sequence) == 1:
+			warning = "This is similar to a commonly used password."
+		default:
+			warning = "Common words and names are easy to guess."
+		}
+		token := string(longest.token)
+		if token == strings.ToUpper(token) && token != strings.ToLower(token) {
+			suggestions = append(suggestions, "All-uppercase is almost as easy to guess as all-lowercase.")
+		} else if unicode.IsUpper(longest.token[0]) {
+			suggestions = append(suggestions, "Capitalization doesn't help very much.")
+		}
+		if longest.reversed {
+			suggestions = append(suggestions, "Reversed words aren't much harder to guess.")
+		}
+		if longest.l33t {
+			suggestions = append(suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much.")
+		}
+	case "spatial":
+		if longest.turns == 1 {
+			warning = "Straight rows of keys are easy to guess."
+		} else {
+			warning = "Short keyboard patterns are easy to guess."
+		}
+		suggestions = append(suggestions, "Use a longer keyboard pattern with more turns.")
+	case "repeat":
+		if len(longest.unit) == 1 {
+			warning = `Repeats like "aaa" are easy to guess.`
+		} else {
+			warning = `Repeats like "abcabc" are only slightly harder to guess than "abc".`
+		}
+		suggestions = append(suggestions, "Avoid repeated words and characters.")
+	case "sequence":
+		warning = "Sequences like abc or 6543 are easy to guess."
+		suggestions = append(suggestions, "Avoid sequences.")
+	case "date":
+		warning = "Dates and years are often easy to guess."
+		suggestions = append(suggestions, "Avoid dates and years that are associated with you.")
+	default:
+		if score <= 1 {
+			warning = "Short passwords are easy to guess."
+		}
+	}
+	return warning, suggestions
+}
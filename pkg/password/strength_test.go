@@ -0,0 +1,74 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/ansel1/merry"
+)
+
+func TestEstimateStrength(t *testing.T) {
+	tests := []struct {
+		password   string
+		userInputs []string
+		maxScore   int
+		minScore   int
+		pattern    string
+	}{
+		{"aaaaaaaaaa", nil, 0, 0, "repeat"},
+		{"abcabcabcabc", nil, 0, 0, "repeat"},
+		{"password", nil, 0, 0, "dictionary"},
+		{"P@ssw0rd", nil, 1, 0, "dictionary"},
+		{"qwertyuiop", nil, 0, 0, "dictionary"},
+		{"zxcvbnm,./", nil, 1, 0, "spatial"},
+		{"abcdefghijkl", nil, 0, 0, "sequence"},
+		{"19870513", nil, 1, 0, "date"},
+		{"ziegler", []string{"ziegler"}, 0, 0, "dictionary"},
+		{"correct horse battery staple", nil, MaxScore, MaxScore, ""},
+		{"Purple elephants dance with Al", []string{"Al"}, MaxScore, MaxScore, ""},
+	}
+	for _, test := range tests {
+		s := EstimateStrength(test.password, test.userInputs...)
+		if s.Score < test.minScore || s.Score > test.maxScore {
+			t.Errorf("%q scored %d, want %d-%d", test.password, s.Score,
+				test.minScore, test.maxScore)
+		}
+		if test.maxScore <= 2 && s.Warning == "" {
+			t.Errorf("%q has no warning", test.password)
+		}
+		if test.pattern == "" {
+			continue
+		}
+		inputs := map[string]int{}
+		for _, input := range test.userInputs {
+			inputs[input] = 1
+		}
+		_, sequence := estimateGuesses([]rune(test.password), inputs)
+		found := false
+		for _, m := range sequence {
+			found = found || m.pattern == test.pattern
+		}
+		if !found {
+			t.Errorf("%q wasn't matched as a %s", test.password, test.pattern)
+		}
+	}
+}
+
+func TestCheckPasswordRules(t *testing.T) {
+	tests := []struct {
+		password string
+		err      error
+	}{
+		{"short", ErrPasswordLength},
+		{"my name is jdoe, hi", ErrPasswordContainsName},
+		{"aaaaaaaaaa", ErrPasswordGuessable},
+		{"Doe12345678", ErrPasswordGuessable},
+		// Short names are only a small part of a strong passphrase
+		{"Purple elephants dance with Jo", nil},
+	}
+	for _, test := range tests {
+		err := CheckPasswordRules(defaultPolicy, "jdoe", "Jo", "Doe", test.password)
+		if (test.err == nil && err != nil) || (test.err != nil && !merry.Is(err, test.err)) {
+			t.Errorf("%q returned %v, want %v", test.password, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package password
+
+import "strings"
+
+// commonWords are ranked by how often they're used in passwords (most common
+// first). The first commonPasswordCount are whole passwords, and the rest are
+// words and names which are often part of one. This list is small so it can be
+// built in; the offline corpus (see Init) catches the long tail.
+const commonWords = `
+password 123456 12345678 qwerty abc123 monkey letmein dragon 111111
+baseball iloveyou trustno1 1234567 sunshine master 123123 welcome shadow
+ashley football jesus michael ninja mustang password1 admin login princess
+solo starwars passw0rd qwertyuiop zaq1zaq1 654321 superman 1qaz2wsx 7777777
+121212 000000 qazwsx 123qwe killer jordan jennifer zxcvbnm asdfgh hunter
+buster soccer harley batman andrew tigger charlie robert thomas hockey
+ranger daniel hannah maggie jessica pepper 1234 matrix computer freedom
+whatever secret summer internet hello access flower cookie samsung
+liverpool chelsea arsenal pokemon loveme lovely babygirl changeme default
+test guest root administrator user server michelle nicole ginger 696969
+biteme 112233 123321 666666 aaaaaa
+
+love life time world money house home family friend baby angel girl boy
+happy lucky magic music game games player star stars moon sun sky blue red
+green black white pink purple orange yellow silver gold diamond king queen
+prince lord god devil heaven hell dream dreams peace power fire water ice
+snow rain storm winter spring autumn fall night day morning cat dog puppy
+kitty tiger lion bear wolf eagle horse fish shark snake bird apple orange
+banana cherry lemon chocolate coffee pizza cheese candy sugar sweet honey
+cool crazy funny smile happy sexy hot fuck shit beautiful pretty little big
+super mega ultra best first last one two three four five six seven eight
+nine ten hundred thousand letme change please open sesame hockey tennis golf
+rock metal punk rap jazz guitar piano dance party summer beach ocean island
+city country america usa london paris texas florida california john david
+james mary linda patricia elizabeth susan sarah karen nancy lisa betty
+margaret sandra william richard joseph christopher matthew anthony mark
+donald steven paul kevin brian george edward ronald timothy jason jeffrey
+ryan jacob gary nicholas eric jonathan stephen larry justin scott brandon
+benjamin samuel frank gregory raymond alexander patrick jack dennis jerry
+tyler aaron jose adam henry nathan douglas zachary peter kyle walter ethan
+jeremy harold keith christian roger noah gerald carl terry sean austin
+arthur lawrence jesse dylan bryan joe jordan billy bruce albert willie
+gabriel logan alan juan wayne roy ralph randy eugene vincent russell louis
+bobby philip johnny emily emma olivia sophia isabella mia amelia abigail
+madison chloe grace anna alice julia rose lily victoria natalie samantha
+company office work school college student teacher secure system network
+account private public security welcome winter2020 summer2020
+`
+
+// commonPasswordCount is how many of commonWords are whole passwords.
+const commonPasswordCount = 100
+
+// rankedWords maps each of commonWords to its rank (starting at 1).
+var rankedWords = func() map[string]int {
+	ranks := map[string]int{}
+	for i, word := range strings.Fields(commonWords) {
+		if _, ok := ranks[word]; !ok {
+			ranks[word] = i + 1
+		}
+	}
+	return ranks
+}()
@@ -20,9 +20,10 @@ var (
 	store secrets
 )
 
-// secrets holds four key secrets for running the web server that should be
+// secrets holds the key secrets for running the web server that should be
 // saved between runs (to prevent user sessions, cookies, and CSRF tokens from
-// being invalidated, and to provide password reset tokens).
+// being invalidated, to provide password reset and email verification tokens,
+// and to decrypt stored two-factor secrets).
 //
 // These cannot be changed after init(), and are only provided via getters!
 type secrets struct {
@@ -30,6 +31,8 @@ type secrets struct {
 	EncryptionKey       []byte
 	CSRFKey             []byte
 	PasswordResetSecret []byte
+	TOTPKey             []byte
+	EmailVerifySecret   []byte
 }
 
 // AuthKey is used to authenticate the cookie value using HMAC.
@@ -55,6 +58,17 @@ func PasswordResetSecret() []byte {
 	return store.PasswordResetSecret
 }
 
+// TOTPKey is used to encrypt users' TOTP secrets before storing them in the
+// database. It's 32 bytes long for AES-256.
+func TOTPKey() []byte {
+	return store.TOTPKey
+}
+
+// EmailVerifySecret is used for securing email address verification links.
+func EmailVerifySecret() []byte {
+	return store.EmailVerifySecret
+}
+
 // init loads the secrets JSON file from disk (if it exists), and if any of the
 // secrets are missing, it will create them and save the resulting secrets back
 // to disk as JSON.
@@ -89,6 +103,14 @@ func init() {
 		store.PasswordResetSecret = securecookie.GenerateRandomKey(32)
 		writeFile = true
 	}
+	if len(store.TOTPKey) < 1 {
+		store.TOTPKey = securecookie.GenerateRandomKey(32)
+		writeFile = true
+	}
+	if len(store.EmailVerifySecret) < 1 {
+		store.EmailVerifySecret = securecookie.GenerateRandomKey(32)
+		writeFile = true
+	}
 
 	if writeFile {
 		// Save to disk
@@ -0,0 +1,129 @@
+// Package totp implements Time-Based One-Time Passwords as described in
+// RFC 6238, using the defaults understood by common authenticator apps
+// (HMAC-SHA1, 6 digits, and a 30 second time step).
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+const (
+	// Digits is the number of digits in each code.
+	Digits = 6
+	// Period is the number of seconds each code is valid for.
+	Period = 30
+	// Skew is the number of time steps before and after the current one we
+	// accept, to allow for clock drift and slow typists.
+	Skew = 1
+	// secretLength is the number of random bytes in a new secret. RFC 4226
+	// recommends 160 bits, which matches the HMAC-SHA1 block output.
+	secretLength = 20
+)
+
+var (
+	// encoding is the unpadded base32 alphabet used by authenticator apps.
+	encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	// ErrInvalidSecret indicates the shared secret could not be decoded.
+	ErrInvalidSecret = merry.New("invalid TOTP secret")
+)
+
+// NewSecret returns a new random shared secret, encoded as base32.
+func NewSecret() (string, error) {
+	b := make([]byte, secretLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// decodeSecret decodes a base32 secret, ignoring case, spaces, and padding
+// since users may type these in by hand.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) < 1 {
+		return nil, ErrInvalidSecret.Here()
+	}
+	return key, nil
+}
+
+// Step returns the time step (the moving factor T in RFC 6238) for t.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// codeAt returns the code for a given time step using HOTP (RFC 4226).
+func codeAt(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// Code returns the code for the given secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return codeAt(key, Step(t)), nil
+}
+
+// Validate checks the code against the secret at time t, allowing for Skew
+// steps of clock drift. If valid, it returns the time step the code matched so
+// the caller can reject it if it's used again (replay protection).
+func Validate(secret string, code string, t time.Time) (step int64, valid bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Step(t)
+	for i := int64(-Skew); i <= Skew; i++ {
+		expected := codeAt(key, current+i)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return current + i, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth:// URI used to enroll an authenticator app, which is
+// typically shown to the user as a QR code.
+//
+// See: https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func URI(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", Digits))
+	params.Set("period", fmt.Sprintf("%d", Period))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
@@ -0,0 +1,66 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	// rfcSecret is the SHA1 seed from RFC 6238 Appendix B ("12345678901234567890")
+	rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+)
+
+// TestRFC6238 uses the SHA1 test vectors from RFC 6238 Appendix B, truncated
+// to the last six digits.
+func TestRFC6238(t *testing.T) {
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for seconds, expected := range vectors {
+		code, err := Code(rfcSecret, time.Unix(seconds, 0))
+		if err != nil {
+			t.Fatalf("Code failed: %s", err)
+		}
+		if code != expected {
+			t.Errorf("Code at %d: %s != %s", seconds, code, expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, now)
+	step, ok := Validate(rfcSecret, code, now)
+	if !ok || step != Step(now) {
+		t.Errorf("Validate failed for current code: %v, %d", ok, step)
+	}
+	// Allow one step of clock drift in either direction
+	_, ok = Validate(rfcSecret, code, now.Add(Period*time.Second))
+	if !ok {
+		t.Errorf("Validate failed for previous step")
+	}
+	_, ok = Validate(rfcSecret, code, now.Add(3*Period*time.Second))
+	if ok {
+		t.Errorf("Validate accepted a code three steps old")
+	}
+	_, ok = Validate(rfcSecret, "000000", now)
+	if ok {
+		t.Errorf("Validate accepted an invalid code")
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret failed: %s", err)
+	}
+	_, err = Code(secret, time.Now())
+	if err != nil {
+		t.Errorf("NewSecret returned a secret we can't use: %s", err)
+	}
+}
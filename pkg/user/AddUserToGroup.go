@@ -1,20 +1,31 @@
 package user
 
 import (
+	"time"
+
 	"github.com/ansel1/merry"
 	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
 )
 
 // setUserGroupMembership is a helper function for AddUserToGroup and
 // RemoveUserFromGroup. If add is true, it adds the user to the group. If add is
-// false, it removes the user from the group.
-func setUserGroupMembership(tx *sqlx.Tx, user string, group string, add bool) error {
+// false, it removes the user from the group. Archived users' groups are kept as
+// they were, so this returns ErrorUserArchived for them.
+func setUserGroupMembership(tx *sqlx.Tx, actor audit.Actor, user string, group string,
+	add bool) error {
 	// 1. Get the UserID and GroupID here once, instead of doing two SQL JOINS
 	var userID, groupID uint64
-	err := tx.Get(&userID, `SELECT ID FROM Users WHERE Username=?;`, user)
+	var archivedAt time.Time
+	err := tx.QueryRowx(`SELECT ID, ArchivedAt FROM Users WHERE Username=?;`,
+		user).Scan(&userID, &archivedAt)
 	if err != nil {
 		return merry.Wrap(err)
 	}
+	if !archivedAt.IsZero() {
+		return ErrorUserArchived.Here()
+	}
 	err = tx.Get(&groupID, `SELECT ID FROM UserGroups WHERE Name=?;`, group)
 	if err != nil {
 		return merry.Wrap(err)
@@ -34,22 +45,31 @@ func setUserGroupMembership(tx *sqlx.Tx, user string, group string, add bool) er
 		if err != nil {
 			return merry.Wrap(err)
 		}
+		return audit.Record(tx, actor, "group.member_add", group, "", user)
 	} else if !add && inGroup {
 		_, err = tx.Exec(`DELETE FROM User2Group
 						  WHERE UserID=? AND GroupID=?;`, userID, groupID)
 		if err != nil {
 			return merry.Wrap(err)
 		}
+		err = checkRoleManagersRemain(tx)
+		if err != nil {
+			return err
+		}
+		return audit.Record(tx, actor, "group.member_remove", group, user, "")
 	}
 	return nil
 }
 
-// AddUserToGroup adds the User to a Group.
-func AddUserToGroup(tx *sqlx.Tx, user string, group string) error {
-	return setUserGroupMembership(tx, user, group, true)
+// AddUserToGroup adds the User to a Group. Returns ErrorUserArchived if the
+// user is archived.
+func AddUserToGroup(tx *sqlx.Tx, actor audit.Actor, user string, group string) error {
+	return setUserGroupMembership(tx, actor, user, group, true)
 }
 
-// RemoveUserFromGroup removes the User from a Group.
-func RemoveUserFromGroup(tx *sqlx.Tx, user string, group string) error {
-	return setUserGroupMembership(tx, user, group, false)
+// RemoveUserFromGroup removes the User from a Group. Returns ErrorUserArchived
+// if the user is archived, or ErrorNoRoleManagers if nobody would be left with
+// PermRolesManage.
+func RemoveUserFromGroup(tx *sqlx.Tx, actor audit.Actor, user string, group string) error {
+	return setUserGroupMembership(tx, actor, user, group, false)
 }
@@ -1,54 +1,113 @@
 package user
 
 import (
+	"database/sql"
 	"time"
 
 	"github.com/ansel1/merry"
 	"github.com/jmoiron/sqlx"
 
+	"github.com/joshsziegler/zauth/pkg/audit"
 	pw "github.com/joshsziegler/zauth/pkg/password"
 	"github.com/joshsziegler/zgo/pkg/log"
 )
 
-// Login returns nil IFF the account is not disabled AND the password is correct
-func Login(tx *sqlx.Tx, username string, password string) (err error) {
+// Login returns nil IFF the account is not disabled or expired AND the password
+// is correct. Returns ErrorPasswordExpired if the password is correct, but has
+// expired; whether to let them in (e.g. to change it) is up to the caller.
+// Both correct passwords (as auth.password_ok) and failed logins are recorded,
+// as the user being logged in. The user may still need a second factor, so the
+// caller MUST call UpdateLastLogin once they have provided every factor.
+//
+// The caller MUST commit the transaction even if the login failed, so the
+// failure is recorded.
+func Login(tx *sqlx.Tx, actor audit.Actor, username string, password string) (err error) {
+	actor = actor.As(username)
+	var userID int64
 	var correctPasswordHash string
 	var disabled bool
-	err = tx.QueryRowx(`SELECT PasswordHash, Disabled
+	var expiresAt, passwordSet time.Time
+	err = tx.QueryRowx(`SELECT ID, PasswordHash, Disabled, ExpiresAt, PasswordSet
 						FROM Users
 						WHERE Username=?`,
-		username).Scan(&correctPasswordHash, &disabled)
-	if err != nil {
+		username).Scan(&userID, &correctPasswordHash, &disabled, &expiresAt, &passwordSet)
+	if err == sql.ErrNoRows {
+		return recordLoginFailure(tx, actor, username, "unknown user", merry.Wrap(err))
+	} else if err != nil {
 		return merry.Wrap(err)
 	}
 
 	if disabled {
-		return ErrorLoginDisabled.Here().WithMessagef("user '%s' is disabled", username)
+		return recordLoginFailure(tx, actor, username, "disabled",
+			ErrorLoginDisabled.Here().WithMessagef("user '%s' is disabled", username))
+	}
+	if isExpired(expiresAt, time.Now()) {
+		return recordLoginFailure(tx, actor, username, "expired",
+			ErrorLoginExpired.Here().WithMessagef("user '%s' expired on %s", username,
+				expiresAt.Format(time.RFC3339)))
 	}
 
-	valid, insecure, err := pw.Valid(password, correctPasswordHash)
+	valid, outdated, err := pw.Valid(password, correctPasswordHash)
 	if err != nil {
 		return merry.Wrap(err)
 	}
 	if !valid {
-		return ErrorLoginPassword.Here().WithMessagef("wrong password for '%s'", username)
+		return recordLoginFailure(tx, actor, username, "wrong password",
+			ErrorLoginPassword.Here().WithMessagef("wrong password for '%s'", username))
 	}
 
-	// Update LastLogin
-	_, err = tx.Exec(`UPDATE Users
-		 			  SET LastLogin=?
-		 			  WHERE Username=?`, time.Now(), username)
+	policy, err := getPasswordPolicy(tx, userID)
 	if err != nil {
-		return merry.Wrap(err)
+		return err
+	}
+	passwordExpired := isExpired(passwordExpiresAt(passwordSet, policy.MaxAgeDays),
+		time.Now())
+	method := "password"
+	if passwordExpired {
+		method = "password (expired)"
+	}
+	err = audit.Record(tx, actor, "auth.password_ok", username, "", method)
+	if err != nil {
+		return err
 	}
 
-	// Update PasswordHash IFF it's using an insecure hashing method (e.g. MD5)
-	if insecure {
-		err = setUserPassword(tx, username, password)
+	// Update PasswordHash IFF it's using an outdated hashing method (e.g. MD5)
+	// or parameters (e.g. a lower bcrypt cost)
+	if outdated {
+		err = upgradePasswordHash(tx, username, password)
 		if err != nil {
 			return err // already wrapped
 		}
 		log.Infof("upgraded password hash for: %s", username)
 	}
+	if passwordExpired {
+		return ErrorPasswordExpired.Here().
+			WithMessagef("password for '%s' has expired", username)
+	}
 	return nil
 }
+
+// UpdateLastLogin sets the user's LastLogin to now, and records the login and
+// the method used (e.g. password or passkey). Only call this once the user has
+// provided ALL of their required factors.
+func UpdateLastLogin(tx *sqlx.Tx, actor audit.Actor, username string, method string) error {
+	_, err := tx.Exec(`UPDATE Users
+		 			  SET LastLogin=?
+		 			  WHERE Username=?`, time.Now(), username)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return audit.Record(tx, actor.As(username), "auth.login", username, "", method)
+}
+
+// recordLoginFailure records a failed login and why, and returns loginErr (or
+// the error recording it).
+func recordLoginFailure(tx *sqlx.Tx, actor audit.Actor, username string,
+	reason string, loginErr error) error {
+	err := audit.Record(tx, actor.As(username), "auth.login_failed", username, "",
+		reason)
+	if err != nil {
+		return err
+	}
+	return loginErr
+}
@@ -3,46 +3,68 @@ package user
 import (
 	"fmt"
 	"strings"
+	"unicode"
 
 	"github.com/ansel1/merry"
 	"github.com/badoux/checkmail"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zauth/pkg/db"
 )
 
 // NewUser creates a new user (if details are valid), and send them an email so
 // they can set their initial password.
-func NewUser(tx *sqlx.Tx, firstName string, lastName string, email string) (user User, err error) {
-	// 0. Create temp user object to hold our values pre-Db-insert
-	firstName = strings.Trim(firstName, " ")
-	lastName = strings.Trim(lastName, " ")
-	email = strings.Trim(email, " ")
-
+//
+// If username is empty, one is made from their name (see newUsername).
+// Otherwise, it must be valid (see reValidName) and not already taken.
+func NewUser(tx *sqlx.Tx, actor audit.Actor, username string, firstName string,
+	lastName string, email string) (user User, err error) {
 	// 1. Validate inputs
-	if len(firstName) < 1 || len(lastName) < 1 {
-		err = merry.New("FirstName or LastName < 1 character").
-			WithUserMessage("First and last name are required.")
+	firstName, lastName, err = cleanNames(firstName, lastName)
+	if err != nil {
 		return
 	}
-	err = checkmail.ValidateFormat(email)
+	email, err = cleanEmail(email)
 	if err != nil {
-		err = merry.Wrap(err).WithUserMessage("Email must be valid.")
 		return
 	}
 
-	// 2. Create username and home directory (based on first and last name)
-	firstName = reBadChars.ReplaceAllString(firstName, "")
-	lastName = reBadChars.ReplaceAllString(lastName, "")
-	username := strings.ToLower(fmt.Sprintf("%s.%s", firstName, lastName))
+	// 2. Check or create their username (based on first and last name)
+	username = strings.TrimSpace(username)
+	if username != "" {
+		err = checkCustomUsername(tx, username)
+	} else {
+		username, err = newUsername(tx, firstName, lastName)
+	}
+	if err != nil {
+		return
+	}
 
-	// 3. Insert the user into the DB
-	_, err = tx.Exec(`INSERT INTO Users (Username, FirstName, LastName, Email)
-					  VALUES (?,?,?,?)`, username, firstName, lastName, email)
+	// 3. Insert the user into the DB, with an ID no deleted user had
+	id, err := nextUserID(tx)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`INSERT INTO Users (ID, Username, FirstName, LastName, Email)
+					  VALUES (?,?,?,?,?)`, id, username, firstName, lastName, email)
 	if err != nil {
-		// TODO: Handle duplicate username errors differently? - JZ 2019.08.23
+		sqlError, ok := err.(*mysql.MySQLError)
+		if ok && sqlError.Number == db.ErrDuplicateEntry {
+			err = ErrorUsernameTaken.Here()
+			return
+		}
 		err = merry.Wrap(err).WithUserMessage("Database insertion failed.")
 		return
 	}
 
+	err = audit.Record(tx, actor, "user.create", username, "",
+		fmt.Sprintf("%s %s <%s>", firstName, lastName, email))
+	if err != nil {
+		return
+	}
+
 	// 4. Get and return the user
 	user, err = GetUserWithGroups(tx, username)
 	if err != nil {
@@ -52,3 +74,47 @@ func NewUser(tx *sqlx.Tx, firstName string, lastName string, email string) (user
 
 	return
 }
+
+// cleanNames trims and validates a user's first and last name. Names are kept
+// as they were written (e.g. with accents), since only usernames are limited to
+// ASCII (see transliterate). This is used for both new users and name changes,
+// so the rules stay the same.
+func cleanNames(firstName string, lastName string) (string, string, error) {
+	firstName = strings.TrimSpace(firstName)
+	lastName = strings.TrimSpace(lastName)
+	if len(firstName) < 1 || len(lastName) < 1 {
+		return "", "", merry.New("FirstName or LastName < 1 character").
+			WithUserMessage("First and last name are required.")
+	}
+	if !validName(firstName) || !validName(lastName) {
+		return "", "", merry.New("FirstName or LastName has invalid characters").
+			WithUserMessage("First and last name must contain letters or numbers, " +
+				"and cannot contain control characters.")
+	}
+	return firstName, lastName, nil
+}
+
+// validName returns true if the name has at least one letter or digit, and no
+// control characters (e.g. newlines).
+func validName(name string) bool {
+	hasAlphanumeric := false
+	for _, r := range name {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return false
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			hasAlphanumeric = true
+		}
+	}
+	return hasAlphanumeric
+}
+
+// cleanEmail trims and validates the format of an email address.
+func cleanEmail(email string) (string, error) {
+	email = strings.Trim(email, " ")
+	err := checkmail.ValidateFormat(email)
+	if err != nil {
+		return "", merry.Wrap(err).WithUserMessage("Email must be valid.")
+	}
+	return email, nil
+}
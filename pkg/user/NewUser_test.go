@@ -3,37 +3,68 @@ package user
 import (
 	"testing"
 
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
 	"github.com/joshsziegler/zauth/pkg/db"
 )
 
+var testActor = audit.Actor{Username: "test", Channel: audit.ChannelCLI}
+
+// newTestAdmin creates a user with the admin role, so there's always a role
+// manager left when tests disable or remove other users.
+func newTestAdmin(t *testing.T, tx *sqlx.Tx) User {
+	u, err := NewUser(tx, testActor, "admin", "Ada", "Admin", "admin@email.com")
+	if err != nil {
+		t.Fatalf("Creating the admin failed: \n%+v", err)
+	}
+	err = AddGroup(tx, testActor, "admin", "Administrators")
+	if err != nil {
+		t.Fatalf("Creating the admin group failed: \n%+v", err)
+	}
+	err = AddGroupToRole(tx, testActor, "admin", "admin")
+	if err != nil {
+		t.Fatalf("Granting the admin role failed: \n%+v", err)
+	}
+	err = AddUserToGroup(tx, testActor, u.Username, "admin")
+	if err != nil {
+		t.Fatalf("Adding the admin to their group failed: \n%+v", err)
+	}
+	return u
+}
+
 func TestNewUserDuplicate(t *testing.T) {
 	database := db.SetupTestingDatabase(t, db.Config{
 		Username: "joshz",
 		Password: "Manikin06!",
 		Address:  "localhost",
 		DBName:   "zauth"},
-		"../../db-schema-v2.0.sql")
+		"../../db-schema-v3.0.sql")
 
-	// NewUser(tx *sqlx.Tx, firstName string, lastName string, email string) (user User, err error)
 	tx := db.GetTxOrFailTesting(t, database)
-	_, err := NewUser(tx, "first", "last", "first.last@email.com")
+	u, err := NewUser(tx, testActor, "", "first", "last", "first.last@email.com")
 	if err != nil {
 		t.Errorf("Creating a valid user failed: \n%+v", err)
+	} else if u.Username != "first.last" {
+		t.Errorf("Expected username first.last, not %s", u.Username)
 	}
 	tx.Commit()
-	// Now create the same user again...
+	// Now create the same user again, which should get a numbered username...
 	tx = db.GetTxOrFailTesting(t, database)
-	_, err = NewUser(tx, "first", "last", "first.last@email.com")
-	if err == nil {
-		t.Errorf("Creating a duplicate user didn't return an error: \n%+v", err)
+	u, err = NewUser(tx, testActor, "", "first", "last", "first.last@email.com")
+	if err != nil {
+		t.Errorf("Creating a user with the same name failed: \n%+v", err)
+	} else if u.Username != "first.last2" {
+		t.Errorf("Expected username first.last2, not %s", u.Username)
 	}
 	tx.Commit()
 
-	// Create a non-duplicate, just to be sure
+	// ...but a duplicate custom username is an error
 	tx = db.GetTxOrFailTesting(t, database)
-	_, err = NewUser(tx, "John", "Doe", "doe@email.com")
-	if err != nil {
-		t.Errorf("Creating a valid user failed: \n%+v", err)
+	_, err = NewUser(tx, testActor, "first.last", "John", "Doe", "doe@email.com")
+	if !merry.Is(err, ErrorUsernameTaken) {
+		t.Errorf("Creating a duplicate username didn't return ErrorUsernameTaken: \n%+v", err)
 	}
 	tx.Commit()
 }
@@ -44,11 +75,11 @@ func TestDuplicateEmail(t *testing.T) {
 		Password: "Manikin06!",
 		Address:  "localhost",
 		DBName:   "zauth"},
-		"../../db-schema-v2.0.sql")
+		"../../db-schema-v3.0.sql")
 
 	// Create a non-duplicate, just to be sure
 	tx := db.GetTxOrFailTesting(t, database)
-	_, err := NewUser(tx, "John", "Doe", "doe@email.com")
+	_, err := NewUser(tx, testActor, "", "John", "Doe", "doe@email.com")
 	if err != nil {
 		t.Errorf("Creating a valid user failed: \n%+v", err)
 	}
@@ -56,7 +87,7 @@ func TestDuplicateEmail(t *testing.T) {
 
 	// Emails do NOT need to be unique, only usernames...
 	tx = db.GetTxOrFailTesting(t, database)
-	_, err = NewUser(tx, "Jane", "Doe", "doe@email.com")
+	_, err = NewUser(tx, testActor, "", "Jane", "Doe", "doe@email.com")
 	if err != nil {
 		t.Errorf("Creating a valid user failed: \n%+v", err)
 	}
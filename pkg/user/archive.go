@@ -0,0 +1,130 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+var (
+	// ErrorUserArchived indicates the user is archived, and must be restored
+	// first (see RestoreUser).
+	ErrorUserArchived = merry.New("user is archived").
+				WithUserMessage("This user is archived. Restore them first.")
+	// ErrorUserNotArchived indicates the user can't be restored, because they
+	// aren't archived.
+	ErrorUserNotArchived = merry.New("user is not archived").
+				WithUserMessage("This user is not archived.")
+)
+
+// ArchiveUser disables the user and revokes their sessions, and hides them
+// from LDAP and the user list. Unlike DeleteUser, their record and group
+// memberships are kept, so they can be restored. Returns ErrorNoRoleManagers if
+// nobody would be left with PermRolesManage.
+func ArchiveUser(tx *sqlx.Tx, actor audit.Actor, username string) error {
+	res, err := tx.Exec(`UPDATE Users
+						 SET ArchivedAt=?, Disabled=1
+						 WHERE Username=? AND ArchivedAt=?`,
+		time.Now(), username, MySQLZeroDate)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if n == 0 {
+		return ErrorUserArchived.Here()
+	}
+	_, err = DeleteUserSessions(tx, actor, username)
+	if err != nil {
+		return err
+	}
+	err = checkRoleManagersRemain(tx)
+	if err != nil {
+		return err
+	}
+	log.Infof("archived user %s", username)
+	return audit.Record(tx, actor, "user.archive", username, "", "")
+}
+
+// RestoreUser un-archives the user. They stay disabled until enabled (see
+// UserEnable).
+func RestoreUser(tx *sqlx.Tx, actor audit.Actor, username string) error {
+	res, err := tx.Exec(`UPDATE Users
+						 SET ArchivedAt=?
+						 WHERE Username=? AND ArchivedAt<>?`,
+		MySQLZeroDate, username, MySQLZeroDate)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if n == 0 {
+		return ErrorUserNotArchived.Here()
+	}
+	log.Infof("restored user %s", username)
+	return audit.Record(tx, actor, "user.restore", username, "", "")
+}
+
+// DeleteUser permanently deletes the user, along with their group memberships,
+// sessions, passkeys, and other keys (via the foreign keys' ON DELETE
+// CASCADE). Their ID (and so their Unix IDs) and username are reserved, so
+// neither is ever given to someone else, and the audit log keeps a tombstone
+// of who they were. Returns ErrorNoRoleManagers if nobody would be left with
+// PermRolesManage.
+func DeleteUser(tx *sqlx.Tx, actor audit.Actor, username string) error {
+	u, err := GetUserWithGroups(tx, username)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO ReservedUsers (ID, Username, Deleted)
+					  VALUES (?,?,?)`, u.ID, u.Username, time.Now())
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`DELETE FROM User2Group WHERE UserID=?`, u.ID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`DELETE FROM Users WHERE ID=?`, u.ID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	err = checkRoleManagersRemain(tx)
+	if err != nil {
+		return err
+	}
+	log.Infof("deleted user %s (uidNumber %d)", username, u.UnixUserID())
+	return audit.Record(tx, actor, "user.delete", username,
+		fmt.Sprintf("uidNumber=%d %s <%s> groups=%s", u.UnixUserID(), u.CommonName(),
+			u.Email, strings.Join(u.Groups, ",")), "")
+}
+
+// nextUserID returns the database ID for a new user, past every current and
+// deleted user's. Deleted users' IDs are reserved (see DeleteUser), since our
+// Unix IDs are based on them, and MySQL's AUTO_INCREMENT can reuse the highest
+// deleted ID (e.g. older versions reset it when restarted).
+//
+// The last user is locked until the transaction ends, so concurrent new users
+// can't be given the same ID.
+func nextUserID(tx *sqlx.Tx) (id int64, err error) {
+	var maxUserID, maxReservedID int64
+	err = tx.Get(&maxUserID, `SELECT COALESCE(MAX(ID), 0) FROM Users FOR UPDATE`)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	err = tx.Get(&maxReservedID, `SELECT COALESCE(MAX(ID), 0) FROM ReservedUsers`)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	return max(maxUserID, maxReservedID) + 1, nil
+}
@@ -0,0 +1,141 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+
+	"github.com/joshsziegler/zauth/pkg/db"
+)
+
+func TestArchiveAndRestoreUser(t *testing.T) {
+	database := db.SetupTestingDatabase(t, db.Config{
+		Username: "joshz",
+		Password: "Manikin06!",
+		Address:  "localhost",
+		DBName:   "zauth"},
+		"../../db-schema-v3.0.sql")
+
+	tx := db.GetTxOrFailTesting(t, database)
+	defer tx.Rollback()
+	admin := newTestAdmin(t, tx)
+	u, err := NewUser(tx, testActor, "", "Jane", "Doe", "doe@email.com")
+	if err != nil {
+		t.Fatalf("Creating a valid user failed: \n%+v", err)
+	}
+	err = RestoreUser(tx, testActor, u.Username)
+	if !merry.Is(err, ErrorUserNotArchived) {
+		t.Errorf("Restoring an unarchived user didn't return ErrorUserNotArchived: \n%+v", err)
+	}
+
+	// Archiving disables them and can't be done twice...
+	err = ArchiveUser(tx, testActor, u.Username)
+	if err != nil {
+		t.Fatalf("Archiving the user failed: \n%+v", err)
+	}
+	archived, err := GetUserWithGroups(tx, u.Username)
+	if err != nil {
+		t.Fatalf("Getting the archived user failed: \n%+v", err)
+	}
+	if !archived.Disabled || archived.ArchivedAt.IsZero() {
+		t.Errorf("Archived user wasn't disabled and archived: %+v", archived)
+	}
+	err = ArchiveUser(tx, testActor, u.Username)
+	if !merry.Is(err, ErrorUserArchived) {
+		t.Errorf("Archiving twice didn't return ErrorUserArchived: \n%+v", err)
+	}
+	// ...and they can't be enabled or change groups until restored
+	err = UserEnable(tx, testActor, u.Username)
+	if !merry.Is(err, ErrorUserArchived) {
+		t.Errorf("Enabling an archived user didn't return ErrorUserArchived: \n%+v", err)
+	}
+	err = AddUserToGroup(tx, testActor, u.Username, "admin")
+	if !merry.Is(err, ErrorUserArchived) {
+		t.Errorf("Adding an archived user to a group didn't return ErrorUserArchived: \n%+v", err)
+	}
+	err = RestoreUser(tx, testActor, u.Username)
+	if err != nil {
+		t.Fatalf("Restoring the user failed: \n%+v", err)
+	}
+	restored, err := GetUserWithGroups(tx, u.Username)
+	if err != nil {
+		t.Fatalf("Getting the restored user failed: \n%+v", err)
+	}
+	if !restored.Disabled || !restored.ArchivedAt.IsZero() {
+		t.Errorf("Restored user wasn't disabled and unarchived: %+v", restored)
+	}
+	err = UserEnable(tx, testActor, u.Username)
+	if err != nil {
+		t.Errorf("Enabling the restored user failed: \n%+v", err)
+	}
+
+	// The last role manager can't be archived
+	err = ArchiveUser(tx, testActor, admin.Username)
+	if !merry.Is(err, ErrorNoRoleManagers) {
+		t.Errorf("Archiving the last role manager didn't return ErrorNoRoleManagers: \n%+v", err)
+	}
+}
+
+func TestDeleteUserReservesIDAndUsername(t *testing.T) {
+	database := db.SetupTestingDatabase(t, db.Config{
+		Username: "joshz",
+		Password: "Manikin06!",
+		Address:  "localhost",
+		DBName:   "zauth"},
+		"../../db-schema-v3.0.sql")
+
+	tx := db.GetTxOrFailTesting(t, database)
+	defer tx.Rollback()
+	admin := newTestAdmin(t, tx)
+	u, err := NewUser(tx, testActor, "", "Jane", "Doe", "doe@email.com")
+	if err != nil {
+		t.Fatalf("Creating a valid user failed: \n%+v", err)
+	}
+	err = DeleteUser(tx, testActor, u.Username)
+	if err != nil {
+		t.Fatalf("Deleting the user failed: \n%+v", err)
+	}
+	_, err = GetUserWithGroups(tx, u.Username)
+	if err == nil {
+		t.Errorf("Deleted user still exists")
+	}
+
+	// Neither their username nor their ID is given to anyone else...
+	_, err = NewUser(tx, testActor, u.Username, "John", "Doe", "john@email.com")
+	if !merry.Is(err, ErrorUsernameTaken) {
+		t.Errorf("Reusing a deleted username didn't return ErrorUsernameTaken: \n%+v", err)
+	}
+	again, err := NewUser(tx, testActor, "", "Jane", "Doe", "doe@email.com")
+	if err != nil {
+		t.Fatalf("Creating a valid user failed: \n%+v", err)
+	}
+	if again.Username == u.Username {
+		t.Errorf("Deleted username %s was given to a new user", u.Username)
+	}
+	if again.ID <= u.ID {
+		t.Errorf("Expected an ID after the deleted user's %d, not %d", u.ID, again.ID)
+	}
+
+	// ...even if it's higher than any current user's (e.g. MySQL reset
+	// AUTO_INCREMENT after the last user was deleted)
+	reservedID := again.ID + 100
+	_, err = tx.Exec(`INSERT INTO ReservedUsers (ID, Username, Deleted)
+					  VALUES (?,?,?)`, reservedID, "reserved", time.Now())
+	if err != nil {
+		t.Fatalf("Reserving an ID failed: \n%+v", err)
+	}
+	next, err := NewUser(tx, testActor, "", "Jim", "Doe", "jim@email.com")
+	if err != nil {
+		t.Fatalf("Creating a valid user failed: \n%+v", err)
+	}
+	if next.ID != reservedID+1 {
+		t.Errorf("Expected ID %d after the reserved ID, not %d", reservedID+1, next.ID)
+	}
+
+	// The last role manager can't be deleted
+	err = DeleteUser(tx, testActor, admin.Username)
+	if !merry.Is(err, ErrorNoRoleManagers) {
+		t.Errorf("Deleting the last role manager didn't return ErrorNoRoleManagers: \n%+v", err)
+	}
+}
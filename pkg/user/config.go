@@ -0,0 +1,101 @@
+package user
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+// Config is used to pass the account options.
+type Config struct {
+	// ExpiryWarningDays is how many days before an account expires to email
+	// a warning to the user and admins. Zero disables the warning.
+	ExpiryWarningDays int
+	// PasswordExpiryWarningDays is how many days before a password expires to
+	// remind the user by email. Zero disables the reminder. When passwords
+	// expire is set by their password policy (see password.Policy).
+	PasswordExpiryWarningDays int
+	// UsernamePattern is how new usernames are made from their first and last
+	// name, such as "first.last" (john.smith) or "flast" (jsmith). Zero uses
+	// DefaultUsernamePattern. See parseUsernamePattern for the details.
+	UsernamePattern string
+	// SiteName is what our emails call this site, such as "Example Accounts".
+	// Zero uses "zauth".
+	SiteName string
+	// SiteURL is the public address of our web UI (e.g.
+	// https://accounts.example.com), which links in emails use. Zero uses
+	// http://localhost:8080, which only works for development.
+	SiteURL string
+	// EmailFromName and EmailFromAddress are who our emails are from. Zero
+	// uses SiteName, and no-reply at SiteURL's host.
+	EmailFromName    string
+	EmailFromAddress string
+	// NewAccountLinkHours is how long the link in the welcome email works.
+	// Zero uses 8.
+	NewAccountLinkHours int
+	// ForgotPasswordLinkHours is how long a forgotten password link works.
+	// This is shorter than for new accounts, since the user is actively
+	// waiting. Zero uses 1.
+	ForgotPasswordLinkHours int
+	// EmailVerifyLinkHours is how long an email verification link works. Zero
+	// uses 24.
+	EmailVerifyLinkHours int
+	// EmailTemplates is a directory of email templates which replace our
+	// defaults (see EmailNames). Any that aren't there use our default.
+	EmailTemplates string
+}
+
+// We use a global config, because it should be read-only after initial loading
+var config Config
+
+// Init sets the account options, and loads our email templates. It should be
+// called before RunExpiryJob, or logging anyone in.
+func Init(c Config) error {
+	if c.UsernamePattern == "" {
+		c.UsernamePattern = DefaultUsernamePattern
+	}
+	pattern, err := parseUsernamePattern(c.UsernamePattern)
+	if err != nil {
+		return merry.Prepend(err, "accounts config")
+	}
+	if c.SiteName == "" {
+		c.SiteName = "zauth"
+	}
+	if c.SiteURL == "" {
+		c.SiteURL = "http://localhost:8080"
+	}
+	c.SiteURL = strings.TrimSuffix(c.SiteURL, "/")
+	siteURL, err := url.Parse(c.SiteURL)
+	if err != nil || (siteURL.Scheme != "http" && siteURL.Scheme != "https") ||
+		siteURL.Host == "" {
+		return merry.Errorf("accounts config: SiteURL '%s' must be an http or "+
+			"https URL", c.SiteURL)
+	}
+	if c.EmailFromName == "" {
+		c.EmailFromName = c.SiteName
+	}
+	if c.EmailFromAddress == "" {
+		c.EmailFromAddress = "no-reply@" + siteURL.Hostname()
+	}
+	if c.NewAccountLinkHours <= 0 {
+		c.NewAccountLinkHours = 8
+	}
+	if c.ForgotPasswordLinkHours <= 0 {
+		c.ForgotPasswordLinkHours = 1
+	}
+	if c.EmailVerifyLinkHours <= 0 {
+		c.EmailVerifyLinkHours = 24
+	}
+	// The templates are checked using the config's values
+	previous := config
+	config = c
+	templates, err := loadEmailTemplates(c.EmailTemplates)
+	if err != nil {
+		config = previous
+		return merry.Prepend(err, "accounts config: email templates")
+	}
+	emailTemplates = templates
+	usernamePattern = pattern
+	return nil
+}
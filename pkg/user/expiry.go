@@ -0,0 +1,252 @@
+package user
+
+import (
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+const (
+	// expiryJobInterval is how often RunExpiryJob checks for expired accounts.
+	expiryJobInterval = time.Hour
+	// expiryDateFormat is how expiration dates are shown to users.
+	expiryDateFormat = "January 2, 2006"
+)
+
+// systemActor is who makes the changes done by background jobs.
+var systemActor = audit.Actor{Username: "zauth", Channel: audit.ChannelSystem}
+
+// formatExpiresAt returns the expiration date for the audit log, or 'never'.
+func formatExpiresAt(expiresAt time.Time) string {
+	if expiresAt.IsZero() {
+		return "never"
+	}
+	return expiresAt.Format(time.RFC3339)
+}
+
+// SetExpiresAt sets when the user's account expires. Zero means it never
+// expires. Changing the date means the user will be warned again before the
+// new date.
+func SetExpiresAt(tx *sqlx.Tx, actor audit.Actor, username string, expiresAt time.Time) error {
+	var before time.Time
+	err := tx.Get(&before, `SELECT ExpiresAt FROM Users WHERE Username=?`, username)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	var value interface{} = expiresAt
+	if expiresAt.IsZero() {
+		value = MySQLZeroDate // Go's zero time isn't a valid MySQL date
+	}
+	_, err = tx.Exec(`UPDATE Users
+					  SET ExpiresAt=?, ExpiryWarned=0
+					  WHERE Username=?`, value, username)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return audit.Record(tx, actor, "user.expires", username,
+		formatExpiresAt(before), formatExpiresAt(expiresAt))
+}
+
+// ExpireAccounts disables every enabled user whose account has expired, and
+// returns their usernames.
+func ExpireAccounts(tx *sqlx.Tx, actor audit.Actor) (usernames []string, err error) {
+	err = tx.Select(&usernames, `SELECT Username
+								 FROM Users
+								 WHERE Disabled=0 AND ExpiresAt<>? AND ExpiresAt<=?
+								 ORDER BY Username ASC`, MySQLZeroDate, time.Now())
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	for _, username := range usernames {
+		// Skip checkRoleManagersRemain, since expired users can't login anyway
+		err = userSetEnable(tx, actor, false, username)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("disabled %s because their account expired", username)
+	}
+	return usernames, nil
+}
+
+// getExpiringUsers returns the enabled users whose accounts expire within the
+// warning period and who haven't been warned, and marks them as warned.
+func getExpiringUsers(tx *sqlx.Tx) (users []User, err error) {
+	if config.ExpiryWarningDays <= 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	err = tx.Select(&users, `SELECT *
+							 FROM Users
+							 WHERE Disabled=0 AND ExpiryWarned=0 AND ExpiresAt<>?
+								 AND ExpiresAt>? AND ExpiresAt<=?
+							 ORDER BY ExpiresAt ASC, Username ASC`,
+		MySQLZeroDate, now, now.AddDate(0, 0, config.ExpiryWarningDays))
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	for _, u := range users {
+		_, err = tx.Exec(`UPDATE Users SET ExpiryWarned=1 WHERE ID=?`, u.ID)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+	return users, nil
+}
+
+// getUsersWithPermission returns the enabled users who have the permission.
+func getUsersWithPermission(tx *sqlx.Tx, permission Permission) (users []User, err error) {
+	err = tx.Select(&users, `SELECT DISTINCT Users.*
+							 FROM Users
+							 INNER JOIN User2Group
+								 ON User2Group.UserID=Users.ID
+							 INNER JOIN Role2Group
+								 ON Role2Group.GroupID=User2Group.GroupID
+							 INNER JOIN Role2Permission
+								 ON Role2Permission.RoleID=Role2Group.RoleID
+							 WHERE Role2Permission.Permission=? AND Users.Disabled=0
+							 ORDER BY Users.Username ASC`, permission)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return users, nil
+}
+
+// getPasswordExpiringUsers returns the enabled users whose passwords expire
+// within the reminder period and who haven't been reminded, and marks them as
+// reminded. Their Groups are loaded, since when passwords expire depends on
+// their password policy.
+func getPasswordExpiringUsers(tx *sqlx.Tx) (expiring []User, err error) {
+	if config.PasswordExpiryWarningDays <= 0 {
+		return nil, nil
+	}
+	var users []User
+	err = tx.Select(&users, `SELECT *
+							 FROM Users
+							 WHERE Disabled=0 AND PasswordExpiryWarned=0 AND PasswordSet<>?
+							 ORDER BY PasswordSet ASC, Username ASC`, MySQLZeroDate)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	now := time.Now()
+	remindAfter := now.AddDate(0, 0, config.PasswordExpiryWarningDays)
+	for _, u := range users {
+		u.Groups, err = getGroupNames(tx, u.ID)
+		if err != nil {
+			return nil, err
+		}
+		expiresAt := u.PasswordExpiresAt()
+		if expiresAt.IsZero() || !expiresAt.After(now) || expiresAt.After(remindAfter) {
+			continue
+		}
+		_, err = tx.Exec(`UPDATE Users SET PasswordExpiryWarned=1 WHERE ID=?`, u.ID)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		expiring = append(expiring, u)
+	}
+	return expiring, nil
+}
+
+// checkExpiry disables expired accounts, and queues warnings about accounts and
+// passwords that will soon expire. The warnings are queued in the same
+// transaction that marks users as warned, so they're sent IFF it commits.
+func checkExpiry(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer tx.Rollback()
+	_, err = ExpireAccounts(tx, systemActor)
+	if err != nil {
+		return err
+	}
+	expiring, err := getExpiringUsers(tx)
+	if err != nil {
+		return err
+	}
+	for _, u := range expiring {
+		err = u.SendExpiryWarningEmail(tx)
+		if err != nil {
+			return merry.Prependf(err, "warning %s", u.Username)
+		}
+	}
+	if len(expiring) > 0 {
+		// Admins are those who can disable users
+		admins, err := getUsersWithPermission(tx, PermUsersDisable)
+		if err != nil {
+			return err
+		}
+		for _, admin := range admins {
+			err = admin.SendExpiringAccountsEmail(tx, expiring)
+			if err != nil {
+				return merry.Prependf(err, "warning admin %s", admin.Username)
+			}
+		}
+	}
+	passwordExpiring, err := getPasswordExpiringUsers(tx)
+	if err != nil {
+		return err
+	}
+	for _, u := range passwordExpiring {
+		err = u.SendPasswordExpiryEmail(tx)
+		if err != nil {
+			return merry.Prependf(err, "reminding %s about their password",
+				u.Username)
+		}
+	}
+	return merry.Wrap(tx.Commit())
+}
+
+// RunExpiryJob disables expired accounts, and emails warnings about accounts and
+// passwords that will soon expire. It runs once at start and then every hour
+// (blocking).
+func RunExpiryJob(db *sqlx.DB) {
+	for {
+		err := checkExpiry(db)
+		if err != nil {
+			log.Errorf("expiry: %s", err)
+		}
+		time.Sleep(expiryJobInterval)
+	}
+}
+
+// SendExpiryWarningEmail tells the user when their account will expire, once
+// the transaction commits.
+func (u *User) SendExpiryWarningEmail(tx *sqlx.Tx) error {
+	err := queueEmail(tx, EmailAccountExpiry, u.Email, &EmailData{User: *u,
+		ExpiresAt: u.ExpiresAt})
+	if err != nil {
+		return err
+	}
+	log.Infof("queued expiry warning to %s", u.Username)
+	return nil
+}
+
+// SendExpiringAccountsEmail tells an admin which accounts will soon expire,
+// once the transaction commits.
+func (u *User) SendExpiringAccountsEmail(tx *sqlx.Tx, expiring []User) error {
+	err := queueEmail(tx, EmailExpiringAccounts, u.Email, &EmailData{User: *u,
+		Users: expiring})
+	if err != nil {
+		return err
+	}
+	log.Infof("queued expiring accounts list to %s", u.Username)
+	return nil
+}
+
+// SendPasswordExpiryEmail reminds the user to change their password before it
+// expires, once the transaction commits.
+func (u *User) SendPasswordExpiryEmail(tx *sqlx.Tx) error {
+	err := queueEmail(tx, EmailPasswordExpiry, u.Email, &EmailData{User: *u,
+		ExpiresAt: u.PasswordExpiresAt(),
+		Link:      config.SiteURL + "/users/" + u.Username + "/password"})
+	if err != nil {
+		return err
+	}
+	log.Infof("queued password expiry reminder to %s", u.Username)
+	return nil
+}
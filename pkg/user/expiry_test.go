@@ -0,0 +1,50 @@
+package user
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsExpired(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if isExpired(time.Time{}, now) {
+		t.Error("accounts without an expiration date should never expire")
+	}
+	if isExpired(now.Add(time.Second), now) {
+		t.Error("account expired early")
+	}
+	if !isExpired(now, now) || !isExpired(now.Add(-time.Hour), now) {
+		t.Error("account should have expired")
+	}
+}
+
+func TestPasswordExpiresAt(t *testing.T) {
+	set := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !passwordExpiresAt(set, 0).IsZero() {
+		t.Error("passwords should not expire without a maximum age")
+	}
+	if !passwordExpiresAt(time.Time{}, 90).IsZero() {
+		t.Error("passwords with an unknown age should not expire")
+	}
+	want := time.Date(2020, 3, 31, 0, 0, 0, 0, time.UTC)
+	if got := passwordExpiresAt(set, 90); !got.Equal(want) {
+		t.Errorf("password expires at %s, want %s", got, want)
+	}
+}
+
+func TestExpiryWarningEmail(t *testing.T) {
+	if err := Init(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	u := User{Username: "jane.doe", FirstName: "Jane", LastName: "Doe",
+		Email: "jane@example.com", ExpiresAt: time.Date(2020, 3, 4, 12, 0, 0, 0, time.Local)}
+	m, err := renderEmail(EmailAccountExpiry, &EmailData{User: u, ExpiresAt: u.ExpiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ToEmail != "jane@example.com" || m.ToName != "Jane Doe" ||
+		!strings.Contains(m.Plain, "March 4, 2020") || !strings.Contains(m.HTML, "jane.doe") {
+		t.Errorf("unexpected email: %+v", m)
+	}
+}
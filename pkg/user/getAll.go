@@ -7,14 +7,16 @@ import (
 )
 
 // GetAllUsersAndGroups Users and Groups, WITH membership info populated.
+// Archived users are left out (including from their groups' members) unless
+// includeArchived is true.
 //
 // This exists because it *should* be more efficient for populating group
 // membership info IF AND ONLY IF you need all or most of the users and groups.
-func GetAllUsersAndGroups(tx *sqlx.Tx) (users map[int64]*(User),
+func GetAllUsersAndGroups(tx *sqlx.Tx, includeArchived bool) (users map[int64]*(User),
 	groups map[int64]*(Group), err error) {
 
 	// Get all Users (does NOT pull group membership)
-	users, err = GetUsersMapWithoutGroups(tx)
+	users, err = GetUsersMapWithoutGroups(tx, includeArchived)
 	if err != nil {
 		err = merry.Append(err, "error getting users")
 		return
@@ -44,6 +46,9 @@ func GetAllUsersAndGroups(tx *sqlx.Tx) (users map[int64]*(User),
 			err = merry.Append(err, "error scanning into User2Group")
 			return
 		}
+		if users[u2g.UserID] == nil {
+			continue // Archived
+		}
 		// Update the User record
 		users[u2g.UserID].Groups = append(users[u2g.UserID].Groups,
 			groups[u2g.GroupID].Name)
@@ -1,13 +1,17 @@
 package user
 
 import (
-	"database/sql"
+	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/ansel1/merry"
 	"github.com/go-sql-driver/mysql"
 	_ "github.com/go-sql-driver/mysql" // Blank import required for SQL drivers
 	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
 )
 
 // TODO: Restrict Group names to alphanumeric, with hyphens (no whitespace)
@@ -29,6 +33,9 @@ var (
 	//    <hyphen-minus> characters, respectively. See also Pathname.
 	//
 	reValidName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+	// ErrorGroupReferenced indicates a group can't be deleted because something
+	// still depends on it.
+	ErrorGroupReferenced = merry.New("group is still referenced")
 )
 
 // Group represents and LDAP group's attributes and members
@@ -36,7 +43,9 @@ type Group struct {
 	ID          int64  `db:"ID"`
 	Name        string `db:"Name"`
 	Description string `db:"Description"`
-	Members     []string
+	// Require2FA forces members of this group to enroll in two-factor auth.
+	Require2FA bool `db:"Require2FA"`
+	Members    []string
 }
 
 // UnixGroupID is always their database ID + 100.
@@ -48,7 +57,7 @@ func (g Group) UnixGroupID() int64 {
 }
 
 func GetGroupsSliceWithoutUsers(tx *sqlx.Tx) (groups []*Group, err error) {
-	err = tx.Select(&groups, "SELECT ID, Name, Description FROM UserGroups ORDER BY Name ASC")
+	err = tx.Select(&groups, "SELECT ID, Name, Description, Require2FA FROM UserGroups ORDER BY Name ASC")
 	if err != nil {
 		err = merry.WithMessage(err, "error retrieving groups list from database")
 		return
@@ -83,7 +92,7 @@ func GetGroupsMapWithoutUsers(tx *sqlx.Tx) (groups map[int64]*(Group), err error
 // Add inserts a new group into the database.
 //
 // TODO: Add name validity checks? - JZ
-func AddGroup(tx *sqlx.Tx, name string, description string) error {
+func AddGroup(tx *sqlx.Tx, actor audit.Actor, name string, description string) error {
 	_, err := tx.Exec("INSERT INTO UserGroups (Name, Description) VALUES (?,?);",
 		name, description)
 	if err != nil {
@@ -95,13 +104,116 @@ func AddGroup(tx *sqlx.Tx, name string, description string) error {
 		}
 		return merry.Wrap(err)
 	}
-	return nil
+	return audit.Record(tx, actor, "group.create", name, "", description)
+}
+
+// GetGroupWithMembers returns a single Group, including the usernames of its
+// members (in alphabetical ascending order).
+func GetGroupWithMembers(tx *sqlx.Tx, name string) (group Group, err error) {
+	err = tx.Get(&group, `SELECT ID, Name, Description, Require2FA
+						  FROM UserGroups
+						  WHERE Name=?`, name)
+	if err != nil {
+		return Group{}, merry.Wrap(err)
+	}
+	err = tx.Select(&group.Members, `SELECT Users.Username
+									 FROM Users
+									 INNER JOIN User2Group
+										 ON Users.ID=User2Group.UserID
+									 WHERE User2Group.GroupID=?
+									 ORDER BY Users.Username ASC;`, group.ID)
+	if err != nil {
+		return Group{}, merry.Wrap(err)
+	}
+	return group, nil
 }
 
-func DeleteGroup(tx *sql.Tx, name string) error {
-	_, err := tx.Exec("DELETE FROM UserGroups WHERE Name IS ?;", name)
+// SetGroupDescription changes the description of the named group.
+func SetGroupDescription(tx *sqlx.Tx, actor audit.Actor, name string, description string) error {
+	description = strings.TrimSpace(description)
+	var before string
+	err := tx.Get(&before, `SELECT Description FROM UserGroups WHERE Name=?`, name)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`UPDATE UserGroups
+					  SET Description=?
+					  WHERE Name=?`, description, name)
 	if err != nil {
 		return merry.Wrap(err)
 	}
-	return nil
+	return audit.Record(tx, actor, "group.description", name, before, description)
+}
+
+// GetGroupReferences returns the reasons the named group can't be deleted, or
+// an empty slice if nothing refers to it.
+func GetGroupReferences(tx *sqlx.Tx, name string) (references []string, err error) {
+	roles, err := getGroupRoles(tx, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		references = append(references,
+			fmt.Sprintf("it grants the '%s' role to its members", role))
+	}
+	return references, nil
+}
+
+// DeleteGroup deletes the named group, and removes all of its members from it.
+// It refuses to delete groups that are still referenced (see
+// GetGroupReferences).
+func DeleteGroup(tx *sqlx.Tx, actor audit.Actor, name string) error {
+	references, err := GetGroupReferences(tx, name)
+	if err != nil {
+		return err
+	}
+	if len(references) > 0 {
+		return ErrorGroupReferenced.Here().
+			WithMessagef("group '%s' is still referenced: %s", name,
+				strings.Join(references, "; ")).
+			WithUserMessagef("Group %s cannot be deleted because %s.", name,
+				strings.Join(references, ", and "))
+	}
+	// Memberships are removed by the User2Group foreign key (ON DELETE CASCADE)
+	res, err := tx.Exec("DELETE FROM UserGroups WHERE Name=?;", name)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if n != 1 {
+		return merry.Errorf("group '%s' does not exist", name).
+			WithUserMessage("That group does not exist.")
+	}
+	return audit.Record(tx, actor, "group.delete", name, "", "")
+}
+
+// SetGroupRequire2FA sets whether members of the named group must use
+// two-factor authentication to login to the web UI.
+func SetGroupRequire2FA(tx *sqlx.Tx, actor audit.Actor, name string, require bool) error {
+	res, err := tx.Exec(`UPDATE UserGroups
+						 SET Require2FA=?
+						 WHERE Name=?`, require, name)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if n < 1 {
+		// MySQL reports zero rows if the value didn't change, so double check
+		var exists bool
+		err = tx.Get(&exists, `SELECT COUNT(*)=1 FROM UserGroups WHERE Name=?`, name)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		if !exists {
+			return merry.Errorf("group '%s' does not exist", name)
+		}
+	}
+	return audit.Record(tx, actor, "group.require_2fa", name, "",
+		strconv.FormatBool(require))
 }
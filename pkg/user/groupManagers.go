@@ -0,0 +1,123 @@
+package user
+
+import (
+	"database/sql"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+)
+
+const (
+	// GroupRoleOwner can add and remove members, managers, and other owners.
+	GroupRoleOwner = "owner"
+	// GroupRoleManager can add and remove members.
+	GroupRoleManager = "manager"
+)
+
+var (
+	ErrorInvalidGroupRole = merry.New("invalid group role").
+		WithUserMessage("Group roles must be either 'owner' or 'manager'.")
+)
+
+// GroupManager is a user who can manage a group without being an admin.
+type GroupManager struct {
+	Username string `db:"Username"`
+	Role     string `db:"Role"`
+}
+
+// GetGroupManagers returns the owners and managers of the named group, sorted by
+// role (owners first) and then username.
+func GetGroupManagers(tx *sqlx.Tx, group string) (managers []GroupManager, err error) {
+	err = tx.Select(&managers, `SELECT Users.Username, GroupManagers.Role
+								FROM GroupManagers
+								INNER JOIN Users
+									ON Users.ID=GroupManagers.UserID
+								INNER JOIN UserGroups
+									ON UserGroups.ID=GroupManagers.GroupID
+								WHERE UserGroups.Name=?
+								ORDER BY GroupManagers.Role ASC, Users.Username ASC;`,
+		group)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return managers, nil
+}
+
+// getManagedGroups returns the name of each group this user owns or manages,
+// mapped to their role in it.
+func getManagedGroups(tx *sqlx.Tx, userID int64) (groups map[string]string, err error) {
+	rows, err := tx.Queryx(`SELECT UserGroups.Name, GroupManagers.Role
+							FROM GroupManagers
+							INNER JOIN UserGroups
+								ON UserGroups.ID=GroupManagers.GroupID
+							WHERE GroupManagers.UserID=?;`, userID)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+	groups = make(map[string]string)
+	var name, role string
+	for rows.Next() {
+		err = rows.Scan(&name, &role)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		groups[name] = role
+	}
+	return groups, nil
+}
+
+// SetGroupManager makes the user an owner or manager of the group, replacing
+// their previous role if they had one.
+func SetGroupManager(tx *sqlx.Tx, actor audit.Actor, group string, username string,
+	role string) error {
+	if role != GroupRoleOwner && role != GroupRoleManager {
+		return ErrorInvalidGroupRole.Here()
+	}
+	var userID, groupID int64
+	err := tx.Get(&userID, `SELECT ID FROM Users WHERE Username=?;`, username)
+	if merry.Is(err, sql.ErrNoRows) {
+		return merry.Errorf("user '%s' does not exist", username).
+			WithUserMessagef("User '%s' does not exist.", username)
+	} else if err != nil {
+		return merry.Wrap(err)
+	}
+	err = tx.Get(&groupID, `SELECT ID FROM UserGroups WHERE Name=?;`, group)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`INSERT INTO GroupManagers (GroupID, UserID, Role)
+					  VALUES (?, ?, ?)
+					  ON DUPLICATE KEY UPDATE Role=VALUES(Role);`,
+		groupID, userID, role)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return audit.Record(tx, actor, "group.manager_set", group, "", username+": "+role)
+}
+
+// RemoveGroupManager removes the user's owner or manager role from the group.
+// It does not change whether they are a member of it.
+func RemoveGroupManager(tx *sqlx.Tx, actor audit.Actor, group string, username string) error {
+	res, err := tx.Exec(`DELETE GroupManagers
+					   FROM GroupManagers
+					   INNER JOIN Users
+						   ON Users.ID=GroupManagers.UserID
+					   INNER JOIN UserGroups
+						   ON UserGroups.ID=GroupManagers.GroupID
+					   WHERE UserGroups.Name=? AND Users.Username=?;`,
+		group, username)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if n == 0 {
+		return nil // Nothing changed
+	}
+	return audit.Record(tx, actor, "group.manager_remove", group, username, "")
+}
@@ -0,0 +1,409 @@
+package user
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+)
+
+const (
+	// ImportCreate means the row is valid, and a new user will be created.
+	ImportCreate = "create"
+	// ImportConflict means the row's username already exists (in the database
+	// or earlier in the same file), so it will be skipped.
+	ImportConflict = "conflict"
+	// ImportInvalid means the row has bad or missing values, so it will be
+	// skipped.
+	ImportInvalid = "invalid"
+)
+
+// ImportRow is a single user to import, as read from a CSV or LDIF file.
+type ImportRow struct {
+	// Line is where this row starts in the file (starting at 1).
+	Line      int
+	FirstName string
+	LastName  string
+	Email     string
+	Groups    []string
+}
+
+// ImportResult is what happened (or would happen during a dry-run) to a single
+// ImportRow.
+type ImportResult struct {
+	Row ImportRow
+	// Username is the username that will be (or was) created for this row.
+	Username string
+	// Status is one of ImportCreate, ImportConflict, or ImportInvalid.
+	Status string
+	// Message explains why the row is a conflict or invalid.
+	Message string
+}
+
+// splitGroups splits a list of group names separated by semicolons, commas, or
+// whitespace.
+func splitGroups(groups string) []string {
+	return strings.FieldsFunc(groups, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// ParseImportCSV reads users from CSV with the columns: first name, last name,
+// email, and groups (optional, separated by semicolons). A header row is
+// skipped if present.
+func ParseImportCSV(r io.Reader) (rows []ImportRow, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // Groups are optional
+	reader.TrimLeadingSpace = true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, merry.Wrap(err).WithUserMessagef("Invalid CSV: %s", err)
+		}
+		line, _ := reader.FieldPos(0)
+		// Skip the header row, and blank lines
+		if len(rows) == 0 && len(record) > 0 &&
+			strings.Contains(strings.ToLower(record[0]), "first") {
+			continue
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		row := ImportRow{Line: line}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch i {
+			case 0:
+				row.FirstName = value
+			case 1:
+				row.LastName = value
+			case 2:
+				row.Email = value
+			case 3:
+				row.Groups = splitGroups(value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ldifEntry is a single LDIF record's attributes (with lowercase names).
+type ldifEntry struct {
+	line       int
+	attributes map[string][]string
+}
+
+// first returns the first value of the attribute, or an empty string.
+func (e ldifEntry) first(name string) string {
+	if values := e.attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// hasObjectClass returns true if the entry has the objectClass (ignoring case).
+func (e ldifEntry) hasObjectClass(class string) bool {
+	for _, c := range e.attributes["objectclass"] {
+		if strings.EqualFold(c, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// readLDIF splits LDIF content into entries. It handles comments, folded lines,
+// and base64 values (attr:: value), but not URLs (attr:< url) or change records.
+func readLDIF(r io.Reader) (entries []ldifEntry, err error) {
+	scanner := bufio.NewScanner(r)
+	var entry *ldifEntry
+	var logical string // The current attribute line, after unfolding
+	lineNum, logicalNum := 0, 0
+
+	addAttribute := func() error {
+		if logical == "" {
+			return nil
+		}
+		defer func() { logical = "" }()
+		i := strings.Index(logical, ":")
+		if i < 1 {
+			return merry.Errorf("line %d: missing ':'", logicalNum).
+				WithUserMessagef("Invalid LDIF on line %d: expected 'attribute: value'.", logicalNum)
+		}
+		name := strings.ToLower(strings.TrimSpace(logical[:i]))
+		value := logical[i+1:]
+		if strings.HasPrefix(value, ":") {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return merry.Wrap(err).
+					WithUserMessagef("Invalid LDIF on line %d: bad base64 value.", logicalNum)
+			}
+			value = string(decoded)
+		} else if strings.HasPrefix(value, "<") {
+			return merry.Errorf("line %d: URL values are not supported", logicalNum).
+				WithUserMessagef("Invalid LDIF on line %d: URL values are not supported.", logicalNum)
+		}
+		value = strings.TrimSpace(value)
+		if name == "version" && entry == nil {
+			return nil
+		}
+		if entry == nil {
+			entry = &ldifEntry{line: logicalNum, attributes: make(map[string][]string)}
+		}
+		entry.attributes[name] = append(entry.attributes[name], value)
+		return nil
+	}
+	endEntry := func() {
+		if entry != nil {
+			entries = append(entries, *entry)
+			entry = nil
+		}
+	}
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, " "): // Continuation of the previous line
+			logical += line[1:]
+		case strings.HasPrefix(line, "#"):
+			continue
+		case strings.TrimSpace(line) == "":
+			if err = addAttribute(); err != nil {
+				return nil, err
+			}
+			endEntry()
+		default:
+			if err = addAttribute(); err != nil {
+				return nil, err
+			}
+			logical, logicalNum = line, lineNum
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if err = addAttribute(); err != nil {
+		return nil, err
+	}
+	endEntry()
+	return entries, nil
+}
+
+// rdnValue returns the value of the first RDN in a DN (e.g. "bob" from
+// "uid=bob,ou=Users,dc=example,dc=com"), or the string itself if it isn't a DN.
+func rdnValue(dn string) string {
+	first := strings.SplitN(dn, ",", 2)[0]
+	if i := strings.Index(first, "="); i >= 0 {
+		return strings.TrimSpace(first[i+1:])
+	}
+	return strings.TrimSpace(dn)
+}
+
+// ParseImportLDIF reads users from LDIF, such as an export from another
+// directory. Entries with givenName, sn, or mail are users. Their groups come
+// from their memberOf attribute, and the member or memberUid attributes of any
+// group entries in the same file.
+func ParseImportLDIF(r io.Reader) (rows []ImportRow, err error) {
+	entries, err := readLDIF(r)
+	if err != nil {
+		return nil, err
+	}
+	byUID := make(map[string]int) // uid -> index in rows
+	var groups []ldifEntry
+	for _, e := range entries {
+		if e.hasObjectClass("posixGroup") || e.hasObjectClass("groupOfNames") ||
+			e.hasObjectClass("groupOfUniqueNames") {
+			groups = append(groups, e)
+			continue
+		}
+		if e.first("givenname") == "" && e.first("sn") == "" && e.first("mail") == "" {
+			continue // Not a user (e.g. an organizational unit)
+		}
+		row := ImportRow{
+			Line:      e.line,
+			FirstName: e.first("givenname"),
+			LastName:  e.first("sn"),
+			Email:     e.first("mail"),
+		}
+		for _, group := range e.attributes["memberof"] {
+			row.Groups = append(row.Groups, rdnValue(group))
+		}
+		if uid := e.first("uid"); uid != "" {
+			byUID[strings.ToLower(uid)] = len(rows)
+		}
+		rows = append(rows, row)
+	}
+	for _, g := range groups {
+		name := g.first("cn")
+		var members []string
+		for _, attribute := range []string{"member", "memberuid", "uniquemember"} {
+			members = append(members, g.attributes[attribute]...)
+		}
+		for _, member := range members {
+			i, ok := byUID[strings.ToLower(rdnValue(member))]
+			if ok && !containsString(rows[i].Groups, name) {
+				rows[i].Groups = append(rows[i].Groups, name)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// containsString returns true if the slice contains the string.
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanImport checks each row without changing anything, and returns what
+// Import would do with it. This is the dry-run.
+//
+// canAssign is called for each group a row asks for, so callers can refuse
+// groups the importing user can't manage.
+func PlanImport(tx *sqlx.Tx, rows []ImportRow, canAssign func(group string) bool) (
+	results []ImportResult, err error) {
+	groups, err := GetGroupsSliceWithoutUsers(tx)
+	if err != nil {
+		return nil, err
+	}
+	groupExists := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		groupExists[g.Name] = true
+	}
+	seen := make(map[string]int) // username -> line it was first seen on
+	for _, row := range rows {
+		result := ImportResult{Row: row, Status: ImportCreate}
+		results = append(results, result)
+		r := &results[len(results)-1]
+
+		firstName, lastName, err := cleanNames(row.FirstName, row.LastName)
+		if err != nil {
+			r.Status, r.Message = ImportInvalid, merry.UserMessage(err)
+			continue
+		}
+		// Don't add a number to make the username unique, since the same name
+		// usually means they were already imported.
+		r.Username, err = usernameBase(firstName, lastName)
+		if err != nil {
+			r.Status = ImportInvalid
+			r.Message = "A username could not be made from their name. Create them on the New User page instead."
+			continue
+		}
+		if _, err = cleanEmail(row.Email); err != nil {
+			r.Status, r.Message = ImportInvalid, merry.UserMessage(err)
+			continue
+		}
+		var missing, refused []string
+		for _, group := range row.Groups {
+			if !groupExists[group] {
+				missing = append(missing, group)
+			} else if canAssign != nil && !canAssign(group) {
+				refused = append(refused, group)
+			}
+		}
+		if len(missing) > 0 {
+			r.Status = ImportInvalid
+			r.Message = "Groups do not exist: " + strings.Join(missing, ", ")
+			continue
+		}
+		if len(refused) > 0 {
+			r.Status = ImportInvalid
+			r.Message = "You cannot add members to: " + strings.Join(refused, ", ")
+			continue
+		}
+		if line, ok := seen[r.Username]; ok {
+			r.Status = ImportConflict
+			r.Message = fmt.Sprintf("Same username as line %d.", line)
+			continue
+		}
+		seen[r.Username] = row.Line
+		taken, err := usernameTaken(tx, r.Username)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			r.Status = ImportConflict
+			r.Message = "A user with this username already exists, or was deleted."
+		}
+	}
+	return results, nil
+}
+
+// Import creates a user (using NewUser) for every row PlanImport would create,
+// and adds them to their groups. Conflicting and invalid rows are skipped.
+//
+// Everything happens in tx, so the caller should roll back if this returns an
+// error. The new users are returned so the caller can email them after
+// committing.
+func Import(tx *sqlx.Tx, actor audit.Actor, rows []ImportRow,
+	canAssign func(group string) bool) (results []ImportResult, created []User, err error) {
+	results, err = PlanImport(tx, rows, canAssign)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range results {
+		if r.Status != ImportCreate {
+			continue
+		}
+		u, err := NewUser(tx, actor, r.Username, r.Row.FirstName, r.Row.LastName, r.Row.Email)
+		if err != nil {
+			return nil, nil, merry.Prependf(err, "line %d", r.Row.Line)
+		}
+		groups := append([]string(nil), r.Row.Groups...)
+		sort.Strings(groups)
+		for _, group := range groups {
+			err = AddUserToGroup(tx, actor, u.Username, group)
+			if err != nil {
+				return nil, nil, merry.Prependf(err, "line %d", r.Row.Line)
+			}
+		}
+		u.Groups = groups
+		created = append(created, u)
+	}
+	return results, created, nil
+}
+
+const (
+	// ImportEmailWelcome sends new users the new account email, with a link
+	// to set their password.
+	ImportEmailWelcome = "welcome"
+	// ImportEmailReset sends new users a password reset link.
+	ImportEmailReset = "reset"
+)
+
+// SendImportEmails queues the kind of email (ImportEmailWelcome or
+// ImportEmailReset) to each new user. Call this in the same transaction as the
+// import, so the emails are sent once it commits.
+func SendImportEmails(tx *sqlx.Tx, users []User, kind string) error {
+	for i := range users {
+		var err error
+		switch kind {
+		case ImportEmailWelcome:
+			err = users[i].SendPasswordResetEmail(tx)
+		case ImportEmailReset:
+			err = users[i].SendForgotPasswordEmail(tx)
+		default:
+			return nil
+		}
+		if err != nil {
+			return merry.Prependf(err, "queueing %s email to %s", kind,
+				users[i].Username)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package user
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	input := `First Name,Last Name,Email,Groups
+Jane,Doe,jane@example.com,staff;lab
+"John", "Smith", john@example.com
+
+Ann,Lee,ann@example.com,"staff, lab"
+`
+	rows, err := ParseImportCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ImportRow{
+		{Line: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com",
+			Groups: []string{"staff", "lab"}},
+		{Line: 3, FirstName: "John", LastName: "Smith", Email: "john@example.com"},
+		{Line: 5, FirstName: "Ann", LastName: "Lee", Email: "ann@example.com",
+			Groups: []string{"staff", "lab"}},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("got:\n%+v\nexpected:\n%+v", rows, expected)
+	}
+}
+
+func TestParseImportCSVInvalid(t *testing.T) {
+	_, err := ParseImportCSV(strings.NewReader("Jane,\"Doe,jane@example.com\n"))
+	if err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
+
+func TestParseImportLDIF(t *testing.T) {
+	input := `version: 1
+# A comment
+dn: ou=Users,dc=example,dc=com
+objectClass: organizationalUnit
+ou: Users
+
+dn: uid=jane.doe,ou=Users,dc=example,dc=com
+objectClass: inetOrgPerson
+uid: jane.doe
+givenName: Jane
+sn: Doe
+mail: jane@exa
+ mple.com
+memberOf: cn=staff,ou=Groups,dc=example,dc=com
+
+dn: uid=jose,ou=Users,dc=example,dc=com
+objectClass: inetOrgPerson
+uid: jose
+givenName:: Sm9zw6k=
+sn: Garcia
+mail: jose@example.com
+
+dn: cn=lab,ou=Groups,dc=example,dc=com
+objectClass: posixGroup
+cn: lab
+memberUid: jose
+member: uid=jane.doe,ou=Users,dc=example,dc=com
+`
+	rows, err := ParseImportLDIF(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ImportRow{
+		{Line: 7, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com",
+			Groups: []string{"staff", "lab"}},
+		{Line: 16, FirstName: "José", LastName: "Garcia", Email: "jose@example.com",
+			Groups: []string{"lab"}},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("got:\n%+v\nexpected:\n%+v", rows, expected)
+	}
+}
+
+func TestParseImportLDIFInvalid(t *testing.T) {
+	_, err := ParseImportLDIF(strings.NewReader("dn: uid=x\nthis is not ldif\n"))
+	if err == nil {
+		t.Error("expected an error for a line without ':'")
+	}
+}
@@ -0,0 +1,229 @@
+package user
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/gobuffalo/packr"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/email"
+)
+
+// Our notification emails. Each has a plain text template NAME.txt, which MUST
+// define a "subject" template, and an HTML template NAME.html.
+const (
+	// EmailWelcome is sent to new users, with a link to set their password.
+	EmailWelcome = "welcome"
+	// EmailForgotPassword is sent when a user asks to reset their password.
+	EmailForgotPassword = "forgot-password"
+	// EmailAccountExpiry warns a user that their account will soon expire.
+	EmailAccountExpiry = "account-expiry"
+	// EmailExpiringAccounts tells an admin which accounts will soon expire.
+	EmailExpiringAccounts = "expiring-accounts"
+	// EmailPasswordExpiry reminds a user that their password will soon expire.
+	EmailPasswordExpiry = "password-expiry"
+	// EmailVerify is sent to a user's new email address, with a link to
+	// confirm it.
+	EmailVerify = "email-verify"
+	// EmailChanged tells a user's old email address that it was changed.
+	EmailChanged = "email-changed"
+)
+
+// EmailNames lists every notification email, in the order the preview page
+// shows them.
+var EmailNames = []string{EmailWelcome, EmailForgotPassword, EmailAccountExpiry,
+	EmailExpiringAccounts, EmailPasswordExpiry, EmailVerify, EmailChanged}
+
+// defaultEmails are the templates used unless Config.EmailTemplates has a
+// replacement.
+var defaultEmails = packr.NewBox("./emails")
+
+// EmailData is given to the email templates. Not every field is set for every
+// email.
+type EmailData struct {
+	SiteName string
+	SiteURL  string
+	// User is who the email is about, and usually who it's sent to.
+	User User
+	// Link is what the user should follow, such as to set their password.
+	Link string
+	// LinkHours is how long Link works.
+	LinkHours int
+	// ExpiresAt is when the user's account or password expires.
+	ExpiresAt time.Time
+	// NewEmail is the user's new email address.
+	NewEmail string
+	// Users are the accounts that will soon expire.
+	Users []User
+}
+
+// emailTemplate is the parsed plain text and HTML templates of one email.
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// emailFuncs can be used in every email template.
+var emailFuncs = map[string]interface{}{
+	"date": func(t time.Time) string { return t.Local().Format(expiryDateFormat) },
+}
+
+// emailTemplates are set by Init.
+var emailTemplates map[string]emailTemplate
+
+// readEmailTemplate returns the file from directory if it's there, and
+// otherwise our default.
+func readEmailTemplate(directory string, filename string) (string, error) {
+	if directory != "" {
+		data, err := os.ReadFile(filepath.Join(directory, filename))
+		if err == nil {
+			return string(data), nil
+		} else if !os.IsNotExist(err) {
+			return "", merry.Wrap(err)
+		}
+	}
+	data, err := defaultEmails.FindString(filename)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	return data, nil
+}
+
+// loadEmailTemplates parses every email's templates, using those in directory
+// instead of our defaults where they exist. Each is rendered with example data
+// so mistakes (such as misspelled fields) are found now, instead of when an
+// email is sent.
+func loadEmailTemplates(directory string) (map[string]emailTemplate, error) {
+	templates := make(map[string]emailTemplate, len(EmailNames))
+	for _, name := range EmailNames {
+		text, err := readEmailTemplate(directory, name+".txt")
+		if err != nil {
+			return nil, err
+		}
+		var t emailTemplate
+		t.text, err = texttemplate.New(name + ".txt").Funcs(emailFuncs).Parse(text)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		if t.text.Lookup("subject") == nil {
+			return nil, merry.Errorf("%s.txt must define a \"subject\" template", name)
+		}
+		html, err := readEmailTemplate(directory, name+".html")
+		if err != nil {
+			return nil, err
+		}
+		t.html, err = htmltemplate.New(name + ".html").Funcs(emailFuncs).Parse(html)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		_, err = t.render(exampleEmailData(name, exampleUser()))
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+// render returns the email's subject, plain text, and HTML. The HTML template
+// escapes everything it's given, so names can't add their own markup.
+func (t emailTemplate) render(data *EmailData) (message email.Message, err error) {
+	var subject, plain, html bytes.Buffer
+	err = t.text.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return message, merry.Wrap(err)
+	}
+	err = t.text.Execute(&plain, data)
+	if err != nil {
+		return message, merry.Wrap(err)
+	}
+	err = t.html.Execute(&html, data)
+	if err != nil {
+		return message, merry.Wrap(err)
+	}
+	return email.Message{
+		FromName:  config.EmailFromName,
+		FromEmail: config.EmailFromAddress,
+		ToName:    data.User.CommonName(),
+		ToEmail:   data.User.Email,
+		// Subjects are a single line
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Plain:   strings.TrimSpace(plain.String()),
+		HTML:    strings.TrimSpace(html.String()),
+	}, nil
+}
+
+// renderEmail returns the named email to data.User, filling in our site's name
+// and URL.
+func renderEmail(name string, data *EmailData) (email.Message, error) {
+	t, ok := emailTemplates[name]
+	if !ok {
+		return email.Message{}, merry.Errorf("unknown email '%s' (was user.Init called?)", name)
+	}
+	data.SiteName = config.SiteName
+	data.SiteURL = config.SiteURL
+	return t.render(data)
+}
+
+// queueEmail renders the named email to toEmail, and adds it to the outbox.
+// It's sent once the transaction commits (see email.RunOutbox).
+func queueEmail(tx *sqlx.Tx, name string, toEmail string, data *EmailData) error {
+	message, err := renderEmail(name, data)
+	if err != nil {
+		return err
+	}
+	message.ToEmail = toEmail
+	return email.Enqueue(tx, message)
+}
+
+// exampleUser is who emails are about when checking templates.
+func exampleUser() User {
+	return User{Username: "jane.doe", FirstName: "Jane", LastName: "Doe",
+		Email: "jane.doe@example.com"}
+}
+
+// exampleEmailData returns realistic data for the named email about u, for
+// checking and previewing templates. Links use a fake token, so they don't
+// work.
+func exampleEmailData(name string, u User) *EmailData {
+	data := &EmailData{SiteName: config.SiteName, SiteURL: config.SiteURL, User: u,
+		ExpiresAt: time.Now().AddDate(0, 0, 7)}
+	switch name {
+	case EmailWelcome:
+		data.Link = config.SiteURL + "/reset-password/EXAMPLE-TOKEN"
+		data.LinkHours = config.NewAccountLinkHours
+	case EmailForgotPassword:
+		data.Link = config.SiteURL + "/reset-password/EXAMPLE-TOKEN"
+		data.LinkHours = config.ForgotPasswordLinkHours
+	case EmailPasswordExpiry:
+		data.Link = config.SiteURL + "/users/" + u.Username + "/password"
+	case EmailExpiringAccounts:
+		data.Users = []User{{Username: "john.smith", FirstName: "John",
+			LastName: "Smith", ExpiresAt: data.ExpiresAt}}
+	case EmailVerify:
+		data.Link = config.SiteURL + "/verify-email/EXAMPLE-TOKEN"
+		data.LinkHours = config.EmailVerifyLinkHours
+		data.NewEmail = "new." + u.Email
+	case EmailChanged:
+		data.NewEmail = "new." + u.Email
+	}
+	return data
+}
+
+// PreviewEmail renders the named email as if it were sent to u, using example
+// links (which don't work) and dates.
+func PreviewEmail(name string, u User) (email.Message, error) {
+	data := exampleEmailData(name, u)
+	message, err := renderEmail(name, data)
+	if name == EmailVerify {
+		message.ToEmail = data.NewEmail
+	}
+	return message, err
+}
@@ -0,0 +1,81 @@
+package user
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWelcomeEmail(t *testing.T) {
+	err := Init(Config{SiteName: "Example Accounts", SiteURL: "https://accounts.example.com/",
+		NewAccountLinkHours: 48})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := User{Username: "jane.doe", FirstName: "<b>Jane</b>", LastName: "Doe & Co",
+		Email: "jane@example.com"}
+	m, err := renderEmail(EmailWelcome, &EmailData{User: u,
+		Link:      config.SiteURL + "/reset-password/TOKEN",
+		LinkHours: config.NewAccountLinkHours})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.FromName != "Example Accounts" || m.FromEmail != "no-reply@accounts.example.com" ||
+		m.Subject != "Your New Example Accounts Account" {
+		t.Errorf("unexpected sender or subject: %+v", m)
+	}
+	link := "https://accounts.example.com/reset-password/"
+	for _, body := range []string{m.Plain, m.HTML} {
+		if !strings.Contains(body, link) || !strings.Contains(body, "48 hour(s)") {
+			t.Errorf("missing link or lifetime: %s", body)
+		}
+	}
+	if !strings.Contains(m.Plain, "Hello <b>Jane</b> Doe & Co,") {
+		t.Errorf("plain text should not be escaped: %s", m.Plain)
+	}
+	if !strings.Contains(m.HTML, "Hello &lt;b&gt;Jane&lt;/b&gt; Doe &amp; Co,") {
+		t.Errorf("HTML should be escaped: %s", m.HTML)
+	}
+}
+
+func TestEmailTemplateOverrides(t *testing.T) {
+	directory := t.TempDir()
+	err := os.WriteFile(filepath.Join(directory, EmailWelcome+".txt"),
+		[]byte(`{{define "subject"}}Welcome, {{.User.FirstName}}{{end}}Hi {{.User.Username}}`),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Init(Config{EmailTemplates: directory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := PreviewEmail(EmailWelcome, exampleUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "Welcome, Jane" || m.Plain != "Hi jane.doe" ||
+		!strings.Contains(m.HTML, "set your password") {
+		t.Errorf("override not used for plain text only: %+v", m)
+	}
+
+	// Mistakes are found by Init, instead of when sending
+	for filename, content := range map[string]string{
+		EmailWelcome + ".txt":        "No subject",
+		EmailForgotPassword + ".txt": `{{define "subject"}}Hi{{end}}{{.User.Nmae}}`,
+		EmailChanged + ".html":       "<p>{{.NewEmail</p>",
+	} {
+		bad := t.TempDir()
+		err = os.WriteFile(filepath.Join(bad, filename), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = Init(Config{EmailTemplates: bad}); err == nil {
+			t.Errorf("%s should be invalid: %s", filename, content)
+		}
+	}
+	if err = Init(Config{SiteURL: "accounts.example.com"}); err == nil {
+		t.Error("SiteURL without a scheme should be invalid")
+	}
+}
@@ -0,0 +1,115 @@
+package user
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+// Passkey represents a single WebAuthn credential (e.g. a security key, or a
+// passkey saved in a phone or password manager) registered to a user.
+//
+// Users may have more than one, so they can keep a backup authenticator.
+type Passkey struct {
+	ID     int64 `db:"ID"` // Database ID
+	UserID int64 `db:"UserID"`
+	// Name is chosen by the user so they can tell their authenticators apart.
+	Name string `db:"Name"`
+	// CredentialID is the ID the authenticator gave this credential.
+	CredentialID []byte `db:"CredentialID"`
+	// Credential is the JSON-encoded credential record, including the public
+	// key. It's opaque to this package; see pkg/passkey.
+	Credential []byte `db:"Credential"`
+	// SignCount is the authenticator's signature counter from the last login.
+	SignCount uint32    `db:"SignCount"`
+	Created   time.Time `db:"Created"`
+	LastUsed  time.Time `db:"LastUsed"` // SQL Default: 0001-01-01 00:00:00
+}
+
+// GetPasskeys returns all of the user's passkeys, oldest first.
+func GetPasskeys(tx *sqlx.Tx, userID int64) (passkeys []Passkey, err error) {
+	err = tx.Select(&passkeys, `SELECT *
+								FROM Passkeys
+								WHERE UserID=?
+								ORDER BY Created ASC, ID ASC`, userID)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return passkeys, nil
+}
+
+// GetUsernameByID returns the username for the given database ID.
+func GetUsernameByID(tx *sqlx.Tx, userID int64) (username string, err error) {
+	err = tx.Get(&username, `SELECT Username FROM Users WHERE ID=?`, userID)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	return username, nil
+}
+
+// AddPasskey saves a newly registered passkey for the user.
+func AddPasskey(tx *sqlx.Tx, actor audit.Actor, userID int64, name string,
+	credentialID []byte, credential []byte, signCount uint32) error {
+	if name == "" {
+		name = "Passkey"
+	}
+	_, err := tx.Exec(`INSERT INTO Passkeys (UserID, Name, CredentialID,
+							Credential, SignCount, Created)
+					   VALUES (?,?,?,?,?,?)`,
+		userID, name, credentialID, credential, signCount, time.Now())
+	if err != nil {
+		return merry.Wrap(err).WithUserMessage("Failed to save passkey.")
+	}
+	log.Infof("added passkey '%s' for user ID %d", name, userID)
+	username, err := GetUsernameByID(tx, userID)
+	if err != nil {
+		return err
+	}
+	return audit.Record(tx, actor, "user.passkey_add", username, "", name)
+}
+
+// UpdatePasskeyAfterLogin saves the passkey's new signature counter (and any
+// flags the authenticator changed) after a successful login.
+func UpdatePasskeyAfterLogin(tx *sqlx.Tx, id int64, credential []byte,
+	signCount uint32) error {
+	_, err := tx.Exec(`UPDATE Passkeys
+					   SET Credential=?, SignCount=?, LastUsed=?
+					   WHERE ID=?`, credential, signCount, time.Now(), id)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return nil
+}
+
+// DeletePasskey removes one of the user's passkeys. The user ID is required so
+// users can't delete each other's passkeys by guessing IDs.
+func DeletePasskey(tx *sqlx.Tx, actor audit.Actor, userID int64, id int64) error {
+	var name string
+	err := tx.Get(&name, `SELECT Name FROM Passkeys WHERE ID=? AND UserID=?`, id, userID)
+	if err != nil && err != sql.ErrNoRows {
+		return merry.Wrap(err)
+	}
+	res, err := tx.Exec(`DELETE FROM Passkeys WHERE ID=? AND UserID=?`, id, userID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if n != 1 {
+		return merry.Errorf("passkey %d does not belong to user ID %d", id, userID).
+			WithUserMessage("That passkey does not exist.")
+	}
+	log.Infof("deleted passkey %d for user ID %d", id, userID)
+	username, err := GetUsernameByID(tx, userID)
+	if err != nil {
+		return err
+	}
+	return audit.Record(tx, actor, "user.passkey_delete", username, name, "")
+}
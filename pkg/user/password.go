@@ -1,7 +1,6 @@
 package user
 
 import (
-	"strconv"
 	"time"
 
 	"github.com/ansel1/merry"
@@ -9,50 +8,88 @@ import (
 	_ "github.com/go-sql-driver/mysql" // Blank import required for SQL drivers
 	"github.com/jmoiron/sqlx"
 
-	"github.com/joshsziegler/zauth/pkg/email"
+	"github.com/joshsziegler/zauth/pkg/audit"
 	pw "github.com/joshsziegler/zauth/pkg/password"
 	"github.com/joshsziegler/zauth/pkg/secrets"
 	"github.com/joshsziegler/zgo/pkg/log"
 )
 
-// setUserPassword hashes the given cleartext password and updates the database.
+// setUserPassword hashes the given cleartext password and updates the database,
+// keeping the last historyLength passwords in their history.
 //
 // Warning: This does NOT check the password for strength!
-func setUserPassword(tx *sqlx.Tx, username string, password string) error {
+func setUserPassword(tx *sqlx.Tx, username string, password string, historyLength int) error {
 	newPasswordHash, err := pw.Hash(password)
 	if err != nil {
 		return merry.Wrap(err)
 	}
 	_, err = tx.Exec(`UPDATE Users
 					  SET PasswordHash=?,
-					      PasswordSet=?
+					      PasswordSet=?,
+					      PasswordExpiryWarned=0
 					  WHERE Username=?`, newPasswordHash, time.Now(), username)
 	if err != nil {
 		return merry.Wrap(err)
 	}
 
+	return addPasswordHistory(tx, username, newPasswordHash, historyLength)
+}
+
+// upgradePasswordHash re-hashes the user's password using our current hashing
+// method. Unlike setUserPassword, this doesn't change PasswordSet, since the
+// password itself hasn't changed.
+func upgradePasswordHash(tx *sqlx.Tx, username string, password string) error {
+	newPasswordHash, err := pw.Hash(password)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`UPDATE Users
+					  SET PasswordHash=?
+					  WHERE Username=?`, newPasswordHash, username)
+	if err != nil {
+		return merry.Wrap(err)
+	}
 	return nil
 }
 
-// SetUserPassword checks the password's strength, and if ok, updates the
-// database.
-func SetUserPassword(tx *sqlx.Tx, username string, password string) error {
+// SetUserPassword checks the password's strength and that it wasn't used
+// recently, and if ok, updates the database. All of the user's sessions are
+// revoked, so anyone using a stolen session is logged out.
+func SetUserPassword(tx *sqlx.Tx, actor audit.Actor, username string, password string) error {
 	// Get first and last name so we can pass to CheckPasswordRules()
-	var firstName, lastName string
-	err := tx.QueryRowx(`SELECT FirstName, LastName
+	var userID int64
+	var firstName, lastName, currentHash string
+	err := tx.QueryRowx(`SELECT ID, FirstName, LastName, PasswordHash
 					FROM Users
 					WHERE Username=?`,
-		username).Scan(&firstName, &lastName)
+		username).Scan(&userID, &firstName, &lastName, &currentHash)
 	if err != nil {
 		return merry.Wrap(err)
 	}
-	// Check the user's password against our rules to see if it's too weak
-	err = pw.CheckPasswordRules(username, firstName, lastName, password)
+	// Check the user's password against their policy to see if it's too weak
+	policy, err := getPasswordPolicy(tx, userID)
+	if err != nil {
+		return err
+	}
+	err = pw.CheckPasswordRules(policy, username, firstName, lastName, password)
+	if err != nil {
+		return err
+	}
+	// Don't let them reuse their current or recent passwords
+	err = checkPasswordReuse(tx, userID, currentHash, password, policy.HistoryLength)
 	if err != nil {
 		return err
 	}
 	// Everything is ok, so change the password hash in the database
-	err = setUserPassword(tx, username, password)
+	err = setUserPassword(tx, username, password, policy.HistoryLength)
+	if err != nil {
+		return err
+	}
+	_, err = DeleteUserSessions(tx, actor, username)
+	if err != nil {
+		return err
+	}
+	err = audit.Record(tx, actor, "user.password", username, "", "")
 	if err != nil {
 		return err
 	}
@@ -138,28 +175,42 @@ func ValidatePasswordResetToken(tx *sqlx.Tx, token string) (username string, err
 	return
 }
 
-// SendPasswordResetEmail uses `GetPasswordResetToken` to create and send a
-// password reset link.
+// SendPasswordResetEmail sends a new user the welcome email, with a link
+// (see GetPasswordResetToken) to set their password. It's sent once the
+// transaction commits.
 //
-// This uses the configured site name, URI, reply email, and reset timeout to
-// create the email. If these are incorrectly configured, this may not work!
-func (u *User) SendPasswordResetEmail() error {
-	// TODO: Allow these to be set in the config.yml
-	siteName := "MindModeling"
-	siteURI := "https://user.mindmodeling.org"
-	replyEmail := "no-reply@mindmodeling.org"
-	linkTime := int64(8) // In Hours
-
-	// Generate link and send the email
-	resetLink := u.GetPasswordResetToken(linkTime)
-	err := email.Send(siteName, replyEmail, u.CommonName(), u.Email,
-		"Your New "+siteName+" Account",
-		"A new account has been created",
-		`<p>Hello `+u.CommonName()+`,</p>
-		<p>A new account has been created for you. Your username is <b>`+u.Username+`</b>. To complete
-		the setup, you need to
-		<a href="`+siteURI+`/reset-password/`+resetLink+`">
-		set your password here</a>. This link is valid for the next `+strconv.FormatInt(linkTime, 10)+`
-		hours.</p>`)
-	return err
+// The link uses the configured SiteURL, and works for NewAccountLinkHours. If
+// these are incorrectly configured, this may not work!
+func (u *User) SendPasswordResetEmail(tx *sqlx.Tx) error {
+	hours := config.NewAccountLinkHours
+	link := config.SiteURL + "/reset-password/" + u.GetPasswordResetToken(int64(hours))
+	return queueEmail(tx, EmailWelcome, u.Email, &EmailData{User: *u, Link: link,
+		LinkHours: hours})
+}
+
+// SendForgotPasswordEmail uses `GetPasswordResetToken` to create and send a
+// password reset link to a user who has forgotten their password.
+//
+// Unlike SendPasswordResetEmail, this is sent at the user's request, so it
+// tells them what to do if they did NOT ask for it. It's sent once the
+// transaction commits.
+func (u *User) SendForgotPasswordEmail(tx *sqlx.Tx) error {
+	hours := config.ForgotPasswordLinkHours
+	link := config.SiteURL + "/reset-password/" + u.GetPasswordResetToken(int64(hours))
+	return queueEmail(tx, EmailForgotPassword, u.Email, &EmailData{User: *u, Link: link,
+		LinkHours: hours})
+}
+
+// GetUsersByUsernameOrEmail returns the users whose username or email address
+// matches (ignoring case). Email addresses are not unique, so this may return
+// more than one user.
+func GetUsersByUsernameOrEmail(tx *sqlx.Tx, usernameOrEmail string) (users []User, err error) {
+	err = tx.Select(&users, `SELECT *
+							 FROM Users
+							 WHERE LOWER(Username)=LOWER(?) OR LOWER(Email)=LOWER(?)
+							 ORDER BY Username ASC`, usernameOrEmail, usernameOrEmail)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return users, nil
 }
@@ -0,0 +1,91 @@
+package user
+
+import (
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	pw "github.com/joshsziegler/zauth/pkg/password"
+)
+
+var (
+	// ErrorPasswordReused means the new password is the user's current
+	// password, or one of the previous ones their password policy's
+	// HistoryLength covers.
+	ErrorPasswordReused = merry.WithMessage(pw.ErrPasswordWeak, "password was used recently").
+		WithUserMessage("Password must be different from your current password.")
+)
+
+// checkPasswordReuse returns ErrorPasswordReused if the password matches the
+// user's current password hash, or any of their last historyLength before it.
+// Since the history's newest entry is the current password, that's the first
+// historyLength+1 entries.
+func checkPasswordReuse(tx *sqlx.Tx, userID int64, currentHash string, password string,
+	historyLength int) error {
+	var hashes []string
+	err := tx.Select(&hashes, `SELECT PasswordHash
+							   FROM PasswordHistory
+							   WHERE UserID=?
+							   ORDER BY ID DESC`, userID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if len(hashes) > historyLength+1 {
+		hashes = hashes[:historyLength+1]
+	}
+	hashes = append(hashes, currentHash)
+	for _, hash := range hashes {
+		// Ignore errors, since unset passwords (i.e. '-') can't be matched
+		valid, _, _ := pw.Valid(password, hash)
+		if !valid {
+			continue
+		}
+		if historyLength > 1 {
+			return ErrorPasswordReused.Here().WithUserMessagef(
+				"Password must be different from your last %d passwords.",
+				historyLength)
+		}
+		return ErrorPasswordReused.Here()
+	}
+	return nil
+}
+
+// addPasswordHistory saves the user's new password hash, and forgets all but
+// it and the historyLength hashes before it.
+func addPasswordHistory(tx *sqlx.Tx, username string, passwordHash string,
+	historyLength int) error {
+	var userID int64
+	err := tx.Get(&userID, `SELECT ID FROM Users WHERE Username=?`, username)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if historyLength > 0 {
+		_, err = tx.Exec(`INSERT INTO PasswordHistory (UserID, PasswordHash, Created)
+						  VALUES (?,?,?)`, userID, passwordHash, time.Now())
+		if err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	var ids []int64
+	err = tx.Select(&ids, `SELECT ID
+						   FROM PasswordHistory
+						   WHERE UserID=?
+						   ORDER BY ID DESC`, userID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	keep := 0
+	if historyLength > 0 {
+		keep = historyLength + 1
+	}
+	if len(ids) <= keep {
+		return nil
+	}
+	_, err = tx.Exec(`DELETE FROM PasswordHistory WHERE UserID=? AND ID<=?`,
+		userID, ids[keep])
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return nil
+}
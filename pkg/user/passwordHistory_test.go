@@ -0,0 +1,72 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/ansel1/merry"
+
+	"github.com/joshsziegler/zauth/pkg/db"
+	pw "github.com/joshsziegler/zauth/pkg/password"
+)
+
+func TestPasswordHistory(t *testing.T) {
+	err := pw.Init(pw.Config{Policy: pw.Policy{HistoryLength: 2}})
+	if err != nil {
+		t.Fatalf("Setting the password policy failed: \n%+v", err)
+	}
+	defer pw.Init(pw.Config{})
+	database := db.SetupTestingDatabase(t, db.Config{
+		Username: "joshz",
+		Password: "Manikin06!",
+		Address:  "localhost",
+		DBName:   "zauth"},
+		"../../db-schema-v3.0.sql")
+
+	tx := db.GetTxOrFailTesting(t, database)
+	defer tx.Rollback()
+	u, err := NewUser(tx, testActor, "", "Jane", "Doe", "doe@email.com")
+	if err != nil {
+		t.Fatalf("Creating a valid user failed: \n%+v", err)
+	}
+	passwords := []string{
+		"copper kettle sings at dawn 41",
+		"violet harbor drifts slowly 72",
+		"granite owl watches the moor 19",
+		"amber comet crosses the bay 86",
+	}
+	for _, password := range passwords[:3] {
+		err = SetUserPassword(tx, testActor, u.Username, password)
+		if err != nil {
+			t.Fatalf("Setting a new password failed: \n%+v", err)
+		}
+	}
+
+	// The current password and the two before it can't be reused...
+	for _, password := range passwords[:3] {
+		err = SetUserPassword(tx, testActor, u.Username, password)
+		if !merry.Is(err, ErrorPasswordReused) {
+			t.Errorf("Reusing '%s' didn't return ErrorPasswordReused: \n%+v", password, err)
+		}
+	}
+	// ...but once a fourth is set, the first has fallen out of the history
+	err = SetUserPassword(tx, testActor, u.Username, passwords[3])
+	if err != nil {
+		t.Fatalf("Setting a new password failed: \n%+v", err)
+	}
+	var count int
+	err = tx.Get(&count, `SELECT COUNT(*) FROM PasswordHistory WHERE UserID=?`, u.ID)
+	if err != nil {
+		t.Fatalf("Counting password history failed: \n%+v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 password history entries, not %d", count)
+	}
+	err = SetUserPassword(tx, testActor, u.Username, passwords[1])
+	if !merry.Is(err, ErrorPasswordReused) {
+		t.Errorf("Reusing a recent password didn't return ErrorPasswordReused: \n%+v", err)
+	}
+	err = SetUserPassword(tx, testActor, u.Username, passwords[0])
+	if err != nil {
+		t.Errorf("Reusing a password older than the history failed: \n%+v", err)
+	}
+}
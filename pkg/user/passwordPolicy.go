@@ -0,0 +1,32 @@
+package user
+
+import (
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	pw "github.com/joshsziegler/zauth/pkg/password"
+)
+
+// getGroupNames returns the name of each group the user belongs to.
+func getGroupNames(tx *sqlx.Tx, userID int64) (groups []string, err error) {
+	err = tx.Select(&groups, `SELECT UserGroups.Name
+							  FROM UserGroups
+							  INNER JOIN User2Group
+								  ON UserGroups.ID=User2Group.GroupID
+							  WHERE User2Group.UserID=?
+							  ORDER BY UserGroups.Name ASC`, userID)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return groups, nil
+}
+
+// getPasswordPolicy returns the rules for the user's passwords, when their
+// groups aren't already loaded (see User.PasswordPolicy).
+func getPasswordPolicy(tx *sqlx.Tx, userID int64) (pw.Policy, error) {
+	groups, err := getGroupNames(tx, userID)
+	if err != nil {
+		return pw.Policy{}, err
+	}
+	return pw.PolicyFor(groups), nil
+}
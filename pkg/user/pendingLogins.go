@@ -0,0 +1,123 @@
+package user
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// PendingLoginTimeout is how long a user has to provide their second
+	// factor after providing a correct password.
+	PendingLoginTimeout = 5 * time.Minute
+	// PendingLoginAttempts is how many codes we accept before making the user
+	// start over with their password.
+	PendingLoginAttempts = 5
+)
+
+var (
+	// ErrorPendingLoginInvalid means there's no pending login for the token,
+	// it has expired, or it has used all of its attempts.
+	ErrorPendingLoginInvalid = merry.New("invalid pending login").
+		WithUserMessage("Please login with your username and password first.")
+)
+
+// CreatePendingLogin records that the user provided the correct password, but
+// must still provide their second factor before they are logged in. It returns
+// the token to give their browser. Like sessions, only the token's hash is
+// stored, and the attempts are counted here (not in the browser), so they
+// can't be reset by replaying an old cookie.
+func CreatePendingLogin(tx *sqlx.Tx, username string, remember bool) (token string, err error) {
+	token, err = newSessionToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	res, err := tx.Exec(`INSERT INTO PendingLogins (UserID, TokenHash, Created,
+							Attempts, Remember)
+						 SELECT ID, ?, ?, 0, ?
+						 FROM Users
+						 WHERE Username=?`,
+		hashSessionToken(token), now, remember, username)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	if n != 1 {
+		return "", merry.Errorf("can't create pending login for unknown user '%s'",
+			username)
+	}
+	// Clean up everyone's expired pending logins while we're here
+	_, err = tx.Exec(`DELETE FROM PendingLogins WHERE Created<?`,
+		now.Add(-PendingLoginTimeout))
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	return token, nil
+}
+
+// GetPendingLogin returns the username awaiting a second factor for the token,
+// and whether they asked to be remembered. Returns ErrorPendingLoginInvalid if
+// there isn't one, or it has expired or used all of its attempts.
+func GetPendingLogin(tx *sqlx.Tx, token string) (username string, remember bool, err error) {
+	var created time.Time
+	var attempts int
+	err = tx.QueryRowx(`SELECT Users.Username, PendingLogins.Created,
+							PendingLogins.Attempts, PendingLogins.Remember
+						FROM PendingLogins
+						INNER JOIN Users
+							ON PendingLogins.UserID=Users.ID
+						WHERE PendingLogins.TokenHash=?`,
+		hashSessionToken(token)).Scan(&username, &created, &attempts, &remember)
+	if err == sql.ErrNoRows {
+		return "", false, ErrorPendingLoginInvalid.Here()
+	} else if err != nil {
+		return "", false, merry.Wrap(err)
+	}
+	if time.Since(created) > PendingLoginTimeout || attempts >= PendingLoginAttempts {
+		return "", false, ErrorPendingLoginInvalid.Here().
+			WithMessagef("pending login for %s expired or used every attempt", username)
+	}
+	return username, remember, nil
+}
+
+// UsePendingLoginAttempt counts an attempt to provide a second factor, and
+// returns how many are left. Call this BEFORE checking the code, so
+// simultaneous requests can't get extra attempts. Returns
+// ErrorPendingLoginInvalid if there are no attempts left.
+func UsePendingLoginAttempt(tx *sqlx.Tx, token string) (remaining int, err error) {
+	tokenHash := hashSessionToken(token)
+	res, err := tx.Exec(`UPDATE PendingLogins
+						 SET Attempts=Attempts+1
+						 WHERE TokenHash=? AND Attempts<? AND Created>=?`,
+		tokenHash, PendingLoginAttempts, time.Now().Add(-PendingLoginTimeout))
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	if n != 1 {
+		return 0, ErrorPendingLoginInvalid.Here()
+	}
+	var attempts int
+	err = tx.Get(&attempts, `SELECT Attempts FROM PendingLogins WHERE TokenHash=?`,
+		tokenHash)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	return PendingLoginAttempts - attempts, nil
+}
+
+// DeletePendingLogin removes the pending login, if any, for the token.
+func DeletePendingLogin(tx *sqlx.Tx, token string) error {
+	_, err := tx.Exec(`DELETE FROM PendingLogins WHERE TokenHash=?`,
+		hashSessionToken(token))
+	return merry.Wrap(err)
+}
@@ -1,10 +1,44 @@
 package user
 
+// Can returns true if THIS user has been granted the permission by one of the
+// roles bound to their groups.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) Can(permission Permission) bool {
+	return u.Permissions[permission]
+}
+
+// HasAllPermissionsOf returns true if THIS user has every permission that
+// OTHER has. Users can only act on those they outrank (or equal), so helpdesk
+// staff can't reset an admin's password and then login as them.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) HasAllPermissionsOf(other User) bool {
+	for permission, granted := range other.Permissions {
+		if granted && !u.Permissions[permission] {
+			return false
+		}
+	}
+	return true
+}
+
+// canActOnUser returns true if THIS user is OTHER, or if they have the
+// permission and every permission OTHER has.
+func (u User) canActOnUser(other User, permission Permission) bool {
+	if u.Username == other.Username {
+		return true
+	}
+	return u.Can(permission) && u.HasAllPermissionsOf(other)
+}
+
 // CanViewUser returns true if THIS user can view USERNAME's details.
 //
-// Admins can view/edit all users. All others can only view/edit themselves.
-func (u *User) CanViewUser(username string) bool {
-	if u.IsAdmin() {
+// Users with PermUsersView can view all users. All others can only view
+// themselves.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) CanViewUser(username string) bool {
+	if u.Can(PermUsersView) {
 		return true
 	}
 	if u.Username == username {
@@ -13,15 +47,123 @@ func (u *User) CanViewUser(username string) bool {
 	return false
 }
 
-// CanEditUser returns true if THIS user can edit USERNAME's details.
+// CanEditUser returns true if THIS user can edit OTHER's name, email,
+// two-factor authentication, and passkeys.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) CanEditUser(other User) bool {
+	return u.canActOnUser(other, PermUsersEdit)
+}
+
+// CanSetPassword returns true if THIS user can set OTHER's password.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) CanSetPassword(other User) bool {
+	return u.canActOnUser(other, PermUsersResetPassword)
+}
+
+// CanDisableUser returns true if THIS user can enable or disable OTHER. Users
+// can't disable themselves.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) CanDisableUser(other User) bool {
+	if u.Username == other.Username {
+		return false
+	}
+	return u.canActOnUser(other, PermUsersDisable)
+}
+
+// CanDeleteUser returns true if THIS user can archive, restore, and delete
+// OTHER. Users can't archive or delete themselves.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) CanDeleteUser(other User) bool {
+	if u.Username == other.Username {
+		return false
+	}
+	return u.canActOnUser(other, PermUsersDelete)
+}
+
+// CanRevokeSessions returns true if THIS user can see and revoke OTHER's
+// sessions. Users can always revoke their own.
 //
-// Admins can view/edit all users. All others can only view/edit themselves.
-func (u *User) CanEditUser(username string) bool {
-	if u.IsAdmin() {
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) CanRevokeSessions(other User) bool {
+	return u.canActOnUser(other, PermUsersDisable)
+}
+
+// CanManageGroupMembers returns true if THIS user can add and remove members of
+// the named group.
+//
+// Users with PermGroupsManage can manage all groups. Owners and managers can
+// manage their own.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) CanManageGroupMembers(group string) bool {
+	if u.Can(PermGroupsManage) {
 		return true
 	}
-	if u.Username == username {
+	_, ok := u.ManagedGroups[group]
+	return ok
+}
+
+// CanAddGroupMember returns true if THIS user can add members (including
+// themselves) to the named group, whose roles grant it these permissions.
+//
+// Owners and managers can add members to their own groups, but only if the
+// group grants no permission they don't already have. Otherwise, they could
+// add themselves to a group bound to the admin role and become an admin.
+// Users with PermGroupsManage can add members to every group.
+func (u User) CanAddGroupMember(group string, granted []Permission) bool {
+	if !u.CanManageGroupMembers(group) {
+		return false
+	}
+	if u.Can(PermGroupsManage) {
 		return true
 	}
-	return false
+	for _, permission := range granted {
+		if !u.Can(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanRemoveGroupMember returns true if THIS user can remove OTHER from the
+// named group.
+//
+// Owners and managers can remove members from their own groups, but only those
+// they outrank (or equal), so they can't take away the rights of someone with
+// more permissions (e.g. an admin). Users with PermGroupsManage can remove
+// anyone from every group.
+func (u User) CanRemoveGroupMember(group string, other User) bool {
+	if !u.CanManageGroupMembers(group) {
+		return false
+	}
+	if u.Can(PermGroupsManage) || u.Username == other.Username {
+		return true
+	}
+	return u.HasAllPermissionsOf(other)
+}
+
+// CanManageGroupManagers returns true if THIS user can appoint and remove the
+// owners and managers of the named group.
+//
+// Users with PermGroupsManage can do so for all groups. Owners can do so for
+// their own.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) CanManageGroupManagers(group string) bool {
+	if u.Can(PermGroupsManage) {
+		return true
+	}
+	return u.ManagedGroups[group] == GroupRoleOwner
+}
+
+// ManagesAnyGroup returns true if THIS user can manage every group, or owns or
+// manages at least one group.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) ManagesAnyGroup() bool {
+	return u.Can(PermGroupsManage) || len(u.ManagedGroups) > 0
 }
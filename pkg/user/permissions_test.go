@@ -0,0 +1,112 @@
+package user
+
+import "testing"
+
+func TestPermissionsCannotEscalate(t *testing.T) {
+	admin := User{Username: "admin", Permissions: map[Permission]bool{
+		PermUsersView: true, PermUsersResetPassword: true, PermUsersDisable: true,
+		PermGroupsManage: true, PermRolesManage: true}}
+	helpdesk := User{Username: "helpdesk", Permissions: map[Permission]bool{
+		PermUsersView: true, PermUsersResetPassword: true}}
+	regular := User{Username: "regular"}
+
+	if !helpdesk.CanSetPassword(regular) {
+		t.Error("helpdesk should be able to reset a regular user's password")
+	}
+	if helpdesk.CanSetPassword(admin) {
+		t.Error("helpdesk should not be able to reset an admin's password")
+	}
+	if !admin.CanSetPassword(helpdesk) {
+		t.Error("admin should be able to reset helpdesk's password")
+	}
+	if helpdesk.CanDisableUser(regular) {
+		t.Error("helpdesk should not be able to disable users without users.disable")
+	}
+	if admin.CanDisableUser(admin) {
+		t.Error("users should not be able to disable themselves")
+	}
+	deleter := User{Username: "deleter", Permissions: map[Permission]bool{
+		PermUsersDelete: true}}
+	if !deleter.CanDeleteUser(regular) || deleter.CanDeleteUser(deleter) ||
+		deleter.CanDeleteUser(admin) || admin.CanDeleteUser(regular) {
+		t.Error("only users with users.delete should delete others they outrank")
+	}
+	if !regular.CanRevokeSessions(regular) || helpdesk.CanRevokeSessions(regular) ||
+		!admin.CanRevokeSessions(helpdesk) {
+		t.Error("only users with users.disable should revoke others' sessions")
+	}
+	if !regular.CanSetPassword(regular) || !regular.CanEditUser(regular) {
+		t.Error("users should be able to change their own password and details")
+	}
+	if regular.CanViewUser("admin") || !helpdesk.CanViewUser("admin") {
+		t.Error("only users with users.view should view others")
+	}
+	if helpdesk.CanManageGroupMembers("admin") || !admin.CanManageGroupMembers("admin") {
+		t.Error("only users with groups.manage should manage any group")
+	}
+}
+
+func TestGroupManagerPermissions(t *testing.T) {
+	u := User{Username: "u", ManagedGroups: map[string]string{
+		"owned": GroupRoleOwner, "managed": GroupRoleManager}}
+	if !u.CanManageGroupMembers("owned") || !u.CanManageGroupMembers("managed") {
+		t.Error("owners and managers should manage their group's members")
+	}
+	if u.CanManageGroupMembers("other") {
+		t.Error("managers should not manage other groups")
+	}
+	if !u.CanManageGroupManagers("owned") || u.CanManageGroupManagers("managed") {
+		t.Error("only owners should appoint other managers")
+	}
+	if !u.CanAddGroupMember("managed", nil) ||
+		!u.CanAddGroupMember("managed", []Permission{}) {
+		t.Error("managers should add members to groups without roles")
+	}
+	if !u.ManagesAnyGroup() || (User{}).ManagesAnyGroup() {
+		t.Error("ManagesAnyGroup is wrong")
+	}
+}
+
+func TestGroupManagerCannotJoinRoleGroup(t *testing.T) {
+	manager := User{Username: "manager", ManagedGroups: map[string]string{
+		"ops": GroupRoleOwner}, Permissions: map[Permission]bool{PermUsersView: true}}
+	adminRole := []Permission{PermUsersView, PermRolesManage, PermGroupsManage}
+	if manager.CanAddGroupMember("ops", adminRole) {
+		t.Error("a manager should not add anyone (e.g. themselves) to a group " +
+			"granting permissions they don't have")
+	}
+	if !manager.CanAddGroupMember("ops", []Permission{PermUsersView}) {
+		t.Error("a manager should add members to a group granting only " +
+			"permissions they already have")
+	}
+	if manager.CanAddGroupMember("other", nil) {
+		t.Error("a manager should not add members to other groups")
+	}
+	groupAdmin := User{Username: "groupadmin", Permissions: map[Permission]bool{
+		PermGroupsManage: true}}
+	if !groupAdmin.CanAddGroupMember("ops", adminRole) {
+		t.Error("users with groups.manage should add members to every group")
+	}
+}
+
+func TestGroupManagerCannotRemoveHigherMembers(t *testing.T) {
+	manager := User{Username: "manager", ManagedGroups: map[string]string{
+		"ops": GroupRoleManager}, Permissions: map[Permission]bool{PermUsersView: true}}
+	admin := User{Username: "admin", Permissions: map[Permission]bool{
+		PermUsersView: true, PermRolesManage: true, PermGroupsManage: true}}
+	regular := User{Username: "regular", Permissions: map[Permission]bool{
+		PermUsersView: true}}
+	if manager.CanRemoveGroupMember("ops", admin) {
+		t.Error("a manager should not remove a member with more permissions")
+	}
+	if !manager.CanRemoveGroupMember("ops", regular) ||
+		!manager.CanRemoveGroupMember("ops", manager) {
+		t.Error("a manager should remove members they outrank or equal")
+	}
+	if manager.CanRemoveGroupMember("other", regular) {
+		t.Error("a manager should not remove members from other groups")
+	}
+	if !admin.CanRemoveGroupMember("ops", manager) {
+		t.Error("users with groups.manage should remove anyone")
+	}
+}
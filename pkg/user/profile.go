@@ -0,0 +1,138 @@
+package user
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/dchest/passwordreset"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zauth/pkg/secrets"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+var (
+	ErrorEmailUnchanged = merry.New("email address is unchanged").
+		WithUserMessage("That is already your email address.")
+)
+
+// UpdateName changes the user's first and last name, using the same rules as
+// NewUser.
+func UpdateName(tx *sqlx.Tx, actor audit.Actor, username string, firstName string,
+	lastName string) error {
+	firstName, lastName, err := cleanNames(firstName, lastName)
+	if err != nil {
+		return err
+	}
+	var before string
+	err = tx.Get(&before, `SELECT CONCAT(FirstName, ' ', LastName)
+						   FROM Users
+						   WHERE Username=?`, username)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`UPDATE Users
+					  SET FirstName=?, LastName=?
+					  WHERE Username=?`, firstName, lastName, username)
+	if err != nil {
+		return merry.Wrap(err).WithUserMessage("Failed to update name.")
+	}
+	err = audit.Record(tx, actor, "user.name", username, before,
+		firstName+" "+lastName)
+	if err != nil {
+		return err
+	}
+	log.Infof("changed name for %s to '%s %s'", username, firstName, lastName)
+	return nil
+}
+
+// emailVerifyLogin combines the username and new email address into the
+// "login" signed by the verification token, so the token can only be used to
+// set this user's email to this address.
+func emailVerifyLogin(username string, newEmail string) string {
+	return username + "\n" + newEmail
+}
+
+// getEmailVerifyValue returns the user's CURRENT email address, which is mixed
+// into the token's signature. Once their email changes, all outstanding
+// verification tokens become invalid (just like password reset tokens).
+func getEmailVerifyValue(tx *sqlx.Tx) func(login string) ([]byte, error) {
+	return func(login string) ([]byte, error) {
+		username := strings.SplitN(login, "\n", 2)[0]
+		var current string
+		err := tx.Get(&current, `SELECT Email FROM Users WHERE Username=?`, username)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		return []byte(current), nil
+	}
+}
+
+// RequestEmailChange validates the new email address and sends it a signed
+// verification link. The user's email does NOT change until they follow the
+// link (see ConfirmEmailChange). The link is sent once the transaction commits.
+func (u *User) RequestEmailChange(tx *sqlx.Tx, newEmail string) error {
+	newEmail, err := cleanEmail(newEmail)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(newEmail, u.Email) {
+		return ErrorEmailUnchanged.Here()
+	}
+	hours := config.EmailVerifyLinkHours
+	token := passwordreset.NewToken(emailVerifyLogin(u.Username, newEmail),
+		time.Duration(hours)*time.Hour, []byte(u.Email), secrets.EmailVerifySecret())
+	err = queueEmail(tx, EmailVerify, newEmail, &EmailData{User: *u,
+		Link:      config.SiteURL + "/verify-email/" + token,
+		LinkHours: hours, NewEmail: newEmail})
+	if err != nil {
+		return merry.Wrap(err).WithUserMessage("Failed to send the verification email.")
+	}
+	log.Infof("queued email verification for %s to %s", u.Username, newEmail)
+	return nil
+}
+
+// ConfirmEmailChange checks the verification token, and if valid, changes the
+// user's email address and notifies their old address of the change.
+//
+// The actor's username is ignored, since whoever has the link may not be logged
+// in. The event is recorded as the user themself.
+func ConfirmEmailChange(tx *sqlx.Tx, actor audit.Actor, token string) (
+	username string, err error) {
+	login, err := passwordreset.VerifyToken(token, getEmailVerifyValue(tx),
+		secrets.EmailVerifySecret())
+	if err != nil {
+		return "", merry.Wrap(err).
+			WithUserMessage("Invalid or expired email verification link.")
+	}
+	parts := strings.SplitN(login, "\n", 2)
+	if len(parts) != 2 {
+		return "", merry.New("malformed email verification token").
+			WithUserMessage("Invalid or expired email verification link.")
+	}
+	username, newEmail := parts[0], parts[1]
+	u, err := GetUserWithGroups(tx, username)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`UPDATE Users SET Email=? WHERE ID=?`, newEmail, u.ID)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	err = audit.Record(tx, actor.As(username), "user.email", username, u.Email,
+		newEmail)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("changed email for %s from %s to %s", username, u.Email, newEmail)
+
+	// Let the old address know, in case this wasn't them. Failing to queue
+	// this shouldn't undo the change they just verified.
+	err = queueEmail(tx, EmailChanged, u.Email, &EmailData{User: u, NewEmail: newEmail})
+	if err != nil {
+		log.Errorf("failed to notify %s of their email change: %s", username, err)
+	}
+	return username, nil
+}
@@ -0,0 +1,400 @@
+package user
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zauth/pkg/db"
+)
+
+// Permission is the name of something a user is allowed to do. Permissions are
+// granted to Roles, and Roles are bound to Groups, so a user has every
+// permission of every role bound to any of their groups.
+type Permission string
+
+const (
+	// PermUsersView allows viewing every user's details, not just your own.
+	PermUsersView Permission = "users.view"
+	// PermUsersCreate allows creating new users.
+	PermUsersCreate Permission = "users.create"
+	// PermUsersEdit allows changing other users' names, email addresses,
+	// two-factor authentication, and passkeys.
+	PermUsersEdit Permission = "users.edit"
+	// PermUsersDisable allows enabling and disabling other users.
+	PermUsersDisable Permission = "users.disable"
+	// PermUsersDelete allows archiving, restoring, and deleting other users.
+	PermUsersDelete Permission = "users.delete"
+	// PermUsersResetPassword allows setting other users' passwords.
+	PermUsersResetPassword Permission = "users.reset_password"
+	// PermGroupsManage allows creating, changing, and deleting any group,
+	// including its members and managers.
+	PermGroupsManage Permission = "groups.manage"
+	// PermRolesManage allows creating, changing, and deleting roles.
+	PermRolesManage Permission = "roles.manage"
+	// PermAuditRead allows reading the audit log.
+	PermAuditRead Permission = "audit.read"
+	// PermDirectoryExport allows downloading every user and group as LDIF or
+	// JSON.
+	PermDirectoryExport Permission = "directory.export"
+	// PermEmailsManage allows previewing our notification emails, and seeing
+	// and resending those which failed to send.
+	PermEmailsManage Permission = "emails.manage"
+)
+
+// PermissionInfo describes a Permission for the web UI.
+type PermissionInfo struct {
+	Name        Permission
+	Description string
+}
+
+// Permissions lists every Permission, along with a short description.
+var Permissions = []PermissionInfo{
+	{PermUsersView, "View every user's details"},
+	{PermUsersCreate, "Create new users"},
+	{PermUsersEdit, "Edit other users' names, emails, two-factor, and passkeys"},
+	{PermUsersDisable, "Enable and disable users"},
+	{PermUsersDelete, "Archive, restore, and delete users"},
+	{PermUsersResetPassword, "Set other users' passwords"},
+	{PermGroupsManage, "Create, change, and delete any group"},
+	{PermRolesManage, "Create, change, and delete roles"},
+	{PermAuditRead, "Read the audit log"},
+	{PermDirectoryExport, "Download every user and group (optionally with password hashes)"},
+	{PermEmailsManage, "Preview notification emails, and resend failed ones"},
+}
+
+var (
+	ErrorInvalidPermission = merry.New("invalid permission").
+				WithUserMessage("One or more of those permissions does not exist.")
+	// ErrorNoRoleManagers is returned when a change would leave nobody with
+	// PermRolesManage. The caller MUST roll back the transaction.
+	ErrorNoRoleManagers = merry.New("change would leave nobody able to manage roles").
+				WithUserMessage("That change would leave nobody able to manage roles.")
+)
+
+// Role is a named set of permissions, granted to the members of its groups.
+type Role struct {
+	ID          int64  `db:"ID"`
+	Name        string `db:"Name"`
+	Description string `db:"Description"`
+	Permissions []Permission
+	Groups      []string
+}
+
+// Has returns true if this role grants the permission.
+//
+// ** Doesn't use a pointer to `r` so it can be use in HTML templates.
+func (r Role) Has(permission Permission) bool {
+	for _, p := range r.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidPermission returns true if the permission is one of Permissions.
+func isValidPermission(permission Permission) bool {
+	for _, p := range Permissions {
+		if p.Name == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// getUserPermissions returns every permission granted to the user by the roles
+// bound to their groups.
+func getUserPermissions(tx *sqlx.Tx, userID int64) (permissions map[Permission]bool, err error) {
+	var names []Permission
+	err = tx.Select(&names, `SELECT DISTINCT Role2Permission.Permission
+							 FROM Role2Permission
+							 INNER JOIN Role2Group
+								 ON Role2Group.RoleID=Role2Permission.RoleID
+							 INNER JOIN User2Group
+								 ON User2Group.GroupID=Role2Group.GroupID
+							 WHERE User2Group.UserID=?;`, userID)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	permissions = make(map[Permission]bool, len(names))
+	for _, name := range names {
+		permissions[name] = true
+	}
+	return permissions, nil
+}
+
+// GetGroupPermissions maps the name of each group bound to a role to every
+// permission its roles grant. Groups without a role aren't included.
+func GetGroupPermissions(tx *sqlx.Tx) (permissions map[string][]Permission, err error) {
+	rows, err := tx.Queryx(`SELECT DISTINCT UserGroups.Name, Role2Permission.Permission
+							FROM Role2Group
+							INNER JOIN UserGroups
+								ON UserGroups.ID=Role2Group.GroupID
+							INNER JOIN Role2Permission
+								ON Role2Permission.RoleID=Role2Group.RoleID
+							ORDER BY UserGroups.Name ASC, Role2Permission.Permission ASC`)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+	permissions = make(map[string][]Permission)
+	var group string
+	var permission Permission
+	for rows.Next() {
+		err = rows.Scan(&group, &permission)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		permissions[group] = append(permissions[group], permission)
+	}
+	return permissions, merry.Wrap(rows.Err())
+}
+
+// GetRoles returns all roles, including their permissions and groups, sorted by
+// name.
+func GetRoles(tx *sqlx.Tx) (roles []*Role, err error) {
+	err = tx.Select(&roles, `SELECT ID, Name, Description FROM Roles ORDER BY Name ASC`)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	byID := make(map[int64]*Role, len(roles))
+	for _, role := range roles {
+		byID[role.ID] = role
+	}
+	// Get every role's permissions
+	rows, err := tx.Queryx(`SELECT RoleID, Permission FROM Role2Permission`)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+	var roleID int64
+	var name string
+	for rows.Next() {
+		err = rows.Scan(&roleID, &name)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		if role, ok := byID[roleID]; ok {
+			role.Permissions = append(role.Permissions, Permission(name))
+		}
+	}
+	// Get every role's groups
+	rows, err = tx.Queryx(`SELECT Role2Group.RoleID, UserGroups.Name
+						   FROM Role2Group
+						   INNER JOIN UserGroups
+							   ON UserGroups.ID=Role2Group.GroupID
+						   ORDER BY UserGroups.Name ASC`)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&roleID, &name)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		if role, ok := byID[roleID]; ok {
+			role.Groups = append(role.Groups, name)
+		}
+	}
+	for _, role := range roles {
+		sort.Slice(role.Permissions, func(i, j int) bool {
+			return role.Permissions[i] < role.Permissions[j]
+		})
+	}
+	return roles, nil
+}
+
+// GetRole returns the named role, including its permissions and groups.
+func GetRole(tx *sqlx.Tx, name string) (*Role, error) {
+	roles, err := GetRoles(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			return role, nil
+		}
+	}
+	return nil, merry.WithMessagef(sql.ErrNoRows, "role '%s' does not exist", name)
+}
+
+// getRoleID returns the database ID of the named role.
+func getRoleID(tx *sqlx.Tx, name string) (id int64, err error) {
+	err = tx.Get(&id, `SELECT ID FROM Roles WHERE Name=?`, name)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	return id, nil
+}
+
+// AddRole creates a new role without any permissions or groups.
+func AddRole(tx *sqlx.Tx, actor audit.Actor, name string, description string) error {
+	if !reValidName.MatchString(name) {
+		return merry.Errorf("invalid role name '%s'", name).
+			WithUserMessage("Role names must start with a lowercase letter or " +
+				"digit, and only contain lowercase letters, digits, periods, " +
+				"underscores, and hyphens.")
+	}
+	_, err := tx.Exec(`INSERT INTO Roles (Name, Description) VALUES (?, ?)`,
+		name, description)
+	if err != nil {
+		sqlError, ok := err.(*mysql.MySQLError)
+		if ok && sqlError.Number == db.ErrDuplicateEntry {
+			return merry.New("a role with that name already exists").
+				WithUserMessage("A role with that name already exists.")
+		}
+		return merry.Wrap(err)
+	}
+	return audit.Record(tx, actor, "role.create", name, "", description)
+}
+
+// DeleteRole deletes the named role, which removes its permissions from the
+// members of its groups.
+func DeleteRole(tx *sqlx.Tx, actor audit.Actor, name string) error {
+	_, err := tx.Exec(`DELETE FROM Roles WHERE Name=?`, name)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	err = checkRoleManagersRemain(tx)
+	if err != nil {
+		return err
+	}
+	return audit.Record(tx, actor, "role.delete", name, "", "")
+}
+
+// checkRoleManagersRemain returns ErrorNoRoleManagers if no enabled,
+// unarchived user has PermRolesManage, so nobody can lock everyone out of the
+// roles page. Every change which can take PermRolesManage away from someone
+// (e.g. removing them from a group, or disabling them) MUST call this.
+func checkRoleManagersRemain(tx *sqlx.Tx) error {
+	var count int
+	err := tx.Get(&count, `SELECT COUNT(DISTINCT Users.ID)
+						   FROM Users
+						   INNER JOIN User2Group
+							   ON User2Group.UserID=Users.ID
+						   INNER JOIN Role2Group
+							   ON Role2Group.GroupID=User2Group.GroupID
+						   INNER JOIN Role2Permission
+							   ON Role2Permission.RoleID=Role2Group.RoleID
+						   WHERE Role2Permission.Permission=? AND Users.Disabled=0
+							   AND Users.ArchivedAt=?`, PermRolesManage, MySQLZeroDate)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if count < 1 {
+		return ErrorNoRoleManagers.Here()
+	}
+	return nil
+}
+
+// SetRolePermissions replaces the named role's permissions.
+//
+// Like DeleteRole and RemoveGroupFromRole, this returns ErrorNoRoleManagers if
+// nobody would be left with PermRolesManage.
+func SetRolePermissions(tx *sqlx.Tx, actor audit.Actor, name string,
+	permissions []Permission) error {
+	for _, p := range permissions {
+		if !isValidPermission(p) {
+			return ErrorInvalidPermission.Here().WithMessagef("invalid permission '%s'", p)
+		}
+	}
+	roleID, err := getRoleID(tx, name)
+	if err != nil {
+		return err
+	}
+	var before []string
+	err = tx.Select(&before, `SELECT Permission
+							  FROM Role2Permission
+							  WHERE RoleID=?
+							  ORDER BY Permission ASC`, roleID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`DELETE FROM Role2Permission WHERE RoleID=?`, roleID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	for _, p := range permissions {
+		_, err = tx.Exec(`INSERT INTO Role2Permission (RoleID, Permission)
+						  VALUES (?, ?)`, roleID, p)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	err = checkRoleManagersRemain(tx)
+	if err != nil {
+		return err
+	}
+	after := make([]string, len(permissions))
+	for i, p := range permissions {
+		after[i] = string(p)
+	}
+	sort.Strings(after)
+	return audit.Record(tx, actor, "role.permissions", name,
+		strings.Join(before, ", "), strings.Join(after, ", "))
+}
+
+// AddGroupToRole grants the named role to every member of the named group.
+func AddGroupToRole(tx *sqlx.Tx, actor audit.Actor, role string, group string) error {
+	roleID, err := getRoleID(tx, role)
+	if err != nil {
+		return err
+	}
+	var groupID int64
+	err = tx.Get(&groupID, `SELECT ID FROM UserGroups WHERE Name=?`, group)
+	if merry.Is(err, sql.ErrNoRows) {
+		return merry.Errorf("group '%s' does not exist", group).
+			WithUserMessagef("Group '%s' does not exist.", group)
+	} else if err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`INSERT IGNORE INTO Role2Group (RoleID, GroupID)
+					  VALUES (?, ?)`, roleID, groupID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return audit.Record(tx, actor, "role.group_add", role, "", group)
+}
+
+// RemoveGroupFromRole stops granting the named role to members of the named
+// group.
+func RemoveGroupFromRole(tx *sqlx.Tx, actor audit.Actor, role string, group string) error {
+	_, err := tx.Exec(`DELETE Role2Group
+					   FROM Role2Group
+					   INNER JOIN Roles
+						   ON Roles.ID=Role2Group.RoleID
+					   INNER JOIN UserGroups
+						   ON UserGroups.ID=Role2Group.GroupID
+					   WHERE Roles.Name=? AND UserGroups.Name=?`, role, group)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	err = checkRoleManagersRemain(tx)
+	if err != nil {
+		return err
+	}
+	return audit.Record(tx, actor, "role.group_remove", role, group, "")
+}
+
+// getGroupRoles returns the names of the roles bound to the named group.
+func getGroupRoles(tx *sqlx.Tx, group string) (roles []string, err error) {
+	err = tx.Select(&roles, `SELECT Roles.Name
+							 FROM Roles
+							 INNER JOIN Role2Group
+								 ON Roles.ID=Role2Group.RoleID
+							 INNER JOIN UserGroups
+								 ON UserGroups.ID=Role2Group.GroupID
+							 WHERE UserGroups.Name=?
+							 ORDER BY Roles.Name ASC`, group)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return roles, nil
+}
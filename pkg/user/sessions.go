@@ -0,0 +1,240 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+const (
+	// sessionTouchInterval limits how often a session's LastUsed is updated,
+	// so every page view doesn't need a write.
+	sessionTouchInterval = time.Minute
+	// maxUserAgentLength is the size of the UserAgent column.
+	maxUserAgentLength = 255
+)
+
+var (
+	// SessionIdleTimeout is how long a session may go unused before it
+	// expires. It doesn't apply to remembered sessions.
+	SessionIdleTimeout = 15 * time.Minute
+	// SessionLifetime is how long a session lasts, even if it's in use.
+	SessionLifetime = 12 * time.Hour
+	// SessionRememberLifetime is how long a remembered session lasts (i.e. the
+	// user checked "Remember me").
+	SessionRememberLifetime = 30 * 24 * time.Hour
+
+	// ErrorSessionInvalid means the session doesn't exist (e.g. it was revoked
+	// because the user logged out everywhere or changed their password), or
+	// belongs to a disabled or expired user.
+	ErrorSessionInvalid = merry.New("invalid session").
+				WithUserMessage("You have been logged out. Please login again.")
+	// ErrorSessionIdle means the session went unused for SessionIdleTimeout.
+	ErrorSessionIdle = merry.WithMessage(ErrorSessionInvalid, "session idle").
+				WithUserMessage("You were logged out due to inactivity. Please login again.")
+	// ErrorSessionExpired means the session reached its lifetime.
+	ErrorSessionExpired = merry.WithMessage(ErrorSessionInvalid, "session expired").
+				WithUserMessage("Your session has expired. Please login again.")
+)
+
+// Session is a single login, from one browser. The browser only has the
+// session's token, and only the token's hash is stored, so sessions can't be
+// stolen from the database.
+type Session struct {
+	ID        int64     `db:"ID"` // Database ID
+	UserID    int64     `db:"UserID"`
+	TokenHash string    `db:"TokenHash"`
+	Created   time.Time `db:"Created"`
+	LastUsed  time.Time `db:"LastUsed"`
+	// Expires is when the session ends, even if it's in use.
+	Expires time.Time `db:"Expires"`
+	// Remember is true if the user asked to stay logged in, in which case
+	// the session doesn't expire when idle.
+	Remember bool `db:"Remember"`
+	// IP and UserAgent are from the login, to help users recognize sessions.
+	IP        string `db:"IP"`
+	UserAgent string `db:"UserAgent"`
+}
+
+// hashSessionToken returns the hash of a session token as stored in the
+// database. Tokens are random, so a fast hash is sufficient (unlike passwords).
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSessionToken returns a new random token to give the browser.
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateSession logs the user in by creating a new session, and returns the
+// token to give their browser. Remembered sessions last for
+// SessionRememberLifetime instead of SessionLifetime, and don't expire when
+// idle.
+func CreateSession(tx *sqlx.Tx, username string, ip string, userAgent string,
+	remember bool) (token string, err error) {
+	token, err = newSessionToken()
+	if err != nil {
+		return "", err
+	}
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	now := time.Now()
+	expires := now.Add(SessionLifetime)
+	if remember {
+		expires = now.Add(SessionRememberLifetime)
+	}
+	res, err := tx.Exec(`INSERT INTO Sessions (UserID, TokenHash, Created,
+							LastUsed, Expires, Remember, IP, UserAgent)
+						 SELECT ID, ?, ?, ?, ?, ?, ?, ?
+						 FROM Users
+						 WHERE Username=?`,
+		hashSessionToken(token), now, now, expires, remember, ip, userAgent,
+		username)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	if n != 1 {
+		return "", merry.Errorf("can't create session for unknown user '%s'", username)
+	}
+	// Clean up everyone's expired sessions while we're here
+	_, err = tx.Exec(`DELETE FROM Sessions
+					  WHERE Expires<? OR (Remember=0 AND LastUsed<?)`,
+		now, now.Add(-SessionIdleTimeout))
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	return token, nil
+}
+
+// GetSession returns the username and session ID for the session token, and
+// marks the session as used so its idle timeout starts over. Returns
+// ErrorSessionIdle or ErrorSessionExpired if the session has expired, or
+// ErrorSessionInvalid if it was revoked or the user has been disabled,
+// archived, or has expired.
+func GetSession(tx *sqlx.Tx, token string) (username string, sessionID int64, err error) {
+	var session Session
+	err = tx.QueryRowx(`SELECT Users.Username, Sessions.ID, Sessions.LastUsed,
+							Sessions.Expires, Sessions.Remember
+						FROM Sessions
+						INNER JOIN Users
+							ON Sessions.UserID=Users.ID
+						WHERE Sessions.TokenHash=? AND Users.Disabled=0
+							AND (Users.ExpiresAt=? OR Users.ExpiresAt>?)
+							AND Users.ArchivedAt=?`,
+		hashSessionToken(token), MySQLZeroDate, time.Now(), MySQLZeroDate).Scan(
+		&username, &session.ID, &session.LastUsed, &session.Expires,
+		&session.Remember)
+	if err == sql.ErrNoRows {
+		return "", 0, ErrorSessionInvalid.Here()
+	} else if err != nil {
+		return "", 0, merry.Wrap(err)
+	}
+	sessionID = session.ID
+	now := time.Now()
+	if now.After(session.Expires) {
+		return "", 0, ErrorSessionExpired.Here().
+			WithMessagef("session %d for %s has expired", sessionID, username)
+	}
+	if !session.Remember && now.Sub(session.LastUsed) > SessionIdleTimeout {
+		return "", 0, ErrorSessionIdle.Here().
+			WithMessagef("session %d for %s was idle too long", sessionID, username)
+	}
+	if now.Sub(session.LastUsed) > sessionTouchInterval {
+		_, err = tx.Exec(`UPDATE Sessions SET LastUsed=? WHERE ID=?`, now, sessionID)
+		if err != nil {
+			return "", 0, merry.Wrap(err)
+		}
+	}
+	return username, sessionID, nil
+}
+
+// GetSessions returns the user's unexpired sessions, most recently used first.
+func GetSessions(tx *sqlx.Tx, userID int64) (sessions []Session, err error) {
+	now := time.Now()
+	err = tx.Select(&sessions, `SELECT *
+								FROM Sessions
+								WHERE UserID=? AND Expires>=?
+									AND (Remember=1 OR LastUsed>=?)
+								ORDER BY LastUsed DESC, ID DESC`,
+		userID, now, now.Add(-SessionIdleTimeout))
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return sessions, nil
+}
+
+// DeleteSession logs out one of the user's sessions. The user ID is required
+// so users can't revoke each other's sessions by guessing IDs.
+//
+// Logging out isn't recorded, but revoking someone else's session is.
+func DeleteSession(tx *sqlx.Tx, actor audit.Actor, userID int64, id int64) error {
+	res, err := tx.Exec(`DELETE FROM Sessions WHERE ID=? AND UserID=?`, id, userID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if n != 1 {
+		return merry.Errorf("session %d does not belong to user ID %d", id, userID).
+			WithUserMessage("That session does not exist.")
+	}
+	username, err := GetUsernameByID(tx, userID)
+	if err != nil {
+		return err
+	}
+	if actor.Username != username {
+		return audit.Record(tx, actor, "user.session_revoke", username, "",
+			"session "+strconv.FormatInt(id, 10))
+	}
+	return nil
+}
+
+// DeleteUserSessions logs the user out everywhere, and returns how many
+// sessions were revoked.
+func DeleteUserSessions(tx *sqlx.Tx, actor audit.Actor, username string) (int64, error) {
+	res, err := tx.Exec(`DELETE Sessions
+						 FROM Sessions
+						 INNER JOIN Users
+							 ON Sessions.UserID=Users.ID
+						 WHERE Users.Username=?`, username)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	if n > 0 {
+		log.Infof("revoked %d sessions for %s", n, username)
+		err = audit.Record(tx, actor, "user.sessions_revoke", username, "",
+			strconv.FormatInt(n, 10)+" sessions")
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,230 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+
+	"github.com/joshsziegler/zauth/pkg/db"
+)
+
+func TestGetSessionRejectsExpiredAndArchivedUsers(t *testing.T) {
+	database := db.SetupTestingDatabase(t, db.Config{
+		Username: "joshz",
+		Password: "Manikin06!",
+		Address:  "localhost",
+		DBName:   "zauth"},
+		"../../db-schema-v3.0.sql")
+
+	tx := db.GetTxOrFailTesting(t, database)
+	defer tx.Rollback()
+	u, err := NewUser(tx, testActor, "", "Jane", "Doe", "doe@email.com")
+	if err != nil {
+		t.Fatalf("Creating a valid user failed: \n%+v", err)
+	}
+	token, err := CreateSession(tx, u.Username, "127.0.0.1", "test", false)
+	if err != nil {
+		t.Fatalf("Creating a session failed: \n%+v", err)
+	}
+	username, _, err := GetSession(tx, token)
+	if err != nil || username != u.Username {
+		t.Fatalf("Expected a valid session for %s, not '%s': \n%+v", u.Username,
+			username, err)
+	}
+
+	// An account expiring in the future is still valid...
+	err = SetExpiresAt(tx, testActor, u.Username, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Setting expiry failed: \n%+v", err)
+	}
+	_, _, err = GetSession(tx, token)
+	if err != nil {
+		t.Errorf("Session for an unexpired user was rejected: \n%+v", err)
+	}
+	// ...but not once it's passed, even if it hasn't been disabled yet
+	err = SetExpiresAt(tx, testActor, u.Username, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Setting expiry failed: \n%+v", err)
+	}
+	_, _, err = GetSession(tx, token)
+	if !merry.Is(err, ErrorSessionInvalid) {
+		t.Errorf("Session for an expired user didn't return ErrorSessionInvalid: \n%+v", err)
+	}
+
+	// Archived users are rejected, even if they aren't disabled
+	err = SetExpiresAt(tx, testActor, u.Username, time.Time{})
+	if err != nil {
+		t.Fatalf("Clearing expiry failed: \n%+v", err)
+	}
+	_, err = tx.Exec(`UPDATE Users SET ArchivedAt=? WHERE ID=?`, time.Now(), u.ID)
+	if err != nil {
+		t.Fatalf("Archiving failed: \n%+v", err)
+	}
+	_, _, err = GetSession(tx, token)
+	if !merry.Is(err, ErrorSessionInvalid) {
+		t.Errorf("Session for an archived user didn't return ErrorSessionInvalid: \n%+v", err)
+	}
+}
+
+func TestCreateAndDeleteSession(t *testing.T) {
+	database := db.SetupTestingDatabase(t, db.Config{
+		Username: "joshz",
+		Password: "Manikin06!",
+		Address:  "localhost",
+		DBName:   "zauth"},
+		"../../db-schema-v3.0.sql")
+
+	tx := db.GetTxOrFailTesting(t, database)
+	defer tx.Rollback()
+	u, err := NewUser(tx, testActor, "", "Jane", "Doe", "doe@email.com")
+	if err != nil {
+		t.Fatalf("Creating a valid user failed: \n%+v", err)
+	}
+	other, err := NewUser(tx, testActor, "", "John", "Doe", "john@email.com")
+	if err != nil {
+		t.Fatalf("Creating a valid user failed: \n%+v", err)
+	}
+	_, err = CreateSession(tx, "nobody", "127.0.0.1", "test", false)
+	if err == nil {
+		t.Errorf("Creating a session for an unknown user didn't fail")
+	}
+	token, err := CreateSession(tx, u.Username, "127.0.0.1", "test", false)
+	if err != nil {
+		t.Fatalf("Creating a session failed: \n%+v", err)
+	}
+	username, sessionID, err := GetSession(tx, token)
+	if err != nil || username != u.Username {
+		t.Fatalf("Expected a valid session for %s, not '%s': \n%+v", u.Username,
+			username, err)
+	}
+	_, _, err = GetSession(tx, "not-a-token")
+	if !merry.Is(err, ErrorSessionInvalid) {
+		t.Errorf("An unknown token didn't return ErrorSessionInvalid: \n%+v", err)
+	}
+
+	// Users can't revoke each other's sessions...
+	err = DeleteSession(tx, testActor, other.ID, sessionID)
+	if err == nil {
+		t.Errorf("Deleting another user's session didn't fail")
+	}
+	_, _, err = GetSession(tx, token)
+	if err != nil {
+		t.Errorf("Session was revoked by another user: \n%+v", err)
+	}
+	// ...only their own
+	err = DeleteSession(tx, testActor, u.ID, sessionID)
+	if err != nil {
+		t.Fatalf("Deleting a session failed: \n%+v", err)
+	}
+	_, _, err = GetSession(tx, token)
+	if !merry.Is(err, ErrorSessionInvalid) {
+		t.Errorf("Deleted session didn't return ErrorSessionInvalid: \n%+v", err)
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	database := db.SetupTestingDatabase(t, db.Config{
+		Username: "joshz",
+		Password: "Manikin06!",
+		Address:  "localhost",
+		DBName:   "zauth"},
+		"../../db-schema-v3.0.sql")
+
+	tx := db.GetTxOrFailTesting(t, database)
+	defer tx.Rollback()
+	u, err := NewUser(tx, testActor, "", "Jane", "Doe", "doe@email.com")
+	if err != nil {
+		t.Fatalf("Creating a valid user failed: \n%+v", err)
+	}
+	token, err := CreateSession(tx, u.Username, "127.0.0.1", "test", false)
+	if err != nil {
+		t.Fatalf("Creating a session failed: \n%+v", err)
+	}
+	rememberToken, err := CreateSession(tx, u.Username, "127.0.0.1", "test", true)
+	if err != nil {
+		t.Fatalf("Creating a remembered session failed: \n%+v", err)
+	}
+	expiredToken, err := CreateSession(tx, u.Username, "127.0.0.1", "test", true)
+	if err != nil {
+		t.Fatalf("Creating a remembered session failed: \n%+v", err)
+	}
+	_, expiredID, err := GetSession(tx, expiredToken)
+	if err != nil {
+		t.Fatalf("Expected a valid session: \n%+v", err)
+	}
+
+	// Only sessions that weren't remembered expire when idle...
+	idle := time.Now().Add(-SessionIdleTimeout - time.Minute)
+	_, err = tx.Exec(`UPDATE Sessions SET LastUsed=? WHERE UserID=?`, idle, u.ID)
+	if err != nil {
+		t.Fatalf("Idling sessions failed: \n%+v", err)
+	}
+	_, _, err = GetSession(tx, token)
+	if !merry.Is(err, ErrorSessionIdle) {
+		t.Errorf("Idle session didn't return ErrorSessionIdle: \n%+v", err)
+	}
+	_, _, err = GetSession(tx, rememberToken)
+	if err != nil {
+		t.Errorf("Idle remembered session was rejected: \n%+v", err)
+	}
+	// ...but every session expires at the end of its lifetime, even if in use
+	_, err = tx.Exec(`UPDATE Sessions SET Expires=? WHERE ID=?`,
+		time.Now().Add(-time.Minute), expiredID)
+	if err != nil {
+		t.Fatalf("Expiring session failed: \n%+v", err)
+	}
+	_, _, err = GetSession(tx, expiredToken)
+	if !merry.Is(err, ErrorSessionExpired) {
+		t.Errorf("Expired session didn't return ErrorSessionExpired: \n%+v", err)
+	}
+}
+
+func TestSessionsRevoked(t *testing.T) {
+	database := db.SetupTestingDatabase(t, db.Config{
+		Username: "joshz",
+		Password: "Manikin06!",
+		Address:  "localhost",
+		DBName:   "zauth"},
+		"../../db-schema-v3.0.sql")
+
+	tx := db.GetTxOrFailTesting(t, database)
+	defer tx.Rollback()
+	newTestAdmin(t, tx)
+	u, err := NewUser(tx, testActor, "", "Jane", "Doe", "doe@email.com")
+	if err != nil {
+		t.Fatalf("Creating a valid user failed: \n%+v", err)
+	}
+
+	// Changing their password logs them out everywhere...
+	token, err := CreateSession(tx, u.Username, "127.0.0.1", "test", true)
+	if err != nil {
+		t.Fatalf("Creating a session failed: \n%+v", err)
+	}
+	err = SetUserPassword(tx, testActor, u.Username, "copper kettle sings at dawn 41")
+	if err != nil {
+		t.Fatalf("Setting a new password failed: \n%+v", err)
+	}
+	_, _, err = GetSession(tx, token)
+	if !merry.Is(err, ErrorSessionInvalid) {
+		t.Errorf("Session survived a password change: \n%+v", err)
+	}
+
+	// ...as does disabling them, and they stay logged out once re-enabled
+	token, err = CreateSession(tx, u.Username, "127.0.0.1", "test", true)
+	if err != nil {
+		t.Fatalf("Creating a session failed: \n%+v", err)
+	}
+	err = UserDisable(tx, testActor, u.Username)
+	if err != nil {
+		t.Fatalf("Disabling the user failed: \n%+v", err)
+	}
+	err = UserEnable(tx, testActor, u.Username)
+	if err != nil {
+		t.Fatalf("Enabling the user failed: \n%+v", err)
+	}
+	_, _, err = GetSession(tx, token)
+	if !merry.Is(err, ErrorSessionInvalid) {
+		t.Errorf("Session survived disabling the user: \n%+v", err)
+	}
+}
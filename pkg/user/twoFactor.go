@@ -0,0 +1,280 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	"github.com/joshsziegler/zauth/pkg/secrets"
+	"github.com/joshsziegler/zauth/pkg/totp"
+	"github.com/joshsziegler/zgo/pkg/log"
+)
+
+const (
+	// RecoveryCodeCount is how many one-time recovery codes a user is given.
+	RecoveryCodeCount = 10
+)
+
+var (
+	ErrorTOTPAlreadyEnabled = merry.New("two-factor authentication is already enabled").
+				WithUserMessage("Two-factor authentication is already enabled.")
+	ErrorTOTPNotEnabled = merry.New("two-factor authentication is not enabled").
+				WithUserMessage("Two-factor authentication is not enabled.")
+	ErrorTOTPInvalidCode = merry.New("invalid two-factor code").
+				WithUserMessage("That code is invalid or has already been used.")
+	// recoveryCodeEncoding is lowercase base32 without padding, which avoids
+	// ambiguous characters such as 0/O and 1/l.
+	recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").
+				WithPadding(base32.NoPadding)
+)
+
+// BeginTOTPEnrollment returns the user's pending TOTP secret in plaintext so it
+// can be shown to them, creating one if they haven't started enrollment yet.
+//
+// The secret is only stored in the database encrypted, and is not used for
+// logins until they confirm it with ConfirmTOTPEnrollment.
+func BeginTOTPEnrollment(tx *sqlx.Tx, username string) (secret string, err error) {
+	var encrypted string
+	var enabled bool
+	err = tx.QueryRowx(`SELECT TOTPSecret, TOTPEnabled
+						FROM Users
+						WHERE Username=?`, username).Scan(&encrypted, &enabled)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	if enabled {
+		return "", ErrorTOTPAlreadyEnabled.Here()
+	}
+	// Reuse the pending secret, so reloading the page doesn't invalidate the
+	// QR code they may have already scanned
+	if encrypted != "" {
+		secret, err = secrets.Decrypt(secrets.TOTPKey(), encrypted)
+		if err == nil {
+			return secret, nil
+		}
+		log.Errorf("discarding undecryptable TOTP secret for %s: %s", username, err)
+	}
+	secret, err = totp.NewSecret()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err = secrets.Encrypt(secrets.TOTPKey(), secret)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`UPDATE Users
+					  SET TOTPSecret=?, TOTPEnabled=0, TOTPLastStep=0
+					  WHERE Username=?`, encrypted, username)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	return secret, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication IFF the code matches
+// the user's pending secret. It returns a new set of recovery codes, which
+// should be shown to the user exactly once.
+func ConfirmTOTPEnrollment(tx *sqlx.Tx, actor audit.Actor, username string, code string) (
+	recoveryCodes []string, err error) {
+	var userID int64
+	var encrypted string
+	var enabled bool
+	err = tx.QueryRowx(`SELECT ID, TOTPSecret, TOTPEnabled
+						FROM Users
+						WHERE Username=?`, username).Scan(&userID, &encrypted, &enabled)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if enabled {
+		return nil, ErrorTOTPAlreadyEnabled.Here()
+	}
+	if encrypted == "" {
+		return nil, ErrorTOTPNotEnabled.Here()
+	}
+	secret, err := secrets.Decrypt(secrets.TOTPKey(), encrypted)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, ErrorTOTPInvalidCode.Here()
+	}
+	_, err = tx.Exec(`UPDATE Users
+					  SET TOTPEnabled=1, TOTPLastStep=?
+					  WHERE ID=?`, step, userID)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	recoveryCodes, err = setRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = audit.Record(tx, actor, "user.2fa_enable", username, "", "")
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("enabled two-factor authentication for %s", username)
+	return recoveryCodes, nil
+}
+
+// DisableTOTP turns off two-factor authentication for the user, and removes
+// their secret and recovery codes.
+func DisableTOTP(tx *sqlx.Tx, actor audit.Actor, username string) error {
+	var userID int64
+	err := tx.Get(&userID, `SELECT ID FROM Users WHERE Username=?`, username)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`UPDATE Users
+					  SET TOTPSecret='', TOTPEnabled=0, TOTPLastStep=0
+					  WHERE ID=?`, userID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	_, err = tx.Exec(`DELETE FROM RecoveryCodes WHERE UserID=?`, userID)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	err = audit.Record(tx, actor, "user.2fa_disable", username, "", "")
+	if err != nil {
+		return err
+	}
+	log.Infof("disabled two-factor authentication for %s", username)
+	return nil
+}
+
+// CheckSecondFactor returns nil IFF the code is a valid TOTP code for this
+// user, or one of their unused recovery codes. Each TOTP code and recovery code
+// can only be used once.
+//
+// Invalid codes and used recovery codes are recorded, so the caller MUST commit
+// the transaction even if the code was invalid.
+func CheckSecondFactor(tx *sqlx.Tx, actor audit.Actor, username string, code string) error {
+	var userID, lastStep int64
+	var encrypted string
+	var enabled bool
+	err := tx.QueryRowx(`SELECT ID, TOTPSecret, TOTPEnabled, TOTPLastStep
+						 FROM Users
+						 WHERE Username=?`, username).
+		Scan(&userID, &encrypted, &enabled, &lastStep)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !enabled {
+		return ErrorTOTPNotEnabled.Here()
+	}
+	secret, err := secrets.Decrypt(secrets.TOTPKey(), encrypted)
+	if err != nil {
+		return err
+	}
+	// 1. Try the code as a TOTP code, rejecting any we've already accepted
+	step, ok := totp.Validate(secret, code, time.Now())
+	if ok && step > lastStep {
+		_, err = tx.Exec(`UPDATE Users SET TOTPLastStep=? WHERE ID=?`, step, userID)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		return nil
+	}
+	// 2. Try the code as a recovery code, marking it used if it matches
+	res, err := tx.Exec(`UPDATE RecoveryCodes
+						 SET Used=1
+						 WHERE UserID=? AND CodeHash=? AND Used=0`,
+		userID, hashRecoveryCode(code))
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if n == 1 {
+		log.Infof("%s logged in using a recovery code", username)
+		return audit.Record(tx, actor, "auth.recovery_code", username, "", "")
+	}
+	err = audit.Record(tx, actor, "auth.2fa_failed", username, "", "")
+	if err != nil {
+		return err
+	}
+	return ErrorTOTPInvalidCode.Here().WithMessagef("invalid two-factor code for '%s'", username)
+}
+
+// RecordSecondFactorFailure records a failed attempt to use a second factor
+// which CheckSecondFactor doesn't check (i.e. a passkey), using the method.
+func RecordSecondFactorFailure(tx *sqlx.Tx, actor audit.Actor, username string,
+	method string) error {
+	return audit.Record(tx, actor, "auth.2fa_failed", username, "", method)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with new ones.
+func RegenerateRecoveryCodes(tx *sqlx.Tx, actor audit.Actor, username string) ([]string, error) {
+	var userID int64
+	var enabled bool
+	err := tx.QueryRowx(`SELECT ID, TOTPEnabled
+						 FROM Users
+						 WHERE Username=?`, username).Scan(&userID, &enabled)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if !enabled {
+		return nil, ErrorTOTPNotEnabled.Here()
+	}
+	err = audit.Record(tx, actor, "user.recovery_codes", username, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return setRecoveryCodes(tx, userID)
+}
+
+// GetUnusedRecoveryCodeCount returns how many recovery codes the user has left.
+func GetUnusedRecoveryCodeCount(tx *sqlx.Tx, userID int64) (count int, err error) {
+	err = tx.Get(&count, `SELECT COUNT(*)
+						  FROM RecoveryCodes
+						  WHERE UserID=? AND Used=0`, userID)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	return count, nil
+}
+
+// setRecoveryCodes deletes any existing recovery codes for the user, and
+// creates and returns new ones. Only the hashes are stored.
+func setRecoveryCodes(tx *sqlx.Tx, userID int64) (codes []string, err error) {
+	_, err = tx.Exec(`DELETE FROM RecoveryCodes WHERE UserID=?`, userID)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		code := recoveryCodeEncoding.EncodeToString(b)[:10]
+		code = code[:5] + "-" + code[5:]
+		_, err = tx.Exec(`INSERT INTO RecoveryCodes (UserID, CodeHash)
+						  VALUES (?, ?)`, userID, hashRecoveryCode(code))
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code. These are random and
+// single-use, so a fast hash is sufficient (unlike passwords).
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
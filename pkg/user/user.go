@@ -2,13 +2,14 @@ package user
 
 import (
 	"fmt"
-	"regexp"
-	"strings"
 	"time"
 
 	"github.com/ansel1/merry"
 	_ "github.com/go-sql-driver/mysql" // Blank import required for SQL drivers
 	"github.com/jmoiron/sqlx"
+
+	"github.com/joshsziegler/zauth/pkg/audit"
+	pw "github.com/joshsziegler/zauth/pkg/password"
 )
 
 const (
@@ -19,19 +20,27 @@ const (
 )
 
 var (
-	reBadChars         = regexp.MustCompile("[^a-zA-Z0-9]+")
 	ErrorLogin         = merry.New("login error")
 	ErrorLoginDisabled = merry.WithMessage(ErrorLogin, "account disabled")
 	ErrorLoginPassword = merry.WithMessage(ErrorLogin, "wrong password")
+	ErrorLoginExpired  = merry.WithMessage(ErrorLogin, "account expired")
+	// ErrorPasswordExpired means the password was correct, but is older than
+	// their password policy's MaxAgeDays. It isn't an ErrorLogin, since the web UI lets
+	// them login so they can change it.
+	ErrorPasswordExpired = merry.New("password expired").
+				WithUserMessage("Your password has expired. Please choose a new one.")
 )
 
 // User represents an LDAP user's attributes and group membership
 //
 // Assumptions:
 //   - Database IDs, and Usernames MUST be unique, and will NEVER change.
-//   - Only admins can create new users, change groups, and enable/disable users
-//   - Enabled means that user can perform LDAP BIND operations. Disabled users
-//     can still login to this website to see and change their info however.
+//   - Only users with the right Permission can create new users, change
+//     groups, and enable/disable users (see permissions.go)
+//   - Enabled means that user can perform LDAP BIND operations and login to
+//     this website. Disabling a user also revokes their sessions.
+//   - Expired users can't login either, even before ExpireAccounts disables
+//     them.
 //   - A user's UnixUserID and UnixGroupID are ALWAYS their DB ID + 1000.
 type User struct {
 	ID       int64  `db:"ID"` // Database ID
@@ -46,12 +55,41 @@ type User struct {
 	PasswordHash string `db:"PasswordHash"` // SQL Default: '-'
 	// Date and time when was this password last set or changed.
 	PasswordSet time.Time `db:"PasswordSet"` // SQL Default: 0001-01-01 00:00:00
+	// PasswordExpiryWarned is true once the user was reminded that their
+	// password will soon expire. It's reset when their password changes.
+	PasswordExpiryWarned bool `db:"PasswordExpiryWarned"`
 	// Date and time when this user last logged in.
 	LastLogin time.Time `db:"LastLogin"` // SQL Default: 0001-01-01 00:00:00
-	// If disabled, LDAP binds for this account will fail. Logins to zauth's
-	// user management page will continue to work however!
+	// If disabled, LDAP binds and logins for this account will fail.
 	Disabled bool `db:"Disabled"` // If true, don't allow to login
-	Groups   []string
+	// ExpiresAt is when this account stops working. Zero means never.
+	ExpiresAt time.Time `db:"ExpiresAt"` // SQL Default: 0001-01-01 00:00:00
+	// ExpiryWarned is true once the user and admins were warned that this
+	// account will soon expire. It's reset when ExpiresAt changes.
+	ExpiryWarned bool `db:"ExpiryWarned"`
+	// ArchivedAt is when this account was archived (see ArchiveUser). Zero
+	// means it isn't archived.
+	ArchivedAt time.Time `db:"ArchivedAt"` // SQL Default: 0001-01-01 00:00:00
+	// TOTPSecret is the user's TOTP secret, encrypted using secrets.TOTPKey().
+	// It's set when they begin enrollment, and cleared if they disable 2FA.
+	TOTPSecret string `db:"TOTPSecret"` // SQL Default: ''
+	// TOTPEnabled is true once the user has confirmed enrollment with a code.
+	TOTPEnabled bool `db:"TOTPEnabled"`
+	// TOTPLastStep is the last TOTP time step accepted, to prevent code reuse.
+	TOTPLastStep int64 `db:"TOTPLastStep"`
+	Groups       []string
+	// TwoFactorRequired is true if any of the user's groups require 2FA.
+	// This is only populated by GetUserWithGroups.
+	TwoFactorRequired bool
+	// HasPasskeys is true if the user has registered at least one passkey.
+	// This is only populated by GetUserWithGroups.
+	HasPasskeys bool
+	// Permissions holds every permission granted to this user by the roles
+	// bound to their groups. This is only populated by GetUserWithGroups.
+	Permissions map[Permission]bool
+	// ManagedGroups maps the name of each group this user owns or manages to
+	// their role in it. This is only populated by GetUserWithGroups.
+	ManagedGroups map[string]string
 }
 
 // CommonName is the user's full name (returns the first and last names).
@@ -88,37 +126,111 @@ func (u User) HomeDirectory() string {
 	return fmt.Sprintf("/home/%s", u.Username)
 }
 
-// IsAdmin returns true if this User belongs to a group named 'admin'.
+// HasSecondFactor returns true if the user has enrolled in at least one form of
+// two-factor authentication (an authenticator app or a passkey).
 //
 // ** Doesn't use a pointer to `u` so it can be use in HTML templates.
-func (u User) IsAdmin() bool {
-	for _, name := range u.Groups {
-		if strings.ToLower(name) == "admin" {
-			return true
-		}
+func (u User) HasSecondFactor() bool {
+	return u.TOTPEnabled || u.HasPasskeys
+}
+
+// Expired returns true if the account has an expiration date which has passed.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) Expired() bool {
+	return isExpired(u.ExpiresAt, time.Now())
+}
+
+// Archived returns true if the account was archived (see ArchiveUser).
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) Archived() bool {
+	return !u.ArchivedAt.IsZero()
+}
+
+// PasswordPolicy returns the rules for the user's passwords, which depend on
+// their Groups (so they must be loaded, e.g. by GetUserWithGroups).
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) PasswordPolicy() pw.Policy {
+	return pw.PolicyFor(u.Groups)
+}
+
+// PasswordExpiresAt returns when the user's password expires, or zero if it
+// doesn't (see passwordExpiresAt). Like PasswordPolicy, this needs their
+// Groups.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) PasswordExpiresAt() time.Time {
+	return passwordExpiresAt(u.PasswordSet, u.PasswordPolicy().MaxAgeDays)
+}
+
+// PasswordExpired returns true if the user's password is older than their
+// password policy's MaxAgeDays, and must be changed.
+//
+// ** Doesn't use a pointer to `u` so it can be use in HTML templates.
+func (u User) PasswordExpired() bool {
+	return isExpired(u.PasswordExpiresAt(), time.Now())
+}
+
+// passwordExpiresAt returns when a password set at this time expires, if
+// passwords may be used for maxAgeDays. Returns zero if passwords don't expire,
+// or if we don't know when it was set (i.e. accounts older than PasswordSet).
+func passwordExpiresAt(passwordSet time.Time, maxAgeDays int) time.Time {
+	if maxAgeDays <= 0 || passwordSet.IsZero() {
+		return time.Time{}
 	}
-	return false
+	return passwordSet.AddDate(0, 0, maxAgeDays)
+}
+
+// isExpired returns true if expiresAt is set and is not after now.
+func isExpired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
 }
 
 // userSetEnable is a helper function for UserEnable and UserDisable.
 //
 // Note that isEnabled is flipped because the database uses Disabled!
-func userSetEnable(tx *sqlx.Tx, isEnabled bool, username string) error {
+func userSetEnable(tx *sqlx.Tx, actor audit.Actor, isEnabled bool, username string) error {
 	_, err := tx.Exec(`UPDATE Users
 		 			  SET Disabled=?
 		 			  WHERE Username=?`, !isEnabled, username)
 	if err != nil {
 		return merry.Wrap(err)
 	}
-	return nil
+	action := "user.enable"
+	if !isEnabled {
+		action = "user.disable"
+		_, err = DeleteUserSessions(tx, actor, username)
+		if err != nil {
+			return err
+		}
+	}
+	return audit.Record(tx, actor, action, username, "", "")
 }
 
-func UserEnable(tx *sqlx.Tx, username string) (err error) {
-	return userSetEnable(tx, true, username)
+// UserEnable lets the user login again. Archived users must be restored first
+// (see RestoreUser).
+func UserEnable(tx *sqlx.Tx, actor audit.Actor, username string) (err error) {
+	var archivedAt time.Time
+	err = tx.Get(&archivedAt, `SELECT ArchivedAt FROM Users WHERE Username=?`, username)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !archivedAt.IsZero() {
+		return ErrorUserArchived.Here()
+	}
+	return userSetEnable(tx, actor, true, username)
 }
 
-func UserDisable(tx *sqlx.Tx, username string) (err error) {
-	return userSetEnable(tx, false, username)
+// UserDisable disables the user. Returns ErrorNoRoleManagers if nobody would be
+// left with PermRolesManage.
+func UserDisable(tx *sqlx.Tx, actor audit.Actor, username string) (err error) {
+	err = userSetEnable(tx, actor, false, username)
+	if err != nil {
+		return err
+	}
+	return checkRoleManagersRemain(tx)
 }
 
 // GetUserWithGroups returns a single User struct, including the groups they
@@ -129,7 +241,7 @@ func GetUserWithGroups(tx *sqlx.Tx, username string) (user User, err error) {
 		return User{}, merry.Wrap(err)
 	}
 	// Get the name of each group this user belongs to
-	rows, err := tx.Queryx(`SELECT UserGroups.Name
+	rows, err := tx.Queryx(`SELECT UserGroups.Name, UserGroups.Require2FA
 							FROM UserGroups
 							INNER JOIN User2Group
 								ON UserGroups.ID=User2Group.GroupID
@@ -140,20 +252,40 @@ func GetUserWithGroups(tx *sqlx.Tx, username string) (user User, err error) {
 	}
 	defer rows.Close()
 	var groupName string
+	var require2FA bool
 	for rows.Next() {
-		err = rows.Scan(&groupName)
+		err = rows.Scan(&groupName, &require2FA)
 		if err != nil {
 			return User{}, merry.Wrap(err)
 		}
 		user.Groups = append(user.Groups, groupName)
+		if require2FA {
+			user.TwoFactorRequired = true
+		}
+	}
+	err = tx.Get(&user.HasPasskeys, `SELECT COUNT(*)>0 FROM Passkeys WHERE UserID=?`,
+		user.ID)
+	if err != nil {
+		return User{}, merry.Wrap(err)
+	}
+	user.ManagedGroups, err = getManagedGroups(tx, user.ID)
+	if err != nil {
+		return User{}, err
+	}
+	user.Permissions, err = getUserPermissions(tx, user.ID)
+	if err != nil {
+		return User{}, err
 	}
 	return
 }
 
 // GetUsersWithoutGroups returns a map of users, stored by their database ID.
-func GetUsersMapWithoutGroups(tx *sqlx.Tx) (users map[int64]*(User), err error) {
+// Archived users are only included if includeArchived is true.
+func GetUsersMapWithoutGroups(tx *sqlx.Tx, includeArchived bool) (users map[int64]*(User),
+	err error) {
 	users = make(map[int64]*(User))
-	rows, err := tx.Queryx(`SELECT * FROM Users`)
+	rows, err := tx.Queryx(`SELECT * FROM Users WHERE ?=1 OR ArchivedAt=?`,
+		includeArchived, MySQLZeroDate)
 	if err != nil {
 		return nil, err
 	}
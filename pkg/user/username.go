@@ -0,0 +1,202 @@
+package user
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// DefaultUsernamePattern is used to make new usernames, unless
+	// Config.UsernamePattern is set.
+	DefaultUsernamePattern = "first.last"
+	// maxUsernameLength is the longest username reValidName allows.
+	maxUsernameLength = 32
+)
+
+var (
+	// ErrorUsernameInvalid indicates a custom username doesn't match
+	// reValidName.
+	ErrorUsernameInvalid = merry.New("invalid username").
+				WithUserMessage("Usernames must be 1-32 characters, start with a " +
+			"lowercase letter or digit, and only contain lowercase letters, digits, " +
+			"periods, underscores, and hyphens.")
+	// ErrorUsernameTaken indicates a custom username already belongs to
+	// someone, or belonged to a deleted user.
+	ErrorUsernameTaken = merry.New("username already exists").
+				WithUserMessage("That username belongs to another user, or to a deleted user.")
+	// ErrorUsernameGenerate indicates their name has nothing we can use in a
+	// username (e.g. it's written in a script we can't transliterate).
+	ErrorUsernameGenerate = merry.New("cannot make a username from their name").
+				WithUserMessage("A username could not be made from their name, so please enter one.")
+
+	// usernamePattern is the parsed Config.UsernamePattern (see
+	// parseUsernamePattern).
+	usernamePattern = mustParseUsernamePattern(DefaultUsernamePattern)
+	// usernameTokens are the words in a UsernamePattern which are replaced by
+	// their name. Longer tokens are first, so "first" isn't read as "f" +
+	// "irst".
+	usernameTokens = []string{"first", "last", "f", "l"}
+)
+
+// parseUsernamePattern splits a pattern into tokens and separators, where
+// tokens are replaced by part of their name:
+//
+//	first  Their whole first name
+//	last   Their whole last name
+//	f      The first letter of their first name
+//	l      The first letter of their last name
+//
+// and separators are a period, underscore, or hyphen. For example,
+// "first.last" makes john.smith, and "flast" makes jsmith.
+func parseUsernamePattern(pattern string) (parts []string, err error) {
+	hasToken := false
+	for rest := pattern; rest != ""; {
+		part := ""
+		for _, token := range usernameTokens {
+			if strings.HasPrefix(rest, token) {
+				part = token
+				hasToken = true
+				break
+			}
+		}
+		if part == "" && strings.ContainsAny(rest[:1], "._-") {
+			part = rest[:1]
+		}
+		if part == "" {
+			return nil, merry.Errorf("invalid UsernamePattern '%s': unexpected '%s'",
+				pattern, rest)
+		}
+		parts = append(parts, part)
+		rest = rest[len(part):]
+	}
+	if !hasToken {
+		return nil, merry.Errorf("invalid UsernamePattern '%s': must contain first, "+
+			"last, f, or l", pattern)
+	}
+	if strings.ContainsAny(pattern[:1], "._-") {
+		return nil, merry.Errorf("invalid UsernamePattern '%s': cannot start with "+
+			"a separator", pattern)
+	}
+	return parts, nil
+}
+
+// mustParseUsernamePattern is parseUsernamePattern, for our default.
+func mustParseUsernamePattern(pattern string) []string {
+	parts, err := parseUsernamePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return parts
+}
+
+// usernameBase returns the username the pattern makes from their name, before
+// any number is added to make it unique (see newUsername).
+func usernameBase(firstName string, lastName string) (string, error) {
+	first, last := transliterate(firstName), transliterate(lastName)
+	var sb strings.Builder
+	for _, part := range usernamePattern {
+		var value string
+		switch part {
+		case "first":
+			value = first
+		case "last":
+			value = last
+		case "f":
+			value = first[:min(1, len(first))]
+		case "l":
+			value = last[:min(1, len(last))]
+		default:
+			sb.WriteString(part) // A separator
+			continue
+		}
+		if value == "" {
+			return "", ErrorUsernameGenerate.Here()
+		}
+		sb.WriteString(value)
+	}
+	username := truncateUsername(sb.String(), maxUsernameLength)
+	if !reValidName.MatchString(username) {
+		return "", ErrorUsernameGenerate.Here()
+	}
+	return username, nil
+}
+
+// truncateUsername returns at most length bytes of the username, without a
+// trailing separator.
+func truncateUsername(username string, length int) string {
+	if len(username) > length {
+		username = strings.TrimRight(username[:length], "._-")
+	}
+	return username
+}
+
+// newUsername returns an unused username for a new user, made from their name
+// using the configured pattern (see Config.UsernamePattern). If that's taken,
+// the lowest free number starting at 2 is added (e.g. john.smith2).
+func newUsername(tx *sqlx.Tx, firstName string, lastName string) (string, error) {
+	base, err := usernameBase(firstName, lastName)
+	if err != nil {
+		return "", err
+	}
+	username := base
+	for n := 2; ; n++ {
+		taken, err := usernameTaken(tx, username)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return username, nil
+		}
+		suffix := strconv.Itoa(n)
+		username = truncateUsername(base, maxUsernameLength-len(suffix)) + suffix
+	}
+}
+
+// checkCustomUsername returns an error if the username an admin chose is
+// invalid or already taken.
+func checkCustomUsername(tx *sqlx.Tx, username string) error {
+	if !reValidName.MatchString(username) {
+		return ErrorUsernameInvalid.Here()
+	}
+	taken, err := usernameTaken(tx, username)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrorUsernameTaken.Here()
+	}
+	return nil
+}
+
+// usernameTaken returns true if a user already has the username, or had it
+// before they were deleted (see DeleteUser).
+func usernameTaken(tx *sqlx.Tx, username string) (taken bool, err error) {
+	err = tx.Get(&taken, `SELECT EXISTS(SELECT 1 FROM Users WHERE Username=?) OR
+								 EXISTS(SELECT 1 FROM ReservedUsers WHERE Username=?)`,
+		username, username)
+	if err != nil {
+		return false, merry.Wrap(err)
+	}
+	return taken, nil
+}
+
+// transliterate returns the name in lowercase ASCII letters and digits, for use
+// in a username. Accented and other Latin letters are replaced with their
+// closest ASCII letters (e.g. "José" becomes "jose", and "Ærøskøbing" becomes
+// "aeroskobing"), as is Greek and Cyrillic. Anything else is removed,
+// including spaces, hyphens, and apostrophes.
+func transliterate(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9':
+			sb.WriteRune(r)
+		default:
+			sb.WriteString(transliterations[r])
+		}
+	}
+	return sb.String()
+}
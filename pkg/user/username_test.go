@@ -0,0 +1,68 @@
+package user
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ansel1/merry"
+)
+
+func TestUsernameBase(t *testing.T) {
+	defer Init(Config{})
+	tests := []struct {
+		pattern   string
+		firstName string
+		lastName  string
+		expected  string
+	}{
+		{"first.last", "John", "Smith", "john.smith"},
+		{"flast", "John", "Smith", "jsmith"},
+		{"firstl", "John", "Smith", "johns"},
+		{"last_first", "John", "Smith", "smith_john"},
+		{"f.l-last", "John", "Smith", "j.s-smith"},
+		{"first.last", "José", "Núñez-García", "jose.nunezgarcia"},
+		{"first.last", "Søren", "Kierkegård", "soren.kierkegard"},
+		{"first.last", "Ægir", "O'Brien", "aegir.obrien"},
+		{"first.last", "Юрий", "Гагарин", "yuriy.gagarin"},
+		{"flast", "Mary Ann", "Ĳsselmeer", "mijsselmeer"},
+		{"first.last", strings.Repeat("a", 20), strings.Repeat("b", 20),
+			strings.Repeat("a", 20) + "." + strings.Repeat("b", 11)},
+		{"first.last", strings.Repeat("a", 31), "b", strings.Repeat("a", 31)},
+	}
+	for _, test := range tests {
+		if err := Init(Config{UsernamePattern: test.pattern}); err != nil {
+			t.Fatal(err)
+		}
+		username, err := usernameBase(test.firstName, test.lastName)
+		if err != nil || username != test.expected {
+			t.Errorf("%s for %s %s returned %s, %v, expected %s", test.pattern,
+				test.firstName, test.lastName, username, err, test.expected)
+		}
+	}
+	Init(Config{})
+	_, err := usernameBase("明", "王")
+	if !merry.Is(err, ErrorUsernameGenerate) {
+		t.Errorf("names without Latin letters returned %v", err)
+	}
+}
+
+func TestInitInvalidUsernamePattern(t *testing.T) {
+	defer Init(Config{})
+	for _, pattern := range []string{"first last", ".first", "._-", "name"} {
+		if err := Init(Config{UsernamePattern: pattern}); err == nil {
+			t.Errorf("pattern '%s' should be invalid", pattern)
+		}
+	}
+}
+
+func TestCleanNamesKeepsAccents(t *testing.T) {
+	firstName, lastName, err := cleanNames(" José ", "Núñez-García")
+	if err != nil || firstName != "José" || lastName != "Núñez-García" {
+		t.Errorf("cleanNames returned '%s', '%s', %v", firstName, lastName, err)
+	}
+	for _, name := range []string{"-", "Bad\nName"} {
+		if _, _, err = cleanNames(name, "Smith"); err == nil {
+			t.Errorf("'%s' should be an invalid name", name)
+		}
+	}
+}
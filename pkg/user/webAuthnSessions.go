@@ -0,0 +1,76 @@
+package user
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/jmoiron/sqlx"
+)
+
+// WebAuthnSessionTimeout is how long the user has to finish a WebAuthn
+// ceremony (registering or using a passkey) after it begins.
+const WebAuthnSessionTimeout = 5 * time.Minute
+
+var (
+	// ErrorWebAuthnSessionInvalid means there's no WebAuthn ceremony for the
+	// token, or it has expired or already been used.
+	ErrorWebAuthnSessionInvalid = merry.New("invalid WebAuthn session").
+		WithUserMessage("Your request expired. Please try again.")
+)
+
+// SaveWebAuthnSession stores a WebAuthn ceremony's data (including its
+// challenge) until it's finished, and returns the token to give the browser.
+// Keeping the data here instead of in the browser's cookie means each
+// challenge can only be used once (see TakeWebAuthnSession).
+func SaveWebAuthnSession(tx *sqlx.Tx, data []byte) (token string, err error) {
+	token, err = newSessionToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = tx.Exec(`INSERT INTO WebAuthnSessions (TokenHash, Created, Data)
+					  VALUES (?,?,?)`, hashSessionToken(token), now, data)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	// Clean up abandoned ceremonies while we're here
+	_, err = tx.Exec(`DELETE FROM WebAuthnSessions WHERE Created<?`,
+		now.Add(-WebAuthnSessionTimeout))
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	return token, nil
+}
+
+// TakeWebAuthnSession returns and deletes the WebAuthn ceremony's data for the
+// token. Returns ErrorWebAuthnSessionInvalid if there isn't one, or it has
+// expired.
+func TakeWebAuthnSession(tx *sqlx.Tx, token string) (data []byte, err error) {
+	tokenHash := hashSessionToken(token)
+	var created time.Time
+	err = tx.QueryRowx(`SELECT Created, Data
+						FROM WebAuthnSessions
+						WHERE TokenHash=?
+						FOR UPDATE`, tokenHash).Scan(&created, &data)
+	if err == sql.ErrNoRows {
+		return nil, ErrorWebAuthnSessionInvalid.Here()
+	} else if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	err = DeleteWebAuthnSession(tx, token)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(created) > WebAuthnSessionTimeout {
+		return nil, ErrorWebAuthnSessionInvalid.Here()
+	}
+	return data, nil
+}
+
+// DeleteWebAuthnSession removes the WebAuthn ceremony, if any, for the token.
+func DeleteWebAuthnSession(tx *sqlx.Tx, token string) error {
+	_, err := tx.Exec(`DELETE FROM WebAuthnSessions WHERE TokenHash=?`,
+		hashSessionToken(token))
+	return merry.Wrap(err)
+}